@@ -1,15 +1,13 @@
 package rod_helper
 
 import (
+	"context"
 	"fmt"
 	"github.com/WQGroup/logger"
-	"github.com/go-resty/resty/v2"
 	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/launcher"
-	"github.com/mediabuyerbot/go-crx3"
 	"github.com/pkg/errors"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 )
@@ -21,13 +19,73 @@ func SwitchAdBlocker(which BlockerType) {
 	nowBlocker = which
 }
 
-// GetADBlock 根据缓存时间，每周获取一次最新的 adblock，注意需要完全关闭所有的 browser，再进行次操作
+var adblockTTL = defADBlockTTL
+
+// SetADBlockTTL 设置 adblock 插件缓存的有效期，过期后会重新下载并校验签名，失败的时候继续使用缓存，0 为不过期
+func SetADBlockTTL(ttl time.Duration) {
+	adblockTTL = ttl
+}
+
+// GetADBlock 获取 adblock 插件的 crx，已经缓存并且签名校验通过的直接使用，没有或者超过 TTL 的时候以当前浏览器的版本去下载，见 ExtensionManager
+// 注意需要完全关闭所有的 browser，再进行次操作
 func GetADBlock(cacheRootDirPath, httpProxyUrl string) (string, error) {
 
+	info, err := getADBlockCacheInfo(cacheRootDirPath, httpProxyUrl)
+	if err != nil {
+		return "", err
+	}
+	return info.CrxPath, nil
+}
+
+// GetADBlockLocalPath 获取本地的 adblock 插件解压后的路径，如果不存在会自动去远程下载
+func GetADBlockLocalPath(cacheRootDirPath, httpProxyUrl string) string {
+
+	var err error
+	var info *ExtensionCacheInfo
+	for i := 1; i <= 5; i++ {
+
+		logger.Infoln("get adblock local path start... try:", i, "time")
+		info, err = getADBlockCacheInfo(cacheRootDirPath, httpProxyUrl)
+		if err != nil {
+			logger.Errorln(fmt.Sprintf("get adblock failed %d tims: %s", i, err))
+			continue
+		}
+		break
+	}
+	if err != nil {
+		logger.Panicln("GetADBlockLocalPath: ", err)
+	}
+
+	return info.UnpackedDir
+}
+
+func getADBlockCacheInfo(cacheRootDirPath, httpProxyUrl string) (*ExtensionCacheInfo, error) {
+
 	defer func() {
 		logger.Infoln("get adblock done")
 	}()
-	nowUserData := filepath.Join(GetRodTmpRootFolder(cacheRootDirPath), RandStringBytesMaskImprSrcSB(20))
+	manager := NewExtensionManager(cacheRootDirPath)
+	manager.SetHttpProxy(httpProxyUrl)
+	manager.SetTTL(adblockTTL)
+	// 需要下载的时候才启动浏览器获取版本，才能下载到兼容的插件
+	manager.SetProdVersionFunc(func() (string, error) {
+		browserVersion, err := getBrowserVersion(cacheRootDirPath)
+		if err != nil {
+			return "", err
+		}
+		logger.Infoln("browser version: ", browserVersion)
+		return browserVersion, nil
+	})
+	return manager.Get(context.Background(), nowBlocker.ExtensionID())
+}
+
+// getBrowserVersion 启动一个浏览器获取版本号
+func getBrowserVersion(cacheRootDirPath string) (string, error) {
+
+	nowUserData, err := NewCacheLayout(cacheRootDirPath).NewUserDataDir()
+	if err != nil {
+		return "", err
+	}
 	purl := launcher.New().
 		UserDataDir(nowUserData).
 		MustLaunch()
@@ -38,6 +96,7 @@ func GetADBlock(cacheRootDirPath, httpProxyUrl string) (string, error) {
 			_ = browser.Close()
 		}
 
+		ReleaseUserDataDir(nowUserData)
 		time.AfterFunc(time.Second*5, func() {
 
 			err := os.RemoveAll(nowUserData)
@@ -55,123 +114,16 @@ func GetADBlock(cacheRootDirPath, httpProxyUrl string) (string, error) {
 	if len(versions) != 2 {
 		return "", errors.New("Chrome Version: " + browserVersion + " Can't split by '/'")
 	}
-	browserVersion = versions[1]
-	logger.Infoln("browser version: ", browserVersion)
-	// 判断插件是否已经下载
-	desFile := filepath.Join(GetADBlockFolder(cacheRootDirPath), browserVersion+".crx")
-	if IsFile(desFile) == false {
-		// || getDownloadedCacheTime(cacheRootDirPath).DownloadedTime < time.Now().AddDate(0, 0, -7).Unix()
-		// 没有下载，那么就去下载，或者下载的时间超过了一周，也需要再次下载
-		// 下载插件
-		logger.Infoln("download adblock plugin start...")
-		client := resty.New()
-		if httpProxyUrl != "" {
-			client.SetProxy(httpProxyUrl)
-		}
-		client.SetTimeout(1 * time.Minute)
-		client.SetOutputDirectory(GetADBlockFolder(cacheRootDirPath))
-
-		tmpBlockID := uBlockID
-		if nowBlocker == uBlock {
-			tmpBlockID = uBlockID
-		} else {
-			tmpBlockID = adblockID
-		}
-		adblockDownloadUrl := adblockDownloadUrl0 + browserVersion + adblockDownloadUrl1 + tmpBlockID + adblockDownloadUrl2
-		_, err = client.R().
-			SetOutput(browserVersion + ".crx").
-			Get(adblockDownloadUrl)
-		if err != nil {
-			return "", err
-		}
-
-		if IsFile(desFile) == false {
-			return "", errors.New("get adblock from web failed")
-		}
-
-		setDownloadedCacheTime(cacheRootDirPath,
-			&ADBlockCacheInfo{
-				DownloadedTime: time.Now().Unix(),
-			})
-	}
-
-	return desFile, nil
+	return versions[1], nil
 }
 
-// GetADBlockLocalPath 获取本地的 adblock 插件路径，如果不存在会自动去远程下载
-func GetADBlockLocalPath(cacheRootDirPath, httpProxyUrl string) string {
-
-	var err error
-	var desFile string
-	for i := 1; i <= 5; i++ {
-
-		logger.Infoln("get adblock local path start... try:", i, "time")
-		desFile, err = GetADBlock(cacheRootDirPath, httpProxyUrl)
-		if err != nil {
-			logger.Errorln(fmt.Sprintf("get adblock failed %d tims: %s", i, err))
-			continue
-		}
-
-		if err = crx3.Extension(desFile).Unpack(); err != nil {
-			logger.Errorln(fmt.Sprintf("unpack adblock failed %d tims: %s", i, err.Error()))
-			logger.Infoln("try to remove adblock file: ", desFile)
-			if IsFile(desFile) == true {
-				_ = os.Remove(desFile)
-			}
-			continue
-		}
-		break
-	}
-	if err != nil {
-		logger.Panicln("GetADBlockLocalPath: ", err)
-	}
+// ADBlockCacheInfo adblock 插件的缓存信息，见 ExtensionCacheInfo
+type ADBlockCacheInfo = ExtensionCacheInfo
 
-	filenameOnly := strings.TrimSuffix(filepath.Base(desFile), filepath.Ext(desFile))
+// defADBlockTTL adblock 插件默认每周更新一次
+const defADBlockTTL = 7 * 24 * time.Hour
 
-	return filepath.Join(GetADBlockFolder(cacheRootDirPath), filenameOnly)
-}
-
-func getDownloadedCacheTime(cacheRootDirPath string) *ADBlockCacheInfo {
-
-	saveFPath := filepath.Join(GetADBlockFolder(cacheRootDirPath), adblockCacheFileName)
-	if IsFile(saveFPath) == false {
-		// 需要保存一个新的
-		info := ADBlockCacheInfo{
-			DownloadedTime: time.Now().Unix(),
-		}
-		err := ToFile(saveFPath, info)
-		if err != nil {
-			logger.Panicln("save adblock cache info failed: ", err)
-		}
-		return &info
-	} else {
-		// 如果存在，那么就直接读取
-		info := ADBlockCacheInfo{}
-		err := ToStruct(saveFPath, &info)
-		if err != nil {
-			logger.Panicln("read adblock cache info failed: ", err)
-		}
-		return &info
-	}
-}
-
-func setDownloadedCacheTime(cacheRootDirPath string, info *ADBlockCacheInfo) {
-	saveFPath := filepath.Join(GetADBlockFolder(cacheRootDirPath), adblockCacheFileName)
-	err := ToFile(saveFPath, *info)
-	if err != nil {
-		logger.Panicln("save adblock cache info failed: ", err)
-	}
-}
-
-type ADBlockCacheInfo struct {
-	DownloadedTime int64
-}
-
-const adblockDownloadUrl0 = "https://clients2.google.com/service/update2/crx?response=redirect&prodversion="
-const adblockDownloadUrl1 = "&acceptformat=crx2%2Ccrx3&x=id%3D"
-const adblockDownloadUrl2 = "%26uc"
 const adblockID = "gighmmpiobklfepjocnamgkkbiglidom"
-const adblockCacheFileName = "cache_time.json"
 
 const uBlockID = "cjpalhdlnbpafiamejdnhcphjbkeiagm"
 
@@ -181,3 +133,11 @@ const (
 	AdBlock BlockerType = iota
 	uBlock
 )
+
+// ExtensionID 插件在 Chrome 应用商店中的 ID
+func (b BlockerType) ExtensionID() string {
+	if b == uBlock {
+		return uBlockID
+	}
+	return adblockID
+}
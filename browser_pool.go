@@ -0,0 +1,443 @@
+package rod_helper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// BrowserPoolConfig 浏览器实例池的设置
+type BrowserPoolConfig struct {
+	MaxPages            int           // 一个实例同时租出去的数量，也就是同时打开的页面数量上限，小于等于 0 的时候为 1
+	MaxUses             int           // 一个实例租出去多少次之后回收，小于等于 0 不限制
+	MaxAge              time.Duration // 一个实例启动多久之后回收，小于等于 0 不限制
+	HealthCheckInterval time.Duration // WarmUp 之后，后台检查空闲的实例是否还活着的间隔，小于等于 0 的时候为 30 秒
+}
+
+func NewBrowserPoolConfig(maxPages, maxUses int, maxAge time.Duration) BrowserPoolConfig {
+	return BrowserPoolConfig{MaxPages: maxPages, MaxUses: maxUses, MaxAge: maxAge}
+}
+
+func (c BrowserPoolConfig) getMaxPages() int {
+	if c.MaxPages <= 0 {
+		return 1
+	}
+	return c.MaxPages
+}
+
+func (c BrowserPoolConfig) getHealthCheckInterval() time.Duration {
+	if c.HealthCheckInterval <= 0 {
+		return defBrowserHealthCheckInterval
+	}
+	return c.HealthCheckInterval
+}
+
+// browserInstance 池中的一个浏览器实例
+type browserInstance struct {
+	info       *BrowserInfo
+	launchTime time.Time
+	inUse      int  // 正在使用的租约数量
+	uses       int  // 一共租出去的次数
+	retired    bool // 不再租出去，所有的租约归还后关闭
+}
+
+// BrowserPool 浏览器实例池，最多同时有 size 个实例，实例按需启动，也可以通过 WarmUp 预先启动
+// 一个实例可以同时租给 BrowserPoolConfig.MaxPages 个使用者，达到 MaxUses、MaxAge 或者进程已经退出的实例会被替换
+type BrowserPool struct {
+	size      int
+	config    BrowserPoolConfig
+	launch    func() (*BrowserInfo, error) // 启动一个新的实例
+	alive     func(*BrowserInfo) bool      // 实例的进程是否还活着
+	log       *logrus.Logger
+	locker    sync.Mutex
+	instances []*browserInstance
+	launching int           // 正在启动的实例数量
+	released  chan struct{} // 有租约归还或者实例被移除的时候关闭并替换，用于唤醒等待的 Acquire
+	warm      bool          // WarmUp 之后，实例被移除的时候在后台补充
+	closed    bool
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewBrowserPool size 小于等于 0 的时候为 1，launch 用于启动新的实例，比如 Pool.NewBrowser
+func NewBrowserPool(size int, config BrowserPoolConfig, launch func() (*BrowserInfo, error), log *logrus.Logger) *BrowserPool {
+	return newBrowserPool(size, config, launch, isBrowserAlive, log)
+}
+
+func newBrowserPool(size int, config BrowserPoolConfig, launch func() (*BrowserInfo, error),
+	alive func(*BrowserInfo) bool, log *logrus.Logger) *BrowserPool {
+
+	if size <= 0 {
+		size = 1
+	}
+	return &BrowserPool{
+		size:      size,
+		config:    config,
+		launch:    launch,
+		alive:     alive,
+		log:       log,
+		instances: make([]*browserInstance, 0, size),
+		released:  make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+}
+
+// Size 最多的实例数量
+func (p *BrowserPool) Size() int {
+	return p.size
+}
+
+// InstanceCount 当前的实例数量，包括等待关闭的
+func (p *BrowserPool) InstanceCount() int {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	return len(p.instances)
+}
+
+// WarmUp 预先启动实例直到 size 个，之后实例被回收或者进程退出的时候会在后台补充，并定期检查空闲的实例是否还活着
+func (p *BrowserPool) WarmUp() error {
+
+	p.locker.Lock()
+	if p.closed == true {
+		p.locker.Unlock()
+		return ErrBrowserPoolClosed
+	}
+	startHealthCheck := p.warm == false
+	p.warm = true
+	p.locker.Unlock()
+
+	if startHealthCheck == true {
+		p.wg.Add(1)
+		go p.healthCheckLoop()
+	}
+	return p.fill()
+}
+
+// Acquire 租用一个浏览器实例，没有空闲的并且实例已经达到上限的时候阻塞等待，直到 ctx 结束
+func (p *BrowserPool) Acquire(ctx context.Context) (*BrowserLease, error) {
+
+	for {
+		instance, released, err := p.tryAcquire()
+		if err != nil {
+			return nil, err
+		}
+		if instance != nil {
+			if p.alive(instance.info) == false {
+				// 进程已经退出了，移除后重新选择
+				p.log.Warningln("BrowserPool.Acquire browser is dead, replace it:", instance.info.UserDataDir)
+				p.removeInstance(instance, true)
+				continue
+			}
+			return &BrowserLease{pool: p, instance: instance, pages: make([]*rod.Page, 0)}, nil
+		}
+		if released == nil {
+			// 可以启动新的实例
+			instance, err = p.launchInstance(true)
+			if err != nil {
+				return nil, err
+			}
+			return &BrowserLease{pool: p, instance: instance, pages: make([]*rod.Page, 0)}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// tryAcquire 选择一个可以租用的实例，没有的时候如果可以启动新的实例 released 为 nil，否则返回需要等待的 released
+func (p *BrowserPool) tryAcquire() (*browserInstance, <-chan struct{}, error) {
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	if p.closed == true {
+		return nil, nil, ErrBrowserPoolClosed
+	}
+
+	now := time.Now()
+	var selected *browserInstance
+	for _, instance := range p.instances {
+		if instance.retired == false && p.expired(instance, now) == true {
+			instance.retired = true
+			p.log.Infoln("BrowserPool recycle browser, uses:", instance.uses, "age:", now.Sub(instance.launchTime))
+		}
+		if instance.retired == true || instance.inUse >= p.config.getMaxPages() {
+			continue
+		}
+		// 优先使用负载最小的实例
+		if selected == nil || instance.inUse < selected.inUse {
+			selected = instance
+		}
+	}
+	// 回收的实例如果已经没有租约了，直接关闭
+	p.closeIdleRetiredLocked()
+	if selected != nil {
+		selected.inUse++
+		selected.uses++
+		return selected, nil, nil
+	}
+	if len(p.instances)+p.launching < p.size {
+		p.launching++
+		return nil, nil, nil
+	}
+	return nil, p.released, nil
+}
+
+// launchInstance 启动一个新的实例，调用之前需要已经增加了 launching，lease 为 true 的时候直接租出去
+func (p *BrowserPool) launchInstance(lease bool) (*browserInstance, error) {
+
+	info, err := p.launch()
+
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.launching--
+	if err != nil {
+		p.notifyReleasedLocked()
+		return nil, errors.New("BrowserPool launch browser failed: " + err.Error())
+	}
+	if p.closed == true {
+		info.Close()
+		return nil, ErrBrowserPoolClosed
+	}
+	instance := &browserInstance{info: info, launchTime: time.Now()}
+	if lease == true {
+		instance.inUse = 1
+		instance.uses = 1
+	}
+	p.instances = append(p.instances, instance)
+	if lease == false {
+		p.notifyReleasedLocked()
+	}
+	return instance, nil
+}
+
+// expired 达到使用次数或者使用时间的上限
+func (p *BrowserPool) expired(instance *browserInstance, now time.Time) bool {
+	if p.config.MaxUses > 0 && instance.uses >= p.config.MaxUses {
+		return true
+	}
+	if p.config.MaxAge > 0 && now.Sub(instance.launchTime) >= p.config.MaxAge {
+		return true
+	}
+	return false
+}
+
+// release 归还一个租约，discard 为 true 的时候这个实例不再使用
+func (p *BrowserPool) release(instance *browserInstance, discard bool) {
+
+	p.locker.Lock()
+	instance.inUse--
+	if discard == true {
+		instance.retired = true
+	}
+	if instance.retired == false && p.expired(instance, time.Now()) == true {
+		instance.retired = true
+	}
+	needFill := p.closeIdleRetiredLocked()
+	p.notifyReleasedLocked()
+	p.locker.Unlock()
+
+	if needFill == true {
+		go func() {
+			_ = p.fill()
+		}()
+	}
+}
+
+// removeInstance 移除一个实例，decUse 为 true 的时候这个实例在 tryAcquire 中已经被租出去了
+func (p *BrowserPool) removeInstance(instance *browserInstance, decUse bool) {
+
+	p.locker.Lock()
+	if decUse == true {
+		instance.inUse--
+	}
+	instance.retired = true
+	needFill := p.closeIdleRetiredLocked()
+	p.notifyReleasedLocked()
+	p.locker.Unlock()
+
+	if needFill == true {
+		go func() {
+			_ = p.fill()
+		}()
+	}
+}
+
+// closeIdleRetiredLocked 关闭已经回收并且没有租约的实例，返回是否需要补充实例，需要在 locker 中调用
+func (p *BrowserPool) closeIdleRetiredLocked() bool {
+
+	keepInstances := p.instances[:0]
+	removed := false
+	for _, instance := range p.instances {
+		if instance.retired == true && instance.inUse <= 0 {
+			// 关闭浏览器会等待进程退出，不在锁中进行
+			go instance.info.Close()
+			removed = true
+			continue
+		}
+		keepInstances = append(keepInstances, instance)
+	}
+	p.instances = keepInstances
+	return removed == true && p.warm == true && p.closed == false
+}
+
+// fill WarmUp 之后，补充实例直到 size 个
+func (p *BrowserPool) fill() error {
+
+	for {
+		p.locker.Lock()
+		if p.closed == true || len(p.instances)+p.launching >= p.size {
+			p.locker.Unlock()
+			return nil
+		}
+		p.launching++
+		p.locker.Unlock()
+
+		_, err := p.launchInstance(false)
+		if err != nil {
+			p.log.Warningln("BrowserPool.fill", err)
+			return err
+		}
+	}
+}
+
+// healthCheckLoop 定期检查空闲的实例，进程已经退出的移除并补充
+func (p *BrowserPool) healthCheckLoop() {
+
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.config.getHealthCheckInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.locker.Lock()
+		idleInstances := make([]*browserInstance, 0)
+		for _, instance := range p.instances {
+			if instance.retired == false && instance.inUse == 0 {
+				idleInstances = append(idleInstances, instance)
+			}
+		}
+		p.locker.Unlock()
+		for _, instance := range idleInstances {
+			if p.alive(instance.info) == false {
+				p.log.Warningln("BrowserPool health check browser is dead, replace it:", instance.info.UserDataDir)
+				p.removeInstance(instance, false)
+			}
+		}
+		_ = p.fill()
+	}
+}
+
+// notifyReleasedLocked 唤醒等待的 Acquire，需要在 locker 中调用
+func (p *BrowserPool) notifyReleasedLocked() {
+	close(p.released)
+	p.released = make(chan struct{})
+}
+
+// Close 关闭所有的实例，还没有归还的租约归还的时候再关闭
+func (p *BrowserPool) Close() {
+
+	p.locker.Lock()
+	if p.closed == true {
+		p.locker.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	for _, instance := range p.instances {
+		instance.retired = true
+	}
+	p.closeIdleRetiredLocked()
+	p.notifyReleasedLocked()
+	p.locker.Unlock()
+
+	p.wg.Wait()
+}
+
+// isBrowserAlive 通过 CDP 获取版本信息，失败说明进程已经退出或者无响应
+func isBrowserAlive(info *BrowserInfo) bool {
+	if info == nil || info.Browser == nil {
+		return false
+	}
+	_, err := info.Browser.Timeout(browserAliveTimeOut).Version()
+	return err == nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// BrowserLease 租用的浏览器实例，使用完毕后必须调用 Release 或者 Discard 归还，通过 NewPage 打开的页面会在归还的时候关闭
+type BrowserLease struct {
+	pool     *BrowserPool
+	instance *browserInstance
+	pages    []*rod.Page
+	released bool
+	locker   sync.Mutex
+}
+
+// Browser 租用的浏览器实例，不要关闭它
+func (l *BrowserLease) Browser() *BrowserInfo {
+	return l.instance.info
+}
+
+// NewPage 在租用的实例中打开一个新的页面
+func (l *BrowserLease) NewPage() (*rod.Page, error) {
+
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	if l.released == true {
+		return nil, ErrBrowserLeaseReleased
+	}
+	page, err := NewPage(l.instance.info.Browser)
+	if err != nil {
+		return nil, err
+	}
+	l.pages = append(l.pages, page)
+	return page, nil
+}
+
+// Release 归还租约，重复调用无影响
+func (l *BrowserLease) Release() {
+	l.finish(false)
+}
+
+// Discard 这个实例已经不可用了，比如页面崩溃，归还租约并且不再使用这个实例
+func (l *BrowserLease) Discard() {
+	l.finish(true)
+}
+
+func (l *BrowserLease) finish(discard bool) {
+
+	l.locker.Lock()
+	if l.released == true {
+		l.locker.Unlock()
+		return
+	}
+	l.released = true
+	pages := l.pages
+	l.pages = nil
+	l.locker.Unlock()
+
+	for _, page := range pages {
+		_ = page.Close()
+	}
+	l.pool.release(l.instance, discard)
+}
+
+var (
+	ErrBrowserPoolClosed    = errors.New("browser pool is closed")
+	ErrBrowserLeaseReleased = errors.New("browser lease is released")
+)
+
+const (
+	defBrowserHealthCheckInterval = 30 * time.Second
+	browserAliveTimeOut           = 5 * time.Second
+)
@@ -0,0 +1,139 @@
+package rod_helper
+
+import (
+	"context"
+	"fmt"
+	"github.com/WQGroup/logger"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBrowserPool(t *testing.T) {
+
+	// 不启动真实的浏览器，用 UserDataDir 区分实例
+	var locker sync.Mutex
+	launchCount := 0
+	deadDirs := make(map[string]bool)
+	launch := func() (*BrowserInfo, error) {
+		locker.Lock()
+		defer locker.Unlock()
+		launchCount++
+		return &BrowserInfo{UserDataDir: fmt.Sprintf("browser-%d", launchCount)}, nil
+	}
+	alive := func(info *BrowserInfo) bool {
+		locker.Lock()
+		defer locker.Unlock()
+		return deadDirs[info.UserDataDir] == false
+	}
+	getLaunchCount := func() int {
+		locker.Lock()
+		defer locker.Unlock()
+		return launchCount
+	}
+
+	p := newBrowserPool(2, BrowserPoolConfig{MaxPages: 1, MaxUses: 2}, launch, alive, logger.GetLogger())
+	defer p.Close()
+
+	leaseA, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaseB, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaseA.Browser() == leaseB.Browser() || getLaunchCount() != 2 {
+		t.Fatal("each instance should only have one page", getLaunchCount())
+	}
+
+	// 实例都在使用中，需要等待
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	_, err = p.Acquire(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatal("acquire should wait", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		leaseA.Release()
+	}()
+	leaseC, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaseC.Browser() != leaseA.Browser() {
+		t.Fatal("released instance should be reused")
+	}
+
+	// 使用了两次的实例被回收，再租用的时候启动新的实例
+	leaseC.Release()
+	if p.InstanceCount() != 1 {
+		t.Fatal("instance should be recycled after MaxUses", p.InstanceCount())
+	}
+	leaseD, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getLaunchCount() != 3 {
+		t.Fatal("recycled instance should be replaced", getLaunchCount())
+	}
+	leaseD.Release()
+
+	// 进程退出的实例被替换
+	leaseB.Release()
+	locker.Lock()
+	deadDirs[leaseB.Browser().UserDataDir] = true
+	locker.Unlock()
+	leaseE, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaseE.Browser().UserDataDir == leaseB.Browser().UserDataDir {
+		t.Fatal("dead instance should not be leased")
+	}
+	leaseE.Release()
+	leaseE.Release()
+
+	p.Close()
+	_, err = p.Acquire(context.Background())
+	if err != ErrBrowserPoolClosed {
+		t.Fatal("acquire after close error", err)
+	}
+}
+
+func TestBrowserPoolWarmUp(t *testing.T) {
+
+	var locker sync.Mutex
+	launchCount := 0
+	launch := func() (*BrowserInfo, error) {
+		locker.Lock()
+		defer locker.Unlock()
+		launchCount++
+		return &BrowserInfo{UserDataDir: fmt.Sprintf("browser-%d", launchCount)}, nil
+	}
+	p := newBrowserPool(3, BrowserPoolConfig{MaxPages: 2, MaxUses: 1}, launch, func(*BrowserInfo) bool { return true }, logger.GetLogger())
+	defer p.Close()
+
+	err := p.WarmUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.InstanceCount() != 3 {
+		t.Fatal("warm up instance count error", p.InstanceCount())
+	}
+	lease, err := p.Acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 回收后在后台补充
+	lease.Release()
+	for i := 0; i < 50 && p.InstanceCount() < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	locker.Lock()
+	defer locker.Unlock()
+	if p.InstanceCount() != 3 || launchCount != 4 {
+		t.Fatal("recycled instance should be refilled", p.InstanceCount(), launchCount)
+	}
+}
@@ -0,0 +1,273 @@
+package rod_helper
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CacheLayout 缓存根目录下的目录布局，rod 的用户数据、插件、代理缓存、UA 缓存、报告都在这里
+//
+//	root
+//	├── rod          每个浏览器实例一个用户数据目录，关闭后删除
+//	├── Plugin
+//	│   ├── adblock
+//	│   └── extensions   ExtensionManager 缓存的插件
+//	├── proxy_cache  过滤结果、节点状态
+//	├── reports      Filter 报告
+//	└── ua           UA 缓存
+type CacheLayout struct {
+	rootDirPath string
+	quotaBytes  int64 // 缓存总大小的上限，小于等于 0 不限制
+}
+
+// NewCacheLayout rootDirPath 为空的时候使用当前目录
+func NewCacheLayout(rootDirPath string) *CacheLayout {
+	if rootDirPath == "" {
+		rootDirPath = "."
+	}
+	return &CacheLayout{rootDirPath: rootDirPath}
+}
+
+func (c *CacheLayout) RootDirPath() string {
+	return c.rootDirPath
+}
+
+// RodUserDataDir 浏览器实例的用户数据目录的上一级
+func (c *CacheLayout) RodUserDataDir() string {
+	return filepath.Join(c.rootDirPath, RodCacheFolder)
+}
+
+func (c *CacheLayout) PluginDir() string {
+	return filepath.Join(c.rootDirPath, PluginFolder)
+}
+
+func (c *CacheLayout) ADBlockDir() string {
+	return filepath.Join(c.PluginDir(), ADBlockFolder)
+}
+
+// ExtensionDir ExtensionManager 缓存插件的目录
+func (c *CacheLayout) ExtensionDir() string {
+	return filepath.Join(c.PluginDir(), ExtensionFolder)
+}
+
+func (c *CacheLayout) ADBlockUnZipDir() string {
+	return filepath.Join(c.ADBlockDir(), ADBlockUnZipFolder)
+}
+
+func (c *CacheLayout) ProxyCacheDir() string {
+	return filepath.Join(c.rootDirPath, ProxyCacheFolder)
+}
+
+func (c *CacheLayout) ReportDir() string {
+	return filepath.Join(c.rootDirPath, ReportFolder)
+}
+
+func (c *CacheLayout) UACacheDir() string {
+	return filepath.Join(c.rootDirPath, UACacheFolder)
+}
+
+// EnsureDirs 新建所有的目录
+func (c *CacheLayout) EnsureDirs() error {
+
+	for _, dirPath := range []string{c.RodUserDataDir(), c.ADBlockUnZipDir(), c.ProxyCacheDir(), c.ReportDir(), c.UACacheDir()} {
+		err := os.MkdirAll(dirPath, os.ModePerm)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetQuota 设置缓存总大小的上限，单位是字节，小于等于 0 不限制，见 Cleanup
+func (c *CacheLayout) SetQuota(quotaBytes int64) {
+	c.quotaBytes = quotaBytes
+}
+
+func (c *CacheLayout) Quota() int64 {
+	return c.quotaBytes
+}
+
+// NewUserDataDir 新建一个浏览器实例的用户数据目录，在 ReleaseUserDataDir 之前 Cleanup 不会删除它
+func (c *CacheLayout) NewUserDataDir() (string, error) {
+
+	userDataDir := filepath.Join(c.RodUserDataDir(), RandStringBytesMaskImprSrcSB(20))
+	err := os.MkdirAll(userDataDir, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	markUserDataDirActive(userDataDir)
+	return userDataDir, nil
+}
+
+// ReleaseUserDataDir 浏览器已经关闭，这个用户数据目录可以被 Cleanup 删除了
+func ReleaseUserDataDir(userDataDir string) {
+
+	activeUserDataLocker.Lock()
+	defer activeUserDataLocker.Unlock()
+	delete(activeUserDataDirs, absPath(userDataDir))
+}
+
+// DiskUsage 缓存根目录下所有文件的总大小
+func (c *CacheLayout) DiskUsage() (int64, error) {
+	return dirSize(c.rootDirPath)
+}
+
+// CacheCleanupResult Cleanup 的结果
+type CacheCleanupResult struct {
+	RemovedDirs []string // 删除的用户数据目录
+	UsageBytes  int64    // 清理后缓存的总大小
+}
+
+// Cleanup 删除超过 staleAfter 没有变化、并且不是这个进程正在使用的用户数据目录，通常是崩溃的进程留下的
+// 设置了 Quota 的时候，超出上限还会从旧到新继续删除不在使用的用户数据目录，依然超出的时候返回 ErrCacheQuotaExceeded
+// 多个进程共用一个缓存根目录的时候，staleAfter 需要大于浏览器实例的使用时间，避免删除其他进程正在使用的目录
+func (c *CacheLayout) Cleanup(staleAfter time.Duration) (*CacheCleanupResult, error) {
+
+	result := &CacheCleanupResult{RemovedDirs: make([]string, 0)}
+	entries, err := os.ReadDir(c.RodUserDataDir())
+	if err != nil && os.IsNotExist(err) == false {
+		return nil, err
+	}
+	type userDataDir struct {
+		dirPath string
+		modTime time.Time
+	}
+	now := time.Now()
+	remainDirs := make([]userDataDir, 0)
+	for _, entry := range entries {
+		if entry.IsDir() == false {
+			continue
+		}
+		dirPath := filepath.Join(c.RodUserDataDir(), entry.Name())
+		if isUserDataDirActive(dirPath) == true {
+			continue
+		}
+		modTime, err := latestModTime(dirPath)
+		if err != nil {
+			continue
+		}
+		if now.Sub(modTime) < staleAfter {
+			remainDirs = append(remainDirs, userDataDir{dirPath: dirPath, modTime: modTime})
+			continue
+		}
+		if os.RemoveAll(dirPath) == nil {
+			result.RemovedDirs = append(result.RemovedDirs, dirPath)
+		}
+	}
+
+	result.UsageBytes, err = c.DiskUsage()
+	if err != nil {
+		return nil, err
+	}
+	if c.quotaBytes <= 0 || result.UsageBytes <= c.quotaBytes {
+		return result, nil
+	}
+	// 超出上限，从旧到新删除，刚刚还在变化的目录可能是其他进程正在使用的，不删除
+	sort.Slice(remainDirs, func(i, j int) bool {
+		return remainDirs[i].modTime.Before(remainDirs[j].modTime)
+	})
+	for _, remainDir := range remainDirs {
+		if result.UsageBytes <= c.quotaBytes || now.Sub(remainDir.modTime) < minUserDataDirAge {
+			break
+		}
+		dirBytes, _ := dirSize(remainDir.dirPath)
+		if os.RemoveAll(remainDir.dirPath) == nil {
+			result.RemovedDirs = append(result.RemovedDirs, remainDir.dirPath)
+			result.UsageBytes -= dirBytes
+		}
+	}
+	if result.UsageBytes > c.quotaBytes {
+		return result, ErrCacheQuotaExceeded
+	}
+	return result, nil
+}
+
+var ErrCacheQuotaExceeded = errors.New("cache quota exceeded")
+
+// 这个进程正在使用的用户数据目录
+var (
+	activeUserDataLocker sync.Mutex
+	activeUserDataDirs   = make(map[string]bool)
+)
+
+func markUserDataDirActive(userDataDir string) {
+
+	activeUserDataLocker.Lock()
+	defer activeUserDataLocker.Unlock()
+	activeUserDataDirs[absPath(userDataDir)] = true
+}
+
+func isUserDataDirActive(userDataDir string) bool {
+
+	activeUserDataLocker.Lock()
+	defer activeUserDataLocker.Unlock()
+	return activeUserDataDirs[absPath(userDataDir)]
+}
+
+func absPath(fPath string) string {
+	nowAbsPath, err := filepath.Abs(fPath)
+	if err != nil {
+		return filepath.Clean(fPath)
+	}
+	return nowAbsPath
+}
+
+// latestModTime 目录本身以及第一层的文件、目录中最新的修改时间
+func latestModTime(dirPath string) (time.Time, error) {
+
+	dirInfo, err := os.Stat(dirPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	modTime := dirInfo.ModTime()
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return modTime, nil
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && info.ModTime().After(modTime) == true {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+func dirSize(dirPath string) (int64, error) {
+
+	var size int64
+	err := filepath.WalkDir(dirPath, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 遍历的时候被其他进程删除了
+			if os.IsNotExist(err) == true {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() == true {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil && os.IsNotExist(err) == true {
+		return 0, nil
+	}
+	return size, err
+}
+
+const (
+	defUserDataStaleTime = 6 * time.Hour    // 超过这个时间没有变化的用户数据目录视为崩溃的进程留下的
+	minUserDataDirAge    = 10 * time.Minute // 超出上限的时候，也不会删除这个时间内还在变化的用户数据目录
+	cacheQuotaCheckTime  = time.Minute      // 设置了上限的时候，新建浏览器之前最多多久检查一次
+)
@@ -0,0 +1,75 @@
+package rod_helper
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheLayoutCleanup(t *testing.T) {
+
+	rootDir, err := os.MkdirTemp("", "cache_layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(rootDir)
+	}()
+	layout := NewCacheLayout(rootDir)
+	err = layout.EnsureDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.ProxyCacheDir() != filepath.Join(rootDir, ProxyCacheFolder) || IsDir(layout.UACacheDir()) == false {
+		t.Fatal("cache layout dirs error")
+	}
+
+	newUserDataDir := func(active bool, modTime time.Time, fileSize int) string {
+		userDataDir, err := layout.NewUserDataDir()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fPath := filepath.Join(userDataDir, "Preferences")
+		err = os.WriteFile(fPath, make([]byte, fileSize), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(fPath, modTime, modTime)
+		_ = os.Chtimes(userDataDir, modTime, modTime)
+		if active == false {
+			ReleaseUserDataDir(userDataDir)
+		}
+		return userDataDir
+	}
+	now := time.Now()
+	// 崩溃留下的、这个进程正在使用的、刚刚关闭的
+	crashedDir := newUserDataDir(false, now.Add(-2*time.Hour), 10)
+	activeDir := newUserDataDir(true, now.Add(-2*time.Hour), 10)
+	recentDir := newUserDataDir(false, now.Add(-20*time.Minute), 1000)
+	defer ReleaseUserDataDir(activeDir)
+
+	result, err := layout.Cleanup(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.RemovedDirs) != 1 || result.RemovedDirs[0] != crashedDir || IsDir(activeDir) == false || IsDir(recentDir) == false {
+		t.Fatal("cleanup stale user data error", result.RemovedDirs)
+	}
+
+	// 超出上限，不在使用的目录也会被删除，正在使用的依然保留
+	layout.SetQuota(100)
+	result, err = layout.Cleanup(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.RemovedDirs) != 1 || IsDir(recentDir) == true || IsDir(activeDir) == false || result.UsageBytes > 100 {
+		t.Fatal("cleanup over quota error", result.RemovedDirs, result.UsageBytes)
+	}
+	layout.SetQuota(1)
+	_, err = layout.Cleanup(time.Hour)
+	if errors.Is(err, ErrCacheQuotaExceeded) == false {
+		t.Fatal("quota exceeded error", err)
+	}
+}
@@ -0,0 +1,190 @@
+package rod_helper
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState 熔断器的状态
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota + 1 // 正常使用
+	CircuitOpen                             // 熔断中，冷却结束前不使用
+	CircuitHalfOpen                         // 冷却结束，需要探测通过才能恢复使用
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "Closed"
+	case CircuitOpen:
+		return "Open"
+	case CircuitHalfOpen:
+		return "HalfOpen"
+	default:
+		return "Unknown"
+	}
+}
+
+// CircuitBreakerConfig 熔断器的设置
+type CircuitBreakerConfig struct {
+	FailureThreshold int           // 连续失败多少次后熔断
+	OpenDuration     time.Duration // 熔断后冷却多久进入半开状态
+}
+
+func NewCircuitBreakerConfig(failureThreshold int, openDuration time.Duration) CircuitBreakerConfig {
+	return CircuitBreakerConfig{FailureThreshold: failureThreshold, OpenDuration: openDuration}
+}
+
+// CircuitBreaker 以 (节点, KeyName) 为单位的熔断器，一个节点被某个网站封禁不会影响它在其他 KeyName 中的使用
+type CircuitBreaker struct {
+	config   CircuitBreakerConfig
+	circuits map[circuitKey]*circuit
+	locker   sync.Mutex
+}
+
+type circuitKey struct {
+	proxyID string
+	keyName string
+}
+
+type circuit struct {
+	failures  int       // 连续失败的次数
+	openUntil time.Time // 冷却结束的时间，为零值表示没有熔断
+	probing   bool      // 半开状态下是否正在探测
+}
+
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = defFailureThreshold
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = defOpenDuration
+	}
+	return &CircuitBreaker{
+		config:   config,
+		circuits: make(map[circuitKey]*circuit),
+	}
+}
+
+// State 获取当前的状态
+func (c *CircuitBreaker) State(proxyID, keyName string) CircuitState {
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	nowCircuit, found := c.circuits[circuitKey{proxyID, keyName}]
+	if found == false || nowCircuit.openUntil.IsZero() == true {
+		return CircuitClosed
+	}
+	if time.Now().Before(nowCircuit.openUntil) == true {
+		return CircuitOpen
+	}
+	return CircuitHalfOpen
+}
+
+// Allow 是否可以使用这个节点。熔断中的时候返回冷却结束的时间；
+// 冷却已经结束的时候 needProbe 为 true，调用者需要探测后通过 RecordSuccess、RecordFailure 反馈，探测结束之前不会再次要求探测
+func (c *CircuitBreaker) Allow(proxyID, keyName string) (allowed bool, retryTime time.Time, needProbe bool) {
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	nowCircuit, found := c.circuits[circuitKey{proxyID, keyName}]
+	if found == false || nowCircuit.openUntil.IsZero() == true {
+		return true, time.Time{}, false
+	}
+	if time.Now().Before(nowCircuit.openUntil) == true {
+		return false, nowCircuit.openUntil, false
+	}
+	// 半开状态
+	if nowCircuit.probing == true {
+		return false, time.Time{}, false
+	}
+	nowCircuit.probing = true
+	return false, time.Time{}, true
+}
+
+// RecordSuccess 成功后恢复为正常状态
+func (c *CircuitBreaker) RecordSuccess(proxyID, keyName string) {
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	delete(c.circuits, circuitKey{proxyID, keyName})
+}
+
+// RecordFailure 记录一次失败，连续失败达到阈值或者半开探测失败的时候熔断
+func (c *CircuitBreaker) RecordFailure(proxyID, keyName string) CircuitState {
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	nowCircuit := c.getCircuit(proxyID, keyName)
+	nowCircuit.failures++
+	if nowCircuit.openUntil.IsZero() == false || nowCircuit.failures >= c.config.FailureThreshold {
+		c.open(nowCircuit, c.config.OpenDuration)
+		return CircuitOpen
+	}
+	return CircuitClosed
+}
+
+// Trip 立即熔断，openDuration 小于等于 0 的时候使用设置的冷却时间
+func (c *CircuitBreaker) Trip(proxyID, keyName string, openDuration time.Duration) {
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if openDuration <= 0 {
+		openDuration = c.config.OpenDuration
+	}
+	c.open(c.getCircuit(proxyID, keyName), openDuration)
+}
+
+// openCircuits 获取所有还在熔断中的 (节点, KeyName) 以及冷却结束的 UnixTime，保存节点状态的时候用
+func (c *CircuitBreaker) openCircuits(now time.Time) map[string]map[string]int64 {
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	outCircuits := make(map[string]map[string]int64)
+	for nowKey, nowCircuit := range c.circuits {
+		if nowCircuit.openUntil.After(now) == false {
+			continue
+		}
+		if _, found := outCircuits[nowKey.proxyID]; found == false {
+			outCircuits[nowKey.proxyID] = make(map[string]int64)
+		}
+		outCircuits[nowKey.proxyID][nowKey.keyName] = nowCircuit.openUntil.Unix()
+	}
+	return outCircuits
+}
+
+// restore 恢复保存的熔断，已经熔断到更晚时间的不会被覆盖
+func (c *CircuitBreaker) restore(proxyID, keyName string, openUntil time.Time) {
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	nowCircuit := c.getCircuit(proxyID, keyName)
+	if nowCircuit.openUntil.Before(openUntil) == true {
+		nowCircuit.openUntil = openUntil
+		nowCircuit.probing = false
+	}
+}
+
+func (c *CircuitBreaker) getCircuit(proxyID, keyName string) *circuit {
+
+	nowKey := circuitKey{proxyID, keyName}
+	nowCircuit, found := c.circuits[nowKey]
+	if found == false {
+		nowCircuit = &circuit{}
+		c.circuits[nowKey] = nowCircuit
+	}
+	return nowCircuit
+}
+
+func (c *CircuitBreaker) open(nowCircuit *circuit, openDuration time.Duration) {
+	nowCircuit.openUntil = time.Now().Add(openDuration)
+	nowCircuit.probing = false
+}
+
+const (
+	defFailureThreshold = 3
+	defOpenDuration     = 10 * time.Minute
+)
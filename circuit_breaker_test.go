@@ -0,0 +1,41 @@
+package rod_helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+
+	c := NewCircuitBreaker(NewCircuitBreakerConfig(2, 50*time.Millisecond))
+	c.RecordFailure("node", "imdb")
+	if c.State("node", "imdb") != CircuitClosed {
+		t.Fatal("should still closed")
+	}
+	c.RecordFailure("node", "imdb")
+	if c.State("node", "imdb") != CircuitOpen {
+		t.Fatal("should open")
+	}
+	// 其他 KeyName 不受影响
+	if allowed, _, _ := c.Allow("node", "douban"); allowed == false {
+		t.Fatal("other key should allowed")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	allowed, _, needProbe := c.Allow("node", "imdb")
+	if allowed == true || needProbe == false || c.State("node", "imdb") != CircuitHalfOpen {
+		t.Fatal("should half open and need probe")
+	}
+	if _, _, needProbe = c.Allow("node", "imdb"); needProbe == true {
+		t.Fatal("only one probe at a time")
+	}
+	// 探测失败再次熔断
+	c.RecordFailure("node", "imdb")
+	if c.State("node", "imdb") != CircuitOpen {
+		t.Fatal("probe failed should open again")
+	}
+	c.RecordSuccess("node", "imdb")
+	if c.State("node", "imdb") != CircuitClosed {
+		t.Fatal("should closed")
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"github.com/pkg/errors"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 var pool *rod_helper.Pool
@@ -46,8 +49,15 @@ func main() {
 		}
 	}()
 
-	// 阻塞
-	select {}
+	// 阻塞，退出的时候需要 Close，保存节点的封禁、熔断等状态
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	logger.Infoln("Shutdown Http Server ...")
+	if err := srv.Close(); err != nil {
+		logger.Errorln("Close Server Error:", err)
+	}
+	pool.Close()
 }
 
 // AddFilterTaskHandler 添加一个任务
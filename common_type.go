@@ -2,6 +2,7 @@ package rod_helper
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,19 +22,52 @@ type OpenResult struct {
 
 type XrayPoolProxyInfo struct {
 	Index          int
-	Name           string `json:"name"`
-	ProtoModel     string `json:"proto_model"`
-	SocksUrl       string `json:"socks_url"`
-	HttpUrl        string `json:"http_url"`
-	FirTimeAccess  bool   `json:"first_time_access"` // 这个节点第一次被访问
-	skipAccessTime int64  // 如果当前时间大于这个时间，这个节点才可以被访问
-	lastAccessTime int64  // 最后的访问时间
+	ID             string       `json:"id"` // 节点的稳定标识，见 NewProxyID
+	Name           string       `json:"name"`
+	ProtoModel     string       `json:"proto_model"`
+	SocksUrl       string       `json:"socks_url"`
+	HttpUrl        string       `json:"http_url"`
+	FirTimeAccess  bool         `json:"first_time_access"` // 这个节点第一次被访问
+	skipAccessTime int64        // 如果当前时间大于这个时间，这个节点才可以被访问
+	lastAccessTime int64        // 最后的访问时间
+	nextAccessTime time.Time    // GetProxyInfoSync 分配这个节点的时候决定的下一次可以使用的时间
+	exitIP         atomic.Value // 出口 IP，string，FilterInfo.VerifyExitIP 的时候解析，可能在 Filter 的同时读取，使用 GetExitIP、setExitIP
+	anonymity      int32        // 匿名程度 AnonymityLevel，见 Pool.AuditProxy，可能在检查的同时读取，使用 GetAnonymity、setAnonymity
+	leased         bool         // 是否被租约独占
+	stats          ProxyStats   // 租约反馈的统计信息
 }
 
 func (x *XrayPoolProxyInfo) GetLastAccessTime() int64 {
 	return x.lastAccessTime
 }
 
+// GetExitIP 出口 IP，还没有解析过的时候为空
+func (x *XrayPoolProxyInfo) GetExitIP() string {
+	exitIP, _ := x.exitIP.Load().(string)
+	return exitIP
+}
+
+func (x *XrayPoolProxyInfo) setExitIP(exitIP string) {
+	x.exitIP.Store(exitIP)
+}
+
+// GetAnonymity 匿名程度，还没有检查过的时候为 AnonymityUnknown
+func (x *XrayPoolProxyInfo) GetAnonymity() AnonymityLevel {
+	return AnonymityLevel(atomic.LoadInt32(&x.anonymity))
+}
+
+func (x *XrayPoolProxyInfo) setAnonymity(level AnonymityLevel) {
+	atomic.StoreInt32(&x.anonymity, int32(level))
+}
+
+// ProxyUrl 优先使用 http 代理，没有的时候使用 socks5 代理
+func (x *XrayPoolProxyInfo) ProxyUrl() string {
+	if x.HttpUrl != "" {
+		return x.HttpUrl
+	}
+	return x.SocksUrl
+}
+
 const (
 	httpPrefix  = "http://"
 	socksPrefix = "socks5://"
@@ -61,14 +95,97 @@ func (s PageCheck) String() string {
 }
 
 type FilterInfo struct {
-	KeyName   string     // 这次目标网站的关键词
-	PageInfos []PageInfo // 需要测试的 UrlInfo
+	KeyName      string        // 这次目标网站的关键词
+	PageInfos    []PageInfo    // 需要测试的 UrlInfo
+	Stages       []FilterStage // 分阶段测试，为空的时候只有一个阶段，使用 Filter 传入的 threadSize、loadType
+	CacheTTL     time.Duration // 过滤结果的有效期，为 0 的时候使用一天
+	Incremental  bool          // 增量模式，只重新测试失败的、新增的以及快要过期的节点，其他通过的节点保留
+	NearExpiry   time.Duration // 增量模式下，通过测试的时间距离过期小于这个时间的节点需要重新测试，为 0 的时候使用 CacheTTL 的四分之一
+	MinPassRatio float64       // 一个阶段中除了通过所有非 Optional 的页面，通过的页面的权重占比还需要不小于这个值，为 0 的时候不要求，但至少需要通过一个页面
+	KeepFastest  int           // 大于 0 的时候，所有阶段都通过的节点按照平均耗时只保留最快的这么多个，增量模式下保留的节点优先占用名额
+	VerifyExitIP bool          // 是否解析所有阶段都通过的节点的出口 IP，选择节点的时候会避免连续给出同一个出口的节点
+	ExitIPSites  []string      // 解析出口 IP 使用的网站，为空的时候使用 GetPublicIP 默认的网站
+	ExitIPPrefix int           // 出口 IP 分组使用的前缀长度，比如 24 表示同一个 /24 网段的是一组，为 0 的时候按照完整的 IP 分组
+	DedupExitIP  bool          // 同一组出口的节点只保留最快的一个，需要同时设置 VerifyExitIP
+
+	MinAnonymity     AnonymityLevel // 大于 AnonymityUnknown 的时候，所有阶段都通过的节点还需要通过 AnonymityEchoUrl 检查匿名程度，低于这个等级的淘汰
+	AnonymityEchoUrl string         // 检查匿名程度使用的回显服务，见 NewProxyEchoHandler
+	AnonymityRealIP  string         // 本机真实的 IP，为空的时候不使用代理访问 AnonymityEchoUrl 获取
 }
 
 func NewFilterInfo(key string, needTestUrlInfos []PageInfo) *FilterInfo {
 	return &FilterInfo{KeyName: key, PageInfos: needTestUrlInfos}
 }
 
+// NewTwoStageFilterInfo 先使用 http client 快速测试全部的节点，通过的节点再使用浏览器验证
+func NewTwoStageFilterInfo(key string, needTestUrlInfos []PageInfo, httpThreadSize, browserThreadSize int) *FilterInfo {
+	fInfo := NewFilterInfo(key, needTestUrlInfos)
+	fInfo.Stages = []FilterStage{
+		{LoadType: WebPageWithHttpClient, ThreadSize: httpThreadSize},
+		{LoadType: WebPageWithBrowser, ThreadSize: browserThreadSize},
+	}
+	return fInfo
+}
+
+// GetStages 获取需要进行的测试阶段，没有设置并发数的阶段使用 threadSize
+func (f *FilterInfo) GetStages(threadSize int, loadType TryLoadType) []FilterStage {
+
+	if len(f.Stages) < 1 {
+		return []FilterStage{{LoadType: loadType, ThreadSize: threadSize}}
+	}
+	stages := make([]FilterStage, 0, len(f.Stages))
+	for _, stage := range f.Stages {
+		if stage.ThreadSize <= 0 {
+			stage.ThreadSize = threadSize
+		}
+		stages = append(stages, stage)
+	}
+	return stages
+}
+
+// FilterStage Filter 的一个测试阶段，只有通过了上一个阶段的节点才会进入下一个阶段
+type FilterStage struct {
+	LoadType    TryLoadType // 这个阶段测试的方式
+	ThreadSize  int         // 这个阶段的并发数，为 0 的时候使用 Filter 传入的 threadSize
+	PageTimeOut int         // 这个阶段每个页面的超时时间，单位是秒，为 0 的时候使用 PageInfo 中的设置
+	PageInfos   []PageInfo  // 这个阶段需要通过的页面，为空的时候使用 FilterInfo.PageInfos
+}
+
+// GetPageInfos 这个阶段需要通过的页面
+func (s FilterStage) GetPageInfos(defPageInfos []PageInfo) []PageInfo {
+
+	pageInfos := s.PageInfos
+	if len(pageInfos) < 1 {
+		pageInfos = defPageInfos
+	}
+	if s.PageTimeOut <= 0 {
+		return pageInfos
+	}
+	stagePageInfos := make([]PageInfo, 0, len(pageInfos))
+	for _, pageInfo := range pageInfos {
+		pageInfo.PageTimeOut = s.PageTimeOut
+		stagePageInfos = append(stagePageInfos, pageInfo)
+	}
+	return stagePageInfos
+}
+
+// GetCacheTTL 过滤结果的有效期
+func (f *FilterInfo) GetCacheTTL() time.Duration {
+	if f.CacheTTL <= 0 {
+		return defFilterCacheTTL
+	}
+	return f.CacheTTL
+}
+
+// GetNearExpiry 增量模式下，距离过期多久的节点需要重新测试
+func (f *FilterInfo) GetNearExpiry() time.Duration {
+	ttl := f.GetCacheTTL()
+	if f.NearExpiry <= 0 || f.NearExpiry > ttl {
+		return ttl / 4
+	}
+	return f.NearExpiry
+}
+
 type PageInfo struct {
 	Name               string            // 这个页面的目标是干什么
 	Url                string            // 这个页面的 Url
@@ -76,12 +193,24 @@ type PageInfo struct {
 	Header             map[string]string // 这个页面的 Header
 	SuccessWord        []string          // 为空的时候无需检测
 	ExistElementXPaths []string          // 必须存在的元素 XPath
+	Weight             float64           // Filter 计算通过比例时这个页面的权重，为 0 的时候是 1
+	Optional           bool              // Filter 时可选的页面，失败了不会直接淘汰节点，只影响通过比例，一个阶段全部是可选的页面的时候至少需要通过一个
+	RetryCount         int               // Filter 时失败后重试的次数，每次重试都会使用新的 http client 或者新的 page
+	RetryInterval      int               // 两次重试之间等待的时间，单位是秒
 }
 
 func (p PageInfo) GetPageTimeOut() time.Duration {
 	return time.Duration(p.PageTimeOut) * time.Second
 }
 
+// GetWeight Filter 计算通过比例时这个页面的权重
+func (p PageInfo) GetWeight() float64 {
+	if p.Weight <= 0 {
+		return 1
+	}
+	return p.Weight
+}
+
 func (p PageInfo) HasSuccessWord() bool {
 	if p.SuccessWord != nil && len(p.SuccessWord) > 0 {
 		return true
@@ -107,16 +236,21 @@ const (
 )
 
 type ProxyCache struct {
-	UpdateTime               int64 // 更新时间
-	FilterProxyInfoIndexList []int // 过滤后的代理信息
-	NowFilterProxyInfoIndex  int   // 过滤后的代理信息的索引
+	UpdateTime               int64             // 更新时间
+	FilterProxyIDList        []string          // 过滤后的代理节点标识
+	FilterProxyInfoIndexList []int             `json:",omitempty"` // 旧版本缓存的位置索引，仅用于迁移，不再写入
+	NowFilterProxyInfoIndex  int               // 过滤后的代理信息的索引
+	ProxyTestTimes           map[string]int64  `json:",omitempty"` // 节点最后一次通过测试的时间，没有的时候使用 UpdateTime
+	ProxyExitIPs             map[string]string `json:",omitempty"` // 节点的出口 IP
 }
 
 func NewProxyCache() *ProxyCache {
 	pc := ProxyCache{
-		FilterProxyInfoIndexList: make([]int, 0),
-		NowFilterProxyInfoIndex:  0,
-		UpdateTime:               0,
+		FilterProxyIDList:       make([]string, 0),
+		ProxyTestTimes:          make(map[string]int64),
+		ProxyExitIPs:            make(map[string]string),
+		NowFilterProxyInfoIndex: 0,
+		UpdateTime:              0,
 	}
 	return &pc
 }
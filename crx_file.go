@@ -0,0 +1,194 @@
+package rod_helper
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/mediabuyerbot/go-crx3"
+	"github.com/mediabuyerbot/go-crx3/pb"
+	"github.com/pkg/errors"
+)
+
+// crxFile 解析后的 CRX3 文件
+//
+//	"Cr24" | 版本 3 | header 的长度 N | header (CrxFileHeader) | zip
+type crxFile struct {
+	id               string // 由 SignedData.CrxId 得到的插件 ID
+	crxID            []byte
+	header           *pb.CrxFileHeader
+	signedHeaderData []byte
+	archive          []byte // zip 的内容
+}
+
+// readCrxFile 读取并解析 CRX3 文件，不校验签名，见 verify
+func readCrxFile(fPath string) (*crxFile, error) {
+
+	data, err := os.ReadFile(fPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "readCrxFile")
+	}
+	return parseCrxFile(data)
+}
+
+func parseCrxFile(data []byte) (*crxFile, error) {
+
+	if len(data) < crxMetaSize || bytes.Equal(data[:4], []byte(crxMagic)) == false {
+		return nil, ErrInvalidCrxFile
+	}
+	if binary.LittleEndian.Uint32(data[4:8]) != 3 {
+		return nil, errors.Wrap(ErrInvalidCrxFile, "only CRX3 is supported")
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[8:12]))
+	if headerSize <= 0 || headerSize > maxCrxHeaderSize || crxMetaSize+headerSize > len(data) {
+		return nil, errors.Wrap(ErrInvalidCrxFile, "header size out of range")
+	}
+
+	header := &pb.CrxFileHeader{}
+	err := proto.Unmarshal(data[crxMetaSize:crxMetaSize+headerSize], header)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCrxFile, err.Error())
+	}
+	signedData := &pb.SignedData{}
+	err = proto.Unmarshal(header.SignedHeaderData, signedData)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCrxFile, err.Error())
+	}
+	if len(signedData.CrxId) != 16 {
+		return nil, errors.Wrap(ErrInvalidCrxFile, "crx id length error")
+	}
+
+	return &crxFile{
+		id:               encodeExtensionID(signedData.CrxId),
+		crxID:            signedData.CrxId,
+		header:           header,
+		signedHeaderData: header.SignedHeaderData,
+		archive:          data[crxMetaSize+headerSize:],
+	}, nil
+}
+
+// verify 校验 CRX3 的签名，同 Chrome，所有的签名都需要有效，并且其中一个是插件自己的公钥（sha256 的前 16 个字节等于 crx_id）
+// expectID 不为空的时候还需要和插件 ID 一致
+func (c *crxFile) verify(expectID string) error {
+
+	if expectID != "" && c.id != expectID {
+		return errors.Wrapf(ErrExtensionIDMismatch, "expect %s, got %s", expectID, c.id)
+	}
+	if len(c.header.Sha256WithRsa)+len(c.header.Sha256WithEcdsa) == 0 {
+		return errors.Wrap(ErrCrxSignature, "no signature")
+	}
+
+	// 签名的内容："CRX3 SignedData\x00" + signed_header_data 的长度 + signed_header_data + zip
+	hash := sha256.New()
+	hash.Write([]byte(crxSignContext))
+	sizeBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sizeBytes, uint32(len(c.signedHeaderData)))
+	hash.Write(sizeBytes)
+	hash.Write(c.signedHeaderData)
+	hash.Write(c.archive)
+	digest := hash.Sum(nil)
+
+	developerKeyFound := false
+	checkProof := func(proof *pb.AsymmetricKeyProof, isRsa bool) error {
+		publicKey, err := x509.ParsePKIXPublicKey(proof.PublicKey)
+		if err != nil {
+			return errors.Wrap(ErrCrxSignature, err.Error())
+		}
+		valid := false
+		if isRsa == true {
+			rsaKey, ok := publicKey.(*rsa.PublicKey)
+			valid = ok == true && rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest, proof.Signature) == nil
+		} else {
+			ecdsaKey, ok := publicKey.(*ecdsa.PublicKey)
+			valid = ok == true && ecdsa.VerifyASN1(ecdsaKey, digest, proof.Signature) == true
+		}
+		if valid == false {
+			return errors.Wrap(ErrCrxSignature, "signature mismatch")
+		}
+		keyHash := sha256.Sum256(proof.PublicKey)
+		if bytes.Equal(keyHash[:16], c.crxID) == true {
+			developerKeyFound = true
+		}
+		return nil
+	}
+	for _, proof := range c.header.Sha256WithRsa {
+		if err := checkProof(proof, true); err != nil {
+			return err
+		}
+	}
+	for _, proof := range c.header.Sha256WithEcdsa {
+		if err := checkProof(proof, false); err != nil {
+			return err
+		}
+	}
+	if developerKeyFound == false {
+		return errors.Wrap(ErrCrxSignature, "no signature from the extension key")
+	}
+	return nil
+}
+
+// verifyCrxFile 读取并校验本地的 crx 文件
+func verifyCrxFile(fPath string, expectID string) error {
+
+	crx, err := readCrxFile(fPath)
+	if err != nil {
+		return err
+	}
+	return crx.verify(expectID)
+}
+
+// unpack 解压到 dirPath
+func (c *crxFile) unpack(dirPath string) error {
+	return crx3.Unzip(bytes.NewReader(c.archive), int64(len(c.archive)), dirPath)
+}
+
+// extensionIDFromKey Chrome 由公钥（DER）计算插件 ID 的方式，sha256 的前 16 个字节
+func extensionIDFromKey(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	return encodeExtensionID(sum[:16])
+}
+
+// encodeExtensionID 每 4 位用 a-p 表示
+func encodeExtensionID(crxID []byte) string {
+	hexID := []byte(hex.EncodeToString(crxID))
+	for i, c := range hexID {
+		if c >= 'a' {
+			hexID[i] = c - 'a' + 10 + 'a'
+		} else {
+			hexID[i] = c - '0' + 'a'
+		}
+	}
+	return string(hexID)
+}
+
+// isExtensionID 32 个 a-p 的字符
+func isExtensionID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 'a' || id[i] > 'p' {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	ErrInvalidCrxFile = errors.New("invalid crx file")
+	ErrCrxSignature   = errors.New("crx signature verification failed")
+)
+
+const (
+	crxMagic         = "Cr24"
+	crxSignContext   = "CRX3 SignedData\x00"
+	crxMetaSize      = 12
+	maxCrxHeaderSize = 1024 * 1024
+)
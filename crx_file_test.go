@@ -0,0 +1,53 @@
+package rod_helper
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/mediabuyerbot/go-crx3"
+)
+
+func TestCrxFileVerify(t *testing.T) {
+
+	tmpDir := t.TempDir()
+	pk, err := crx3.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crxPath := newTestCrx(t, tmpDir, pk, "1.0.0")
+	id, err := crx3.ID(crxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyCrxFile(crxPath, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyCrxFile(crxPath, extensionIDFromKey([]byte("other")))
+	if errors.Is(err, ErrExtensionIDMismatch) == false {
+		t.Fatal("extension id mismatch error", err)
+	}
+
+	// 改动 zip 的内容后签名失效
+	data, err := os.ReadFile(crxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	crx, err := parseCrxFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = crx.verify(id)
+	if errors.Is(err, ErrCrxSignature) == false {
+		t.Fatal("tampered crx should fail to verify", err)
+	}
+
+	// 没有签名
+	crx.header.Sha256WithRsa = nil
+	err = crx.verify("")
+	if errors.Is(err, ErrCrxSignature) == false {
+		t.Fatal("crx without signature should fail to verify", err)
+	}
+}
@@ -3,8 +3,12 @@ package rod_helper
 import "github.com/pkg/errors"
 
 var (
-	ErrProxyInfosIsEmpty = errors.New("orgProxyInfos is empty")
-	ErrSkipAccessTime    = errors.New("skipAccessTime")
-	ErrIndexIsOutOfRange = errors.New("index is out of range")
-	ErrPageLoadFailed    = errors.New("pageLoaded == false")
+	ErrProxyInfosIsEmpty  = errors.New("orgProxyInfos is empty")
+	ErrSkipAccessTime     = errors.New("skipAccessTime")
+	ErrIndexIsOutOfRange  = errors.New("index is out of range")
+	ErrPageLoadFailed     = errors.New("pageLoaded == false")
+	ErrXrayPoolNotStarted = errors.New("XrayPool Not Started!")
+	ErrPoolClosed         = errors.New("pool is closed")
+	ErrProxyNodeNotFound  = errors.New("proxy node is not found")
+	ErrProxyNodesLeased   = errors.New("all proxy nodes are leased")
 )
@@ -0,0 +1,507 @@
+package rod_helper
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WQGroup/logger"
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+)
+
+// ExtensionCacheInfo 一个插件缓存的信息
+type ExtensionCacheInfo struct {
+	ID             string
+	Name           string // manifest.json 中的名称
+	Version        string // manifest.json 中的版本
+	Source         string // 下载地址，或者本地 crx、目录的路径
+	CrxPath        string // 本地解压好的目录没有 crx
+	UnpackedDir    string // 浏览器加载的目录
+	DownloadedTime int64
+	TTL            int64               // 缓存的有效期，秒，小于等于 0 的时候不过期，过期后 Get 会重新下载
+	Previous       *ExtensionCacheInfo `json:",omitempty"` // 上一个签名校验通过的版本，当前版本校验失败的时候回滚到这个版本
+}
+
+// Expired 缓存是否已经超过了 TTL
+func (i ExtensionCacheInfo) Expired() bool {
+	if i.TTL <= 0 {
+		return false
+	}
+	return time.Now().Unix() >= i.DownloadedTime+i.TTL
+}
+
+// ExtensionManager 下载、解压、缓存任意 ID 的 Chrome 插件，也可以添加本地的 crx 以及解压好的目录
+// 从 Chrome 的更新地址或者设置的镜像下载，缓存在 CacheLayout.ExtensionDir 中
+// crx 安装前以及使用缓存前都会校验 CRX3 的签名和插件 ID，会保留上一个校验通过的版本用于回滚
+//
+//	extensions
+//	├── <id>.json        ExtensionCacheInfo
+//	└── <id>
+//	    ├── <version>.crx
+//	    ├── <version>    解压后的目录
+//	    └── ...          上一个版本，用于回滚
+type ExtensionManager struct {
+	rootDirPath string
+	store       StateStore
+	updateUrl   string // 下载地址，{id} 替换为插件 ID，{prodversion} 替换为浏览器的版本
+	prodVersion string
+	// prodVersionFunc 下载前获取浏览器的版本，比如需要启动浏览器才能知道版本的时候
+	prodVersionFunc func() (string, error)
+	httpProxyUrl    string
+	timeOut         time.Duration
+	ttl             time.Duration
+	locker          sync.Mutex
+}
+
+func NewExtensionManager(cacheRootDirPath string) *ExtensionManager {
+
+	rootDirPath := NewCacheLayout(cacheRootDirPath).ExtensionDir()
+	return &ExtensionManager{
+		rootDirPath: rootDirPath,
+		store:       NewFileStateStore(rootDirPath),
+		updateUrl:   defExtensionUpdateUrl,
+		prodVersion: defExtensionProdVersion,
+		timeOut:     time.Minute,
+	}
+}
+
+// SetUpdateUrl 设置下载的镜像，比如 https://mirror.example.com/crx/{id}.crx，为空的时候使用 Chrome 的更新地址
+func (m *ExtensionManager) SetUpdateUrl(updateUrl string) {
+	if updateUrl == "" {
+		updateUrl = defExtensionUpdateUrl
+	}
+	m.updateUrl = updateUrl
+}
+
+// SetProdVersion 下载时告诉更新服务器的浏览器版本，会影响下载到的插件版本
+func (m *ExtensionManager) SetProdVersion(prodVersion string) {
+	if prodVersion == "" {
+		prodVersion = defExtensionProdVersion
+	}
+	m.prodVersion = prodVersion
+}
+
+// SetProdVersionFunc 需要下载的时候才调用 f 获取浏览器的版本，成功后不再调用，优先于 SetProdVersion
+func (m *ExtensionManager) SetProdVersionFunc(f func() (string, error)) {
+	m.prodVersionFunc = f
+}
+
+// SetTTL 下载的插件缓存的有效期，过期后 Get 会重新下载，下载或者校验失败的时候继续使用缓存，0 为不过期
+// 只对从网络下载的插件生效，本地添加的 crx 以及目录不会过期
+func (m *ExtensionManager) SetTTL(ttl time.Duration) {
+	m.ttl = ttl
+}
+
+// SetHttpProxy 下载时使用的 http 代理
+func (m *ExtensionManager) SetHttpProxy(httpProxyUrl string) {
+	m.httpProxyUrl = httpProxyUrl
+}
+
+func (m *ExtensionManager) SetTimeOut(timeOut time.Duration) {
+	m.timeOut = timeOut
+}
+
+func (m *ExtensionManager) RootDirPath() string {
+	return m.rootDirPath
+}
+
+// Get 获取这个插件，已经缓存并且签名校验通过的直接返回，校验失败的时候回滚到上一个版本，没有的时候下载
+// 缓存超过 TTL 的时候重新下载，失败了继续使用缓存
+func (m *ExtensionManager) Get(ctx context.Context, id string) (*ExtensionCacheInfo, error) {
+
+	if isExtensionID(id) == false {
+		return nil, errors.Wrap(ErrInvalidExtensionID, id)
+	}
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	info, found := m.cached(id)
+	if found == true {
+		info, found = m.verifyCached(info)
+	}
+	if found == false {
+		return m.download(ctx, id)
+	}
+	if isRemoteSource(info.Source) == false {
+		return info, nil
+	}
+	// TTL 以当前的设置为准，记录在缓存信息中
+	ttl := int64(m.ttl / time.Second)
+	if info.TTL != ttl {
+		info.TTL = ttl
+		err := m.store.Save(extensionCacheKey(id), info)
+		if err != nil {
+			logger.Warningln("ExtensionManager save cache info", id, err)
+		}
+	}
+	if info.Expired() == false {
+		return info, nil
+	}
+	newInfo, err := m.download(ctx, id)
+	if err != nil {
+		logger.Warningln("refresh extension failed, keep the cached version", id, info.Version, err)
+		return info, nil
+	}
+	return newInfo, nil
+}
+
+// Download 重新下载这个插件，即使已经缓存
+func (m *ExtensionManager) Download(ctx context.Context, id string) (*ExtensionCacheInfo, error) {
+
+	if isExtensionID(id) == false {
+		return nil, errors.Wrap(ErrInvalidExtensionID, id)
+	}
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.download(ctx, id)
+}
+
+// Cached 这个插件的缓存，解压的目录不存在的时候视为没有缓存
+func (m *ExtensionManager) Cached(id string) (*ExtensionCacheInfo, bool) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.cached(id)
+}
+
+// AddCrx 添加本地的 crx 文件，插件 ID 从文件中读取
+func (m *ExtensionManager) AddCrx(crxPath string) (*ExtensionCacheInfo, error) {
+
+	data, err := os.ReadFile(crxPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "ExtensionManager.AddCrx")
+	}
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	return m.installCrx("", data, crxPath)
+}
+
+// AddUnpacked 添加本地解压好的插件目录，直接加载这个目录，不会复制
+// 插件 ID 同 Chrome，manifest.json 中有 key 的时候由 key 计算，没有的时候由目录的绝对路径计算
+func (m *ExtensionManager) AddUnpacked(dirPath string) (*ExtensionCacheInfo, error) {
+
+	absDirPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "ExtensionManager.AddUnpacked")
+	}
+	manifest, err := readExtensionManifest(absDirPath)
+	if err != nil {
+		return nil, err
+	}
+	id := ""
+	if manifest.Key != "" {
+		publicKey, err := base64.StdEncoding.DecodeString(manifest.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, "ExtensionManager.AddUnpacked decode manifest key")
+		}
+		id = extensionIDFromKey(publicKey)
+	} else {
+		sum := sha256.Sum256([]byte(absDirPath))
+		id = encodeExtensionID(sum[:16])
+	}
+
+	info := &ExtensionCacheInfo{
+		ID:             id,
+		Name:           manifest.Name,
+		Version:        manifest.Version,
+		Source:         absDirPath,
+		UnpackedDir:    absDirPath,
+		DownloadedTime: time.Now().Unix(),
+	}
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	err = m.store.Save(extensionCacheKey(id), info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// List 所有缓存的插件
+func (m *ExtensionManager) List() ([]*ExtensionCacheInfo, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	keys, err := m.store.List("")
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*ExtensionCacheInfo, 0, len(keys))
+	for _, key := range keys {
+		if strings.HasSuffix(key, extensionCacheKeySuffix) == false {
+			continue
+		}
+		info, found := m.cached(strings.TrimSuffix(key, extensionCacheKeySuffix))
+		if found == true {
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// Remove 删除这个插件的缓存，AddUnpacked 添加的目录不会删除
+func (m *ExtensionManager) Remove(id string) error {
+
+	if isExtensionID(id) == false {
+		return errors.Wrap(ErrInvalidExtensionID, id)
+	}
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	err := m.store.Delete(extensionCacheKey(id))
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(m.rootDirPath, id))
+}
+
+// LocalPaths 获取这些插件解压后的目录，没有缓存的会下载，用于 NewBrowserBaseWithExtensions
+func (m *ExtensionManager) LocalPaths(ctx context.Context, ids ...string) ([]string, error) {
+
+	dirPaths := make([]string, 0, len(ids))
+	for _, id := range ids {
+		info, err := m.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		dirPaths = append(dirPaths, info.UnpackedDir)
+	}
+	return dirPaths, nil
+}
+
+func (m *ExtensionManager) cached(id string) (*ExtensionCacheInfo, bool) {
+
+	info := &ExtensionCacheInfo{}
+	err := m.store.Load(extensionCacheKey(id), info)
+	if err != nil {
+		if errors.Is(err, ErrStateNotFound) == false {
+			logger.Warningln("ExtensionManager load cache info", id, err)
+		}
+		return nil, false
+	}
+	if IsDir(info.UnpackedDir) == false {
+		return nil, false
+	}
+	return info, true
+}
+
+// verifyCached 校验缓存的 crx，失败的时候回滚到上一个校验通过的版本，本地解压好的目录没有 crx 不校验
+func (m *ExtensionManager) verifyCached(info *ExtensionCacheInfo) (*ExtensionCacheInfo, bool) {
+
+	if info.CrxPath == "" {
+		return info, true
+	}
+	err := verifyCrxFile(info.CrxPath, info.ID)
+	if err == nil {
+		return info, true
+	}
+	logger.Warningln("cached extension verify failed", info.ID, info.Version, err)
+
+	previous := info.Previous
+	if previous == nil || previous.CrxPath == "" || IsDir(previous.UnpackedDir) == false {
+		return nil, false
+	}
+	err = verifyCrxFile(previous.CrxPath, info.ID)
+	if err != nil {
+		logger.Warningln("previous extension verify failed", info.ID, previous.Version, err)
+		return nil, false
+	}
+	if previous.Version != info.Version {
+		_ = os.RemoveAll(info.UnpackedDir)
+		_ = os.Remove(info.CrxPath)
+	}
+	err = m.store.Save(extensionCacheKey(info.ID), previous)
+	if err != nil {
+		logger.Warningln("ExtensionManager save cache info", info.ID, err)
+	}
+	logger.Infoln("rollback extension", info.ID, info.Version, "->", previous.Version)
+	return previous, true
+}
+
+func (m *ExtensionManager) download(ctx context.Context, id string) (*ExtensionCacheInfo, error) {
+
+	if m.prodVersionFunc != nil {
+		prodVersion, err := m.prodVersionFunc()
+		if err != nil {
+			return nil, err
+		}
+		m.SetProdVersion(prodVersion)
+		m.prodVersionFunc = nil
+	}
+	downloadUrl := strings.ReplaceAll(m.updateUrl, "{id}", id)
+	downloadUrl = strings.ReplaceAll(downloadUrl, "{prodversion}", m.prodVersion)
+	logger.Infoln("download extension start...", id, downloadUrl)
+
+	client := resty.New()
+	if m.httpProxyUrl != "" {
+		client.SetProxy(m.httpProxyUrl)
+	}
+	client.SetTimeout(m.timeOut)
+	res, err := client.R().SetContext(ctx).Get(downloadUrl)
+	if err != nil {
+		return nil, errors.Wrap(err, "download extension "+id)
+	}
+	if res.StatusCode() != http.StatusOK || len(res.Body()) == 0 {
+		return nil, errors.Errorf("download extension %s status code: %d", id, res.StatusCode())
+	}
+	return m.installCrx(id, res.Body(), downloadUrl)
+}
+
+// installCrx 校验签名后解压到以版本命名的目录，保存 crx 以及缓存信息，expectID 不为空的时候需要和 crx 中的 ID 一致
+// 校验失败的时候不会改动已有的缓存，成功后把之前的版本记录为 Previous
+func (m *ExtensionManager) installCrx(expectID string, data []byte, source string) (*ExtensionCacheInfo, error) {
+
+	crx, err := parseCrxFile(data)
+	if err != nil {
+		return nil, err
+	}
+	err = crx.verify(expectID)
+	if err != nil {
+		return nil, err
+	}
+
+	extDirPath := filepath.Join(m.rootDirPath, crx.id)
+	err = os.MkdirAll(extDirPath, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	tmpDirPath, err := os.MkdirTemp(extDirPath, ".unpack-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDirPath)
+	}()
+	err = crx.unpack(tmpDirPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "unpack extension "+crx.id)
+	}
+	manifest, err := readExtensionManifest(tmpDirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	unpackedDir := filepath.Join(extDirPath, manifest.Version)
+	err = os.RemoveAll(unpackedDir)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(tmpDirPath, unpackedDir)
+	if err != nil {
+		return nil, err
+	}
+	crxPath := filepath.Join(extDirPath, manifest.Version+".crx")
+	err = writeFileAtomic(crxPath, data)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ExtensionCacheInfo{
+		ID:             crx.id,
+		Name:           manifest.Name,
+		Version:        manifest.Version,
+		Source:         source,
+		CrxPath:        crxPath,
+		UnpackedDir:    unpackedDir,
+		DownloadedTime: time.Now().Unix(),
+	}
+	if isRemoteSource(source) == true {
+		info.TTL = int64(m.ttl / time.Second)
+	}
+	// 之前的版本保留下来用于回滚，版本相同的时候沿用之前的 Previous
+	oldInfo, found := m.cached(crx.id)
+	if found == true && oldInfo.CrxPath != "" {
+		if oldInfo.Version != manifest.Version {
+			oldInfo.Previous = nil
+			info.Previous = oldInfo
+		} else {
+			info.Previous = oldInfo.Previous
+		}
+	}
+	err = m.store.Save(extensionCacheKey(crx.id), info)
+	if err != nil {
+		return nil, err
+	}
+	keepVersions := []string{manifest.Version}
+	if info.Previous != nil {
+		keepVersions = append(keepVersions, info.Previous.Version)
+	}
+	m.removeOtherVersions(extDirPath, keepVersions...)
+	logger.Infoln("install extension", crx.id, manifest.Name, manifest.Version)
+	return info, nil
+}
+
+// removeOtherVersions 只保留这些版本
+func (m *ExtensionManager) removeOtherVersions(extDirPath string, versions ...string) {
+
+	keepNames := make(map[string]bool)
+	for _, version := range versions {
+		keepNames[version] = true
+		keepNames[version+".crx"] = true
+	}
+	entries, err := os.ReadDir(extDirPath)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if keepNames[name] == true || strings.HasPrefix(name, ".") == true {
+			continue
+		}
+		_ = os.RemoveAll(filepath.Join(extDirPath, name))
+	}
+}
+
+// isRemoteSource 是否是从网络下载的插件，本地的 crx 以及目录不会重新下载
+func isRemoteSource(source string) bool {
+	return strings.HasPrefix(source, "http://") == true || strings.HasPrefix(source, "https://") == true
+}
+
+// extensionManifest manifest.json 中用到的字段
+type extensionManifest struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Key     string `json:"key"`
+}
+
+func readExtensionManifest(dirPath string) (*extensionManifest, error) {
+
+	data, err := os.ReadFile(filepath.Join(dirPath, "manifest.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "read extension manifest")
+	}
+	manifest := &extensionManifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse extension manifest")
+	}
+	// 版本号会作为目录名
+	if manifest.Version == "" || filepath.Base(manifest.Version) != manifest.Version || strings.HasPrefix(manifest.Version, ".") == true {
+		return nil, errors.New("extension manifest version error: " + manifest.Version)
+	}
+	return manifest, nil
+}
+
+func extensionCacheKey(id string) string {
+	return id + extensionCacheKeySuffix
+}
+
+var (
+	ErrInvalidExtensionID  = errors.New("invalid extension id")
+	ErrExtensionIDMismatch = errors.New("extension id mismatch")
+)
+
+const (
+	defExtensionUpdateUrl   = "https://clients2.google.com/service/update2/crx?response=redirect&prodversion={prodversion}&acceptformat=crx2%2Ccrx3&x=id%3D{id}%26uc"
+	defExtensionProdVersion = "120.0.6099.109"
+	extensionCacheKeySuffix = ".json"
+)
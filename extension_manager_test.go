@@ -0,0 +1,234 @@
+package rod_helper
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mediabuyerbot/go-crx3"
+)
+
+// newTestCrx 打包一个只有 manifest.json 的插件
+func newTestCrx(t *testing.T, tmpDir string, pk *rsa.PrivateKey, version string) string {
+
+	srcDir, err := os.MkdirTemp(tmpDir, "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := `{"name": "test", "version": "` + version + `", "manifest_version": 3}`
+	err = os.WriteFile(filepath.Join(srcDir, "manifest.json"), []byte(manifest), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crxPath := srcDir + "-" + version + ".crx"
+	err = crx3.Pack(srcDir, crxPath, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crxPath
+}
+
+func TestExtensionManager(t *testing.T) {
+
+	tmpDir := t.TempDir()
+	pk, err := crx3.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crxPathV1 := newTestCrx(t, tmpDir, pk, "1.0.0")
+	crxPathV2 := newTestCrx(t, tmpDir, pk, "1.1.0")
+	id, err := crx3.ID(crxPathV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewExtensionManager(filepath.Join(tmpDir, "cache"))
+	info, err := m.AddCrx(crxPathV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != id || info.Version != "1.0.0" || IsFile(filepath.Join(info.UnpackedDir, "manifest.json")) == false || IsFile(info.CrxPath) == false {
+		t.Fatal("add crx error", info)
+	}
+
+	// 镜像下载，新的版本替换旧的版本
+	var downloadCount int32
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downloadCount, 1)
+		if r.URL.Path != "/"+id+".crx" {
+			http.ServeFile(w, r, crxPathV1)
+			return
+		}
+		http.ServeFile(w, r, crxPathV2)
+	}))
+	defer mirror.Close()
+	m.SetUpdateUrl(mirror.URL + "/{id}.crx")
+	oldUnpackedDir := info.UnpackedDir
+	info, err = m.Download(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.1.0" || info.Previous == nil || info.Previous.UnpackedDir != oldUnpackedDir || IsDir(oldUnpackedDir) == false {
+		t.Fatal("download new version error", info.Version)
+	}
+	paths, err := m.LocalPaths(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != info.UnpackedDir || atomic.LoadInt32(&downloadCount) != 1 {
+		t.Fatal("cached extension should not be downloaded again", paths, atomic.LoadInt32(&downloadCount))
+	}
+
+	// 镜像返回的插件和请求的 ID 不一致
+	otherID := extensionIDFromKey([]byte("other"))
+	_, err = m.Get(context.Background(), otherID)
+	if errors.Is(err, ErrExtensionIDMismatch) == false {
+		t.Fatal("extension id mismatch error", err)
+	}
+	_, err = m.Get(context.Background(), "../../etc")
+	if errors.Is(err, ErrInvalidExtensionID) == false {
+		t.Fatal("invalid extension id error", err)
+	}
+
+	// 本地解压好的目录，有 key 的时候 ID 和 crx 的一致
+	unpackedDir := filepath.Join(tmpDir, "unpacked")
+	_ = os.MkdirAll(unpackedDir, os.ModePerm)
+	publicKey, _ := x509.MarshalPKIXPublicKey(&pk.PublicKey)
+	manifest := `{"name": "local", "version": "2.0", "key": "` + base64.StdEncoding.EncodeToString(publicKey) + `"}`
+	_ = os.WriteFile(filepath.Join(unpackedDir, "manifest.json"), []byte(manifest), 0666)
+	localInfo, err := m.AddUnpacked(unpackedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if localInfo.ID != id || localInfo.UnpackedDir != unpackedDir || localInfo.CrxPath != "" {
+		t.Fatal("add unpacked error", localInfo)
+	}
+
+	infos, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Version != "2.0" {
+		t.Fatal("list extensions error", len(infos))
+	}
+	err = m.Remove(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := m.Cached(id); found == true || IsDir(unpackedDir) == false {
+		t.Fatal("remove extension error")
+	}
+}
+
+func TestExtensionManagerUpdatePolicy(t *testing.T) {
+
+	tmpDir := t.TempDir()
+	pk, err := crx3.NewPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crxPathV1 := newTestCrx(t, tmpDir, pk, "1.0.0")
+	crxPathV2 := newTestCrx(t, tmpDir, pk, "1.1.0")
+	id, err := crx3.ID(crxPathV1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 篡改过的 v2，签名失效
+	data, err := os.ReadFile(crxPathV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	badCrxPath := filepath.Join(tmpDir, "bad.crx")
+	err = os.WriteFile(badCrxPath, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var downloadCount int32
+	var servePath atomic.Value
+	servePath.Store(crxPathV1)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downloadCount, 1)
+		http.ServeFile(w, r, servePath.Load().(string))
+	}))
+	defer mirror.Close()
+
+	m := NewExtensionManager(filepath.Join(tmpDir, "cache"))
+	m.SetUpdateUrl(mirror.URL + "/{id}.crx")
+	m.SetTTL(time.Hour)
+	expire := func() {
+		info, found := m.Cached(id)
+		if found == false {
+			t.Fatal("extension should be cached")
+		}
+		info.DownloadedTime = time.Now().Add(-2 * time.Hour).Unix()
+		_ = m.store.Save(extensionCacheKey(id), info)
+	}
+
+	info, err := m.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.0.0" || info.TTL != 3600 || info.Expired() == true {
+		t.Fatal("download extension error", info.Version, info.TTL)
+	}
+
+	// 下载到的新版本签名校验失败，不影响已有的缓存
+	servePath.Store(badCrxPath)
+	_, err = m.Download(context.Background(), id)
+	if errors.Is(err, ErrCrxSignature) == false {
+		t.Fatal("tampered crx should fail to verify", err)
+	}
+	expire()
+	info, err = m.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.0.0" || atomic.LoadInt32(&downloadCount) != 3 {
+		t.Fatal("expired extension should keep the cached version when refresh failed", info.Version, atomic.LoadInt32(&downloadCount))
+	}
+
+	// 过期后重新下载新的版本
+	servePath.Store(crxPathV2)
+	expire()
+	info, err = m.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.1.0" || info.Previous == nil || info.Previous.Version != "1.0.0" || atomic.LoadInt32(&downloadCount) != 4 {
+		t.Fatal("expired extension should be downloaded again", info.Version, atomic.LoadInt32(&downloadCount))
+	}
+	info, err = m.Get(context.Background(), id)
+	if err != nil || info.Version != "1.1.0" || atomic.LoadInt32(&downloadCount) != 4 {
+		t.Fatal("extension should not be downloaded before expired", err)
+	}
+
+	// 缓存的 crx 被改动，回滚到上一个版本，上一个版本已经过期，这里不再更新
+	m.SetTTL(0)
+	err = os.WriteFile(info.CrxPath, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badUnpackedDir := info.UnpackedDir
+	info, err = m.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != "1.0.0" || info.Previous != nil || IsDir(badUnpackedDir) == true || atomic.LoadInt32(&downloadCount) != 4 {
+		t.Fatal("rollback extension error", info.Version)
+	}
+	cachedInfo, found := m.Cached(id)
+	if found == false || cachedInfo.Version != "1.0.0" {
+		t.Fatal("rollback should be saved")
+	}
+}
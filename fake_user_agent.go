@@ -22,7 +22,7 @@ func InitFakeUA(UseInsideData bool, tmpRootFolder, httpProxyURL string) {
 	} else {
 		var err error
 		// 查看本地是否有缓存
-		uaRootPath := filepath.Join(".", "cache", "ua")
+		uaRootPath := getUACacheDir(tmpRootFolder)
 		if IsDir(uaRootPath) == false {
 			err = GetFakeUserAgentDataCache(tmpRootFolder, httpProxyURL)
 			if err != nil {
@@ -42,13 +42,24 @@ func InitFakeUA(UseInsideData bool, tmpRootFolder, httpProxyURL string) {
 	logger.Debugln("InitFakeUA Done:", len(allUANames))
 }
 
+// getUACacheDir UA 缓存的目录，旧版本缓存在当前目录的 cache/ua 中，新的目录还没有的时候继续使用旧的
+func getUACacheDir(tmpRootFolder string) string {
+
+	uaRootPath := NewCacheLayout(tmpRootFolder).UACacheDir()
+	legacyUARootPath := filepath.Join(".", "cache", "ua")
+	if IsDir(uaRootPath) == false && IsDir(legacyUARootPath) == true {
+		return legacyUARootPath
+	}
+	return uaRootPath
+}
+
 func readLocalCache(tmpRootFolder, httpProxyURL string, outSideAssets bool) {
 
 	var err error
 
 	if outSideAssets == true {
 		// 从外部获取
-		uaRootPath := filepath.Join(".", "cache", "ua")
+		uaRootPath := getUACacheDir(tmpRootFolder)
 		for i, subType := range subTypes {
 
 			uaFilePath := filepath.Join(uaRootPath, subType+".json")
@@ -57,6 +68,9 @@ func readLocalCache(tmpRootFolder, httpProxyURL string, outSideAssets bool) {
 				if err != nil {
 					logger.Panicln(err)
 				}
+				// 下载的缓存在新的目录中
+				uaRootPath = NewCacheLayout(tmpRootFolder).UACacheDir()
+				uaFilePath = filepath.Join(uaRootPath, subType+".json")
 			}
 			uaInfo := UserAgentInfo{}
 			err = ToStruct(uaFilePath, &uaInfo)
@@ -109,7 +123,7 @@ func GetFakeUserAgentDataCache(tmpRootFolder, httpProxyURL string) error {
 		_ = nowPage.Close()
 	}()
 
-	err = parseUAAllPage(nowPage)
+	err = parseUAAllPage(nowPage, NewCacheLayout(tmpRootFolder).UACacheDir())
 	if err != nil {
 		return err
 	}
@@ -117,7 +131,7 @@ func GetFakeUserAgentDataCache(tmpRootFolder, httpProxyURL string) error {
 	return nil
 }
 
-func parseUAAllPage(nowPage *rod.Page) error {
+func parseUAAllPage(nowPage *rod.Page, saveRootPath string) error {
 
 	// 所有的 UA 的 SubType 都在这里
 	const allInfoPage = "https://useragentstring.com/pages/useragentstring.php"
@@ -218,8 +232,7 @@ func parseUAAllPage(nowPage *rod.Page) error {
 			}
 		}
 	}
-	// 当前的目录下缓存下来
-	saveRootPath := filepath.Join(".", "cache", "ua")
+	// 缓存到 CacheLayout.UACacheDir
 	if IsDir(saveRootPath) == false {
 		err = os.MkdirAll(saveRootPath, os.ModePerm)
 		if err != nil {
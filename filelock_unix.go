@@ -0,0 +1,21 @@
+//go:build !windows
+
+package rod_helper
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFileHandle 建议锁，只对同样使用锁的进程有效
+func lockFileHandle(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive == true {
+		how = syscall.LOCK_EX
+	}
+	return syscall.Flock(int(file.Fd()), how)
+}
+
+func unlockFileHandle(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
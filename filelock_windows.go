@@ -0,0 +1,22 @@
+//go:build windows
+
+package rod_helper
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFileHandle 锁住整个文件，只对同样使用锁的进程有效
+func lockFileHandle(file *os.File, exclusive bool) error {
+	var flags uint32
+	if exclusive == true {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	return windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &windows.Overlapped{})
+}
+
+func unlockFileHandle(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
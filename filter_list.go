@@ -0,0 +1,691 @@
+package rod_helper
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/pkg/errors"
+	"golang.org/x/net/publicsuffix"
+)
+
+// FilterList EasyList、uBlock 格式的网络过滤规则，通过请求拦截实现，不依赖插件，无头模式也可以使用
+// 支持 ||domain^、|、^、* 以及 /正则/ 的写法，@@ 开头的例外规则，$third-party、$domain=、$important、$match-case 和资源类型的选项
+// 元素隐藏、不认识的选项的规则会被忽略，加载完成之后只读，可以在多个页面中共用
+type FilterList struct {
+	blockFilters       *filterIndex
+	exceptionFilters   *filterIndex
+	documentExceptions []*networkFilter // @@...$document，匹配的页面中所有的请求都不拦截
+	ruleCount          int
+	skipCount          int
+}
+
+func NewFilterList() *FilterList {
+	return &FilterList{
+		blockFilters:     newFilterIndex(),
+		exceptionFilters: newFilterIndex(),
+	}
+}
+
+// LoadFilterList 读取多个规则文件，合并到一个 FilterList 中
+func LoadFilterList(fPaths ...string) (*FilterList, error) {
+
+	f := NewFilterList()
+	for _, fPath := range fPaths {
+		err := f.loadFile(fPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *FilterList) loadFile(fPath string) error {
+
+	file, err := os.Open(fPath)
+	if err != nil {
+		return errors.Wrap(err, "LoadFilterList")
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return f.Parse(file)
+}
+
+// Parse 一行一条规则
+func (f *FilterList) Parse(reader io.Reader) error {
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		f.AddRule(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "FilterList.Parse")
+	}
+	return nil
+}
+
+// AddRule 添加一条规则，注释、空行以及不支持的规则返回 false
+func (f *FilterList) AddRule(line string) bool {
+
+	filter := parseNetworkFilter(line)
+	if filter == nil {
+		line = strings.TrimSpace(line)
+		if line != "" && strings.HasPrefix(line, "!") == false && strings.HasPrefix(line, "[") == false {
+			f.skipCount++
+		}
+		return false
+	}
+	f.ruleCount++
+	if filter.exception == true && filter.typeMask&filterTypeDocument != 0 {
+		f.documentExceptions = append(f.documentExceptions, filter)
+	}
+	if filter.exception == true {
+		f.exceptionFilters.add(filter)
+	} else {
+		f.blockFilters.add(filter)
+	}
+	return true
+}
+
+// RuleCount 生效的规则数量
+func (f *FilterList) RuleCount() int {
+	return f.ruleCount
+}
+
+// SkipCount 不支持而被忽略的规则数量，比如元素隐藏的规则
+func (f *FilterList) SkipCount() int {
+	return f.skipCount
+}
+
+// Match 这个请求是否需要拦截，$important 的规则不受例外规则的影响
+func (f *FilterList) Match(req BlockRequest) bool {
+
+	r := newFilterRequest(req.Url, req.SourceUrl, req.ResourceType, req.MainFrame)
+	if r == nil {
+		return false
+	}
+	blocked := f.blockFilters.find(r)
+	if blocked == nil {
+		return false
+	}
+	if blocked.important == true {
+		return true
+	}
+	if f.exceptionFilters.find(r) != nil {
+		return false
+	}
+	if req.SourceUrl != "" && len(f.documentExceptions) > 0 {
+		page := newFilterRequest(req.SourceUrl, "", proto.NetworkResourceTypeDocument, true)
+		if page != nil {
+			for _, filter := range f.documentExceptions {
+				if filter.match(page) == true {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// filterIndex 大部分的规则以域名或者 Url 中的一个词为索引，一个请求只需要检查少量的规则
+type filterIndex struct {
+	hostFilters  map[string][]*networkFilter // ||host^ 开头的规则，以 host 为索引
+	tokenFilters map[string][]*networkFilter // 以规则中一定会完整出现在 Url 中的词为索引
+	otherFilters []*networkFilter            // 找不到索引的规则，每个请求都需要检查
+}
+
+func newFilterIndex() *filterIndex {
+	return &filterIndex{
+		hostFilters:  make(map[string][]*networkFilter),
+		tokenFilters: make(map[string][]*networkFilter),
+		otherFilters: make([]*networkFilter, 0),
+	}
+}
+
+func (i *filterIndex) add(filter *networkFilter) {
+
+	if host := filter.indexHost(); host != "" {
+		i.hostFilters[host] = append(i.hostFilters[host], filter)
+		return
+	}
+	if token := filter.indexToken(); token != "" {
+		i.tokenFilters[token] = append(i.tokenFilters[token], filter)
+		return
+	}
+	i.otherFilters = append(i.otherFilters, filter)
+}
+
+// find 返回匹配的规则，优先返回 $important 的规则
+func (i *filterIndex) find(r *filterRequest) *networkFilter {
+
+	var found *networkFilter
+	check := func(filters []*networkFilter) bool {
+		for _, filter := range filters {
+			if filter.match(r) == false {
+				continue
+			}
+			if filter.important == true {
+				found = filter
+				return true
+			}
+			if found == nil {
+				found = filter
+			}
+		}
+		return false
+	}
+
+	for host := r.host; host != ""; {
+		if check(i.hostFilters[host]) == true {
+			return found
+		}
+		index := strings.Index(host, ".")
+		if index < 0 {
+			break
+		}
+		host = host[index+1:]
+	}
+	for _, token := range r.tokens() {
+		if check(i.tokenFilters[token]) == true {
+			return found
+		}
+	}
+	check(i.otherFilters)
+	return found
+}
+
+// networkFilter 一条网络过滤规则
+type networkFilter struct {
+	raw         string
+	exception   bool
+	pattern     string // 去掉了锚点，除了 $match-case 都是小写
+	hostAnchor  bool   // ||
+	startAnchor bool   // |
+	endAnchor   bool   // 结尾的 |
+	regex       *regexp.Regexp
+	matchCase   bool
+	important   bool
+	typeMask    uint32 // 为 0 的时候匹配除了页面本身之外的所有类型
+	thirdParty  int    // 1 只匹配第三方的请求，-1 只匹配同一个站点的请求，0 不限制
+	domains     []string
+	notDomains  []string
+}
+
+// parseNetworkFilter 注释、元素隐藏以及不支持的规则返回 nil
+func parseNetworkFilter(line string) *networkFilter {
+
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "!") == true || strings.HasPrefix(line, "[") == true {
+		return nil
+	}
+	for _, cosmetic := range []string{"##", "#@#", "#?#", "#$#", "#%#", "#@?#", "#@$#", "#@%#"} {
+		if strings.Contains(line, cosmetic) == true {
+			return nil
+		}
+	}
+
+	filter := &networkFilter{raw: line}
+	if strings.HasPrefix(line, "@@") == true {
+		filter.exception = true
+		line = line[2:]
+	}
+
+	// 选项在最后一个 $ 之后，正则表达式中也可能有 $
+	options := ""
+	if index := strings.LastIndex(line, "$"); index >= 0 {
+		if strings.HasPrefix(line, "/") == false || index > strings.LastIndex(line, "/") {
+			options = line[index+1:]
+			line = line[:index]
+		}
+	}
+	if options != "" && filter.parseOptions(options) == false {
+		return nil
+	}
+
+	if len(line) > 2 && strings.HasPrefix(line, "/") == true && strings.HasSuffix(line, "/") == true {
+		expr := line[1 : len(line)-1]
+		if filter.matchCase == false {
+			expr = "(?i)" + expr
+		}
+		reg, err := regexp.Compile(expr)
+		if err != nil {
+			return nil
+		}
+		filter.regex = reg
+		return filter
+	}
+
+	if strings.HasPrefix(line, "||") == true {
+		filter.hostAnchor = true
+		line = line[2:]
+	} else if strings.HasPrefix(line, "|") == true {
+		filter.startAnchor = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "|") == true {
+		filter.endAnchor = true
+		line = line[:len(line)-1]
+	}
+	for strings.Contains(line, "**") == true {
+		line = strings.ReplaceAll(line, "**", "*")
+	}
+	if filter.hostAnchor == false && filter.startAnchor == false {
+		line = strings.TrimPrefix(line, "*")
+	}
+	if filter.endAnchor == false {
+		line = strings.TrimSuffix(line, "*")
+	}
+	if line == "" && options == "" {
+		// 没有任何限制的规则会匹配所有的请求，通常是写错了
+		return nil
+	}
+	if filter.matchCase == false {
+		line = strings.ToLower(line)
+	}
+	filter.pattern = line
+	return filter
+}
+
+// parseOptions 有不支持的选项的时候返回 false，整条规则忽略，避免拦截了不该拦截的请求
+func (n *networkFilter) parseOptions(options string) bool {
+
+	var includeTypes, excludeTypes uint32
+	for _, option := range strings.Split(options, ",") {
+		option = strings.TrimSpace(option)
+		negated := strings.HasPrefix(option, "~")
+		option = strings.TrimPrefix(option, "~")
+		name, value := option, ""
+		if index := strings.Index(option, "="); index >= 0 {
+			name, value = option[:index], option[index+1:]
+		}
+		name, value = strings.ToLower(name), strings.ToLower(value)
+
+		if typeBit, found := filterOptionTypes[name]; found == true {
+			if negated == true {
+				excludeTypes |= typeBit
+			} else {
+				includeTypes |= typeBit
+			}
+			continue
+		}
+		switch name {
+		case "third-party", "3p":
+			n.thirdParty = 1
+			if negated == true {
+				n.thirdParty = -1
+			}
+		case "first-party", "1p":
+			n.thirdParty = -1
+			if negated == true {
+				n.thirdParty = 1
+			}
+		case "domain", "from":
+			for _, domain := range strings.Split(value, "|") {
+				domain = strings.TrimSpace(domain)
+				if strings.HasPrefix(domain, "~") == true {
+					n.notDomains = append(n.notDomains, strings.TrimPrefix(domain, "~"))
+				} else if domain != "" {
+					n.domains = append(n.domains, domain)
+				}
+			}
+		case "match-case":
+			n.matchCase = true
+		case "important":
+			n.important = true
+		case "all":
+			includeTypes |= filterTypeAll | filterTypeDocument
+		case "collapse":
+		default:
+			return false
+		}
+	}
+
+	if includeTypes == 0 && excludeTypes != 0 {
+		includeTypes = filterTypeAll
+	}
+	n.typeMask = includeTypes &^ excludeTypes
+	if includeTypes != 0 && n.typeMask == 0 {
+		// 排除了所有的类型，这条规则不会匹配任何请求
+		return false
+	}
+	return true
+}
+
+// indexHost ||host^ 或者 ||host/ 这样能确定完整域名的规则，返回这个域名
+func (n *networkFilter) indexHost() string {
+
+	if n.hostAnchor == false || n.regex != nil {
+		return ""
+	}
+	for i := 0; i < len(n.pattern); i++ {
+		c := n.pattern[i]
+		if c == '^' || c == '/' || c == ':' {
+			return n.pattern[:i]
+		}
+		if isFilterHostChar(c) == false {
+			return ""
+		}
+	}
+	if n.endAnchor == true {
+		return n.pattern
+	}
+	return ""
+}
+
+// indexToken 规则中一定会完整出现在 Url 中的最长的词，两边不能是通配符
+func (n *networkFilter) indexToken() string {
+
+	if n.regex != nil || n.matchCase == true {
+		return ""
+	}
+	best := ""
+	for i := 0; i < len(n.pattern); {
+		if isFilterTokenChar(n.pattern[i]) == false {
+			i++
+			continue
+		}
+		j := i
+		for j < len(n.pattern) && isFilterTokenChar(n.pattern[j]) == true {
+			j++
+		}
+		leftOk := (i == 0 && (n.startAnchor == true || n.hostAnchor == true)) || (i > 0 && n.pattern[i-1] != '*')
+		rightOk := (j == len(n.pattern) && n.endAnchor == true) || (j < len(n.pattern) && n.pattern[j] != '*')
+		token := n.pattern[i:j]
+		if leftOk == true && rightOk == true && len(token) > len(best) && commonUrlTokens[token] == false {
+			best = token
+		}
+		i = j
+	}
+	return best
+}
+
+func (n *networkFilter) match(r *filterRequest) bool {
+	return n.matchOptions(r) == true && n.matchUrl(r) == true
+}
+
+func (n *networkFilter) matchOptions(r *filterRequest) bool {
+
+	if n.typeMask != 0 {
+		if n.typeMask&r.typeBit == 0 {
+			return false
+		}
+	} else if r.typeBit == filterTypeDocument {
+		return false
+	}
+	if n.thirdParty != 0 {
+		if r.sourceHost == "" || (n.thirdParty == 1) != r.thirdParty {
+			return false
+		}
+	}
+	if len(n.notDomains) > 0 && r.sourceHost != "" && matchFilterDomains(r.sourceHost, n.notDomains) == true {
+		return false
+	}
+	if len(n.domains) > 0 && (r.sourceHost == "" || matchFilterDomains(r.sourceHost, n.domains) == false) {
+		return false
+	}
+	return true
+}
+
+func (n *networkFilter) matchUrl(r *filterRequest) bool {
+
+	reqUrl := r.lowerUrl
+	if n.matchCase == true {
+		reqUrl = r.url
+	}
+	if n.regex != nil {
+		return n.regex.MatchString(r.url)
+	}
+	if n.hostAnchor == true {
+		for _, start := range r.hostStarts {
+			if matchFilterPattern(n.pattern, reqUrl[start:], n.endAnchor) == true {
+				return true
+			}
+		}
+		return false
+	}
+	if n.startAnchor == true {
+		return matchFilterPattern(n.pattern, reqUrl, n.endAnchor)
+	}
+	if n.pattern == "" {
+		return true
+	}
+	// 没有锚点的规则可以从任意位置开始匹配，以普通字符开头的只需要尝试这个字符出现的位置
+	first := n.pattern[0]
+	if first == '*' || first == '^' {
+		for i := 0; i <= len(reqUrl); i++ {
+			if matchFilterPattern(n.pattern, reqUrl[i:], n.endAnchor) == true {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; i < len(reqUrl); {
+		index := strings.IndexByte(reqUrl[i:], first)
+		if index < 0 {
+			return false
+		}
+		if matchFilterPattern(n.pattern, reqUrl[i+index:], n.endAnchor) == true {
+			return true
+		}
+		i += index + 1
+	}
+	return false
+}
+
+// matchFilterPattern pattern 是否匹配 s 的开头，endAnchor 的时候需要匹配整个 s
+// * 匹配任意字符，^ 匹配分隔符或者结尾
+func matchFilterPattern(pattern, s string, endAnchor bool) bool {
+
+	for len(pattern) > 0 {
+		switch c := pattern[0]; c {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchFilterPattern(pattern, s[i:], endAnchor) == true {
+					return true
+				}
+			}
+			return false
+		case '^':
+			if len(s) == 0 {
+				pattern = pattern[1:]
+				continue
+			}
+			if isFilterSeparator(s[0]) == false {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		default:
+			if len(s) == 0 || s[0] != c {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return endAnchor == false || len(s) == 0
+}
+
+// matchFilterDomains host 是 domains 中的一个或者是它的子域名
+func matchFilterDomains(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) == true {
+			return true
+		}
+	}
+	return false
+}
+
+// filterRequest 匹配规则时用到的请求信息，预先计算好
+type filterRequest struct {
+	url        string
+	lowerUrl   string
+	host       string
+	hostStarts []int // lowerUrl 中 host 开始的位置以及 host 中每个 . 之后的位置，|| 从这些位置开始匹配
+	sourceHost string
+	thirdParty bool
+	typeBit    uint32
+}
+
+func newFilterRequest(reqUrl, sourceUrl string, resourceType proto.NetworkResourceType, mainFrame bool) *filterRequest {
+
+	u, err := url.Parse(reqUrl)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	r := &filterRequest{
+		url:      reqUrl,
+		lowerUrl: strings.ToLower(reqUrl),
+		host:     strings.ToLower(u.Hostname()),
+		typeBit:  filterRequestType(resourceType, mainFrame),
+	}
+	hostStart := strings.Index(r.lowerUrl, "://")
+	if hostStart >= 0 {
+		hostStart = strings.Index(r.lowerUrl[hostStart+3:], r.host) + hostStart + 3
+	}
+	if hostStart >= 0 && strings.HasPrefix(r.lowerUrl[hostStart:], r.host) == true {
+		r.hostStarts = append(r.hostStarts, hostStart)
+		for i := 0; i < len(r.host); i++ {
+			if r.host[i] == '.' {
+				r.hostStarts = append(r.hostStarts, hostStart+i+1)
+			}
+		}
+	}
+	if sourceUrl != "" {
+		if source, err := url.Parse(sourceUrl); err == nil {
+			r.sourceHost = strings.ToLower(source.Hostname())
+		}
+	}
+	if r.sourceHost != "" {
+		r.thirdParty = registrableDomain(r.host) != registrableDomain(r.sourceHost)
+	}
+	return r
+}
+
+// tokens Url 中所有的词，用于查找 tokenFilters
+func (r *filterRequest) tokens() []string {
+
+	tokens := make([]string, 0, 16)
+	seen := make(map[string]bool)
+	for i := 0; i < len(r.lowerUrl); {
+		if isFilterTokenChar(r.lowerUrl[i]) == false {
+			i++
+			continue
+		}
+		j := i
+		for j < len(r.lowerUrl) && isFilterTokenChar(r.lowerUrl[j]) == true {
+			j++
+		}
+		token := r.lowerUrl[i:j]
+		if seen[token] == false {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+		i = j
+	}
+	return tokens
+}
+
+func registrableDomain(host string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+func filterRequestType(resourceType proto.NetworkResourceType, mainFrame bool) uint32 {
+
+	switch resourceType {
+	case proto.NetworkResourceTypeDocument:
+		if mainFrame == true {
+			return filterTypeDocument
+		}
+		return filterTypeSubdocument
+	case proto.NetworkResourceTypeStylesheet:
+		return filterTypeStylesheet
+	case proto.NetworkResourceTypeImage:
+		return filterTypeImage
+	case proto.NetworkResourceTypeMedia:
+		return filterTypeMedia
+	case proto.NetworkResourceTypeFont:
+		return filterTypeFont
+	case proto.NetworkResourceTypeScript:
+		return filterTypeScript
+	case proto.NetworkResourceTypeXHR, proto.NetworkResourceTypeFetch:
+		return filterTypeXHR
+	case proto.NetworkResourceTypeWebSocket:
+		return filterTypeWebSocket
+	case proto.NetworkResourceTypePing:
+		return filterTypePing
+	default:
+		return filterTypeOther
+	}
+}
+
+// isFilterSeparator ^ 匹配的分隔符，除了字母、数字以及 _ - . % 之外的字符
+func isFilterSeparator(c byte) bool {
+	return isFilterTokenChar(c) == false && c != '_' && c != '-' && c != '.'
+}
+
+func isFilterTokenChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '%'
+}
+
+func isFilterHostChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '.' || c == '-' || c == '_'
+}
+
+const (
+	filterTypeDocument uint32 = 1 << iota
+	filterTypeSubdocument
+	filterTypeStylesheet
+	filterTypeImage
+	filterTypeMedia
+	filterTypeFont
+	filterTypeScript
+	filterTypeXHR
+	filterTypeWebSocket
+	filterTypePing
+	filterTypeObject
+	filterTypePopup
+	filterTypeOther
+
+	// filterTypeAll 没有指定类型的规则匹配的类型，不包括页面本身
+	filterTypeAll = filterTypeSubdocument | filterTypeStylesheet | filterTypeImage | filterTypeMedia | filterTypeFont |
+		filterTypeScript | filterTypeXHR | filterTypeWebSocket | filterTypePing | filterTypeObject | filterTypeOther
+)
+
+var filterOptionTypes = map[string]uint32{
+	"document":       filterTypeDocument,
+	"doc":            filterTypeDocument,
+	"subdocument":    filterTypeSubdocument,
+	"frame":          filterTypeSubdocument,
+	"stylesheet":     filterTypeStylesheet,
+	"css":            filterTypeStylesheet,
+	"image":          filterTypeImage,
+	"media":          filterTypeMedia,
+	"font":           filterTypeFont,
+	"script":         filterTypeScript,
+	"xmlhttprequest": filterTypeXHR,
+	"xhr":            filterTypeXHR,
+	"websocket":      filterTypeWebSocket,
+	"ping":           filterTypePing,
+	"object":         filterTypeObject,
+	"popup":          filterTypePopup,
+	"other":          filterTypeOther,
+}
+
+// commonUrlTokens 几乎所有 Url 中都有的词，作为索引没有意义
+var commonUrlTokens = map[string]bool{
+	"http": true, "https": true, "www": true, "com": true, "net": true, "org": true,
+	"js": true, "html": true, "php": true,
+}
@@ -0,0 +1,111 @@
+package rod_helper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestFilterList(t *testing.T) {
+
+	rules := `[Adblock Plus 2.0]
+! Title: test list
+||ads.test^
+||tracker.test^$third-party
+||cdn.page.test/banner/*$image
+/ad-frame.
+|http://plain.test/pop|
+/\/track\/[0-9]+\//$script
+||video.test^$~media
+||fonts.test^$domain=news.test|~sports.news.test
+@@||ads.test/allowed/$script
+||forced.test^$important
+@@||forced.test^
+@@||whitelisted.test^$document
+page.test##.ad-banner
+||unsupported.test^$csp=script-src 'none'
+`
+	f := NewFilterList()
+	err := f.Parse(strings.NewReader(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.RuleCount() != 12 || f.SkipCount() != 2 {
+		t.Fatal("rule count error", f.RuleCount(), f.SkipCount())
+	}
+
+	script := proto.NetworkResourceTypeScript
+	cases := []struct {
+		url          string
+		sourceUrl    string
+		resourceType proto.NetworkResourceType
+		blocked      bool
+	}{
+		{"https://ads.test/a.js", "https://page.test/", script, true},
+		{"https://cdn.ads.test/a.js", "https://page.test/", script, true},
+		{"https://badads.test/a.js", "https://page.test/", script, false},
+		{"https://ads.test/allowed/a.js", "https://page.test/", script, false},
+		{"https://ads.test/allowed/a.png", "https://page.test/", proto.NetworkResourceTypeImage, true},
+		{"https://tracker.test/t.js", "https://page.test/", script, true},
+		{"https://tracker.test/t.js", "https://www.tracker.test/", script, false},
+		{"https://tracker.test/t.js", "", script, false},
+		{"https://cdn.page.test/banner/1.png", "", proto.NetworkResourceTypeImage, true},
+		{"https://cdn.page.test/banner/1.js", "", script, false},
+		{"https://page.test/x/ad-frame.html", "", proto.NetworkResourceTypeDocument, true},
+		{"http://plain.test/pop", "", script, true},
+		{"http://plain.test/pop/up", "", script, false},
+		{"https://page.test/track/123/p.js", "", script, true},
+		{"https://page.test/track/abc/p.js", "", script, false},
+		{"https://video.test/v.mp4", "", proto.NetworkResourceTypeMedia, false},
+		{"https://video.test/v.js", "", script, true},
+		{"https://fonts.test/f.woff2", "https://news.test/", proto.NetworkResourceTypeFont, true},
+		{"https://fonts.test/f.woff2", "https://sports.news.test/", proto.NetworkResourceTypeFont, false},
+		{"https://fonts.test/f.woff2", "https://other.test/", proto.NetworkResourceTypeFont, false},
+		{"https://forced.test/f.js", "", script, true},
+		{"https://ads.test/a.js", "https://whitelisted.test/page", script, false},
+	}
+	for _, c := range cases {
+		req := BlockRequest{Url: c.url, SourceUrl: c.sourceUrl, ResourceType: c.resourceType}
+		if f.Match(req) != c.blocked {
+			t.Fatal("filter list match error", c.url, c.sourceUrl, c.blocked)
+		}
+	}
+
+	// 没有指定 $document 的规则不拦截页面本身
+	if f.Match(BlockRequest{Url: "https://ads.test/", ResourceType: proto.NetworkResourceTypeDocument, MainFrame: true}) == true {
+		t.Fatal("main frame should not be blocked")
+	}
+
+	// 通过 RequestBlocker 使用
+	blocker, err := NewRequestBlocker(BlockRules{FilterLists: []*FilterList{f}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocker.Match("https://ads.test/a.js", script) == false {
+		t.Fatal("request blocker with filter list error")
+	}
+}
+
+func TestMatchFilterPattern(t *testing.T) {
+
+	cases := []struct {
+		pattern   string
+		s         string
+		endAnchor bool
+		matched   bool
+	}{
+		{"example.com^", "example.com/a", false, true},
+		{"example.com^", "example.com", false, true},
+		{"example.com^", "example.com.cn/", false, false},
+		{"a*b^", "a-x-b?c", false, true},
+		{"a*b", "a-x-c", false, false},
+		{"a/b", "a/b/c", true, false},
+		{"a/*", "a/b/c", true, true},
+	}
+	for _, c := range cases {
+		if matchFilterPattern(c.pattern, c.s, c.endAnchor) != c.matched {
+			t.Fatal("match filter pattern error", c.pattern, c.s)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package rod_helper
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FailureCategory 节点测试失败的分类
+type FailureCategory string
+
+const (
+	FailureNone        FailureCategory = ""
+	FailureTimeout     FailureCategory = "timeout"      // 连接或者加载超时
+	FailureNetwork     FailureCategory = "network"      // 连接失败、代理错误等
+	FailureStatusCode  FailureCategory = "status_code"  // 状态码不符合期望，比如 403
+	FailureSuccessWord FailureCategory = "success_word" // 没有包含成功关键词
+	FailureXPath       FailureCategory = "xpath"        // 必须存在的元素没有出现
+	FailureCanceled    FailureCategory = "canceled"     // Filter 被取消
+	FailureSlow        FailureCategory = "slow"         // 通过了测试，但是不在最快的 FilterInfo.KeepFastest 个节点中
+	FailureDuplicateIP FailureCategory = "duplicate_ip" // 通过了测试，但是同一组出口中已经有更快的节点，见 FilterInfo.DedupExitIP
+	FailureAnonymity   FailureCategory = "anonymity"    // 通过了测试，但是匿名程度低于 FilterInfo.MinAnonymity
+	FailureOther       FailureCategory = "other"
+)
+
+// PageLoadError TryLoadUrl、TryLoadPage 返回的错误，带有失败的分类以及状态码，Error() 与原始的错误一致
+type PageLoadError struct {
+	Category   FailureCategory
+	StatusCode int // 没有收到响应的时候为 0
+	Err        error
+}
+
+func newPageLoadError(category FailureCategory, statusCode int, err error) *PageLoadError {
+	return &PageLoadError{Category: category, StatusCode: statusCode, Err: err}
+}
+
+func (e *PageLoadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PageLoadError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyPageLoadError 获取错误的分类以及状态码
+func ClassifyPageLoadError(err error) (FailureCategory, int) {
+
+	if err == nil {
+		return FailureNone, 0
+	}
+	var loadErr *PageLoadError
+	if errors.As(err, &loadErr) == true {
+		return loadErr.Category, loadErr.StatusCode
+	}
+	if errors.Is(err, context.Canceled) == true {
+		return FailureCanceled, 0
+	}
+	if errors.Is(err, context.DeadlineExceeded) == true {
+		return FailureTimeout, 0
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) == true {
+		if netErr.Timeout() == true {
+			return FailureTimeout, 0
+		}
+		return FailureNetwork, 0
+	}
+	if errors.Is(err, ErrPageLoadFailed) == true {
+		return FailureXPath, 0
+	}
+	return FailureOther, 0
+}
+
+// PageResult 一个节点测试一个 PageInfo 的结果
+type PageResult struct {
+	Stage           int             `json:"stage"`   // 第几个测试阶段，从 0 开始
+	Attempt         int             `json:"attempt"` // 第几次尝试，从 0 开始，大于 0 的是重试
+	PageName        string          `json:"page_name"`
+	Url             string          `json:"url"`
+	Passed          bool            `json:"passed"`
+	StatusCode      int             `json:"status_code"`
+	LatencyMs       int64           `json:"latency_ms"`
+	FailureCategory FailureCategory `json:"failure_category,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	Time            int64           `json:"time"` // 测试完成的 UnixTime
+}
+
+// ProxyFilterResult 一个节点的测试结果，PageResults 只包含实际测试了的页面，一个页面失败后后续的页面不会再测试
+type ProxyFilterResult struct {
+	ProxyID         string          `json:"proxy_id"`
+	ProxyName       string          `json:"proxy_name"`
+	ProxyIndex      int             `json:"proxy_index"`
+	Passed          bool            `json:"passed"`
+	AvgLatencyMs    int64           `json:"avg_latency_ms"`
+	FailureCategory FailureCategory `json:"failure_category,omitempty"`
+	ExitIP          string          `json:"exit_ip,omitempty"`
+	Anonymity       string          `json:"anonymity,omitempty"` // 设置了 FilterInfo.MinAnonymity 的时候检查的匿名程度
+	PageResults     []PageResult    `json:"page_results"`
+}
+
+// avgPageLatencyMs 通过的页面的平均耗时
+func (r *ProxyFilterResult) avgPageLatencyMs() int64 {
+
+	latencySum := int64(0)
+	passedCount := int64(0)
+	for _, pageResult := range r.PageResults {
+		if pageResult.Passed == true {
+			latencySum += pageResult.LatencyMs
+			passedCount++
+		}
+	}
+	if passedCount < 1 {
+		return 0
+	}
+	return latencySum / passedCount
+}
+
+// FilterStats 一次 Filter 的汇总统计
+type FilterStats struct {
+	Total         int                     `json:"total"`
+	Passed        int                     `json:"passed"`
+	Failed        int                     `json:"failed"`
+	PassRate      float64                 `json:"pass_rate"`
+	FailureCounts map[FailureCategory]int `json:"failure_counts"`
+	MinLatencyMs  int64                   `json:"min_latency_ms"`
+	MaxLatencyMs  int64                   `json:"max_latency_ms"`
+	AvgLatencyMs  int64                   `json:"avg_latency_ms"`
+	ExitIPCount   int                     `json:"exit_ip_count"` // 通过的节点中不同的出口 IP 的数量
+}
+
+// FilterReport 一次 Filter 的详细报告，会保存在 CacheLayout.ReportDir 中，见 Pool.GetFilterReports
+type FilterReport struct {
+	KeyName      string               `json:"key_name"`
+	LoadType     TryLoadType          `json:"load_type"`
+	StartTime    int64                `json:"start_time"`
+	EndTime      int64                `json:"end_time"`
+	Skipped      bool                 `json:"skipped"`                  // 缓存还没有过期，这次没有进行测试
+	Incremental  bool                 `json:"incremental"`              // 是否是增量模式
+	KeptProxyIDs []string             `json:"kept_proxy_ids,omitempty"` // 增量模式下保留的节点，没有重新测试，不在 Proxies 中
+	StagePassed  []int                `json:"stage_passed"`             // 每个测试阶段通过的节点数量
+	Proxies      []*ProxyFilterResult `json:"proxies"`
+	Stats        FilterStats          `json:"stats"`
+}
+
+func newFilterReport(keyName string, loadType TryLoadType) *FilterReport {
+	return &FilterReport{
+		KeyName:   keyName,
+		LoadType:  loadType,
+		StartTime: time.Now().Unix(),
+		Proxies:   make([]*ProxyFilterResult, 0),
+		Stats:     FilterStats{FailureCounts: make(map[FailureCategory]int)},
+	}
+}
+
+// PassedProxyIDs 测试通过的节点
+func (r *FilterReport) PassedProxyIDs() []string {
+
+	ids := make([]string, 0)
+	for _, proxyResult := range r.Proxies {
+		if proxyResult.Passed == true {
+			ids = append(ids, proxyResult.ProxyID)
+		}
+	}
+	return ids
+}
+
+// finish 按节点的顺序排列结果，并计算汇总统计
+func (r *FilterReport) finish() {
+
+	r.EndTime = time.Now().Unix()
+	sort.Slice(r.Proxies, func(i, j int) bool {
+		return r.Proxies[i].ProxyIndex < r.Proxies[j].ProxyIndex
+	})
+
+	stats := FilterStats{FailureCounts: make(map[FailureCategory]int)}
+	exitIPs := make(map[string]bool)
+	latencySum := int64(0)
+	for _, proxyResult := range r.Proxies {
+		stats.Total++
+		if proxyResult.Passed == false {
+			stats.Failed++
+			stats.FailureCounts[proxyResult.FailureCategory]++
+			continue
+		}
+		stats.Passed++
+		if proxyResult.ExitIP != "" {
+			exitIPs[proxyResult.ExitIP] = true
+		}
+		latencySum += proxyResult.AvgLatencyMs
+		if stats.Passed == 1 || proxyResult.AvgLatencyMs < stats.MinLatencyMs {
+			stats.MinLatencyMs = proxyResult.AvgLatencyMs
+		}
+		if proxyResult.AvgLatencyMs > stats.MaxLatencyMs {
+			stats.MaxLatencyMs = proxyResult.AvgLatencyMs
+		}
+	}
+	if stats.Total > 0 {
+		stats.PassRate = float64(stats.Passed) / float64(stats.Total)
+	}
+	if stats.Passed > 0 {
+		stats.AvgLatencyMs = latencySum / int64(stats.Passed)
+	}
+	stats.ExitIPCount = len(exitIPs)
+	r.Stats = stats
+}
+
+// FilterReportDiff 两次 Filter 结果的差异
+type FilterReportDiff struct {
+	NewlyPassed []string // 之前没有通过，这次通过的节点
+	NewlyFailed []string // 之前通过了，这次没有通过的节点
+}
+
+// CompareFilterReport 对比两次 Filter 的结果，之前没有测试过的节点视为没有通过
+func CompareFilterReport(oldReport, newReport *FilterReport) FilterReportDiff {
+
+	oldPassed := make(map[string]bool)
+	for _, proxyResult := range oldReport.Proxies {
+		oldPassed[proxyResult.ProxyID] = proxyResult.Passed
+	}
+	diff := FilterReportDiff{NewlyPassed: make([]string, 0), NewlyFailed: make([]string, 0)}
+	for _, proxyResult := range newReport.Proxies {
+		if proxyResult.Passed == true && oldPassed[proxyResult.ProxyID] == false {
+			diff.NewlyPassed = append(diff.NewlyPassed, proxyResult.ProxyID)
+		} else if proxyResult.Passed == false && oldPassed[proxyResult.ProxyID] == true {
+			diff.NewlyFailed = append(diff.NewlyFailed, proxyResult.ProxyID)
+		}
+	}
+	return diff
+}
+
+// saveFilterReport 保存报告，每个 KeyName 只保留最近 maxFilterReportCount 份
+func saveFilterReport(store StateStore, report *FilterReport) error {
+
+	err := store.Save(fmt.Sprintf(filterReportFileName, report.KeyName, nextFilterReportSeq()), report)
+	if err != nil {
+		return err
+	}
+
+	reportKeys, err := findFilterReportKeys(store, report.KeyName)
+	if err != nil {
+		return err
+	}
+	for i := maxFilterReportCount; i < len(reportKeys); i++ {
+		_ = store.Delete(reportKeys[i])
+	}
+	return nil
+}
+
+// GetFilterReports 获取这个 KeyName 保存的 Filter 报告，最新的在前
+func (b *Pool) GetFilterReports(keyName string) ([]*FilterReport, error) {
+	return getFilterReports(b.reportStore, keyName)
+}
+
+// GetFilterReports 获取缓存根目录为当前目录的时候保存的这个 KeyName 的 Filter 报告，最新的在前
+// 设置了 PoolOptions 的 CacheRootDirPath 或者 StateStore 的时候使用 Pool.GetFilterReports
+func GetFilterReports(keyName string) ([]*FilterReport, error) {
+	return getFilterReports(NewFileStateStore(NewCacheLayout("").ReportDir()), keyName)
+}
+
+func getFilterReports(store StateStore, keyName string) ([]*FilterReport, error) {
+
+	reportKeys, err := findFilterReportKeys(store, keyName)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*FilterReport, 0, len(reportKeys))
+	for _, reportKey := range reportKeys {
+		report := &FilterReport{}
+		err = store.Load(reportKey, report)
+		if err != nil {
+			if errors.Is(err, ErrStateNotFound) == true {
+				// 其他进程刚刚清理掉了
+				continue
+			}
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// nextFilterReportSeq 报告 key 中的序号，纳秒的时间，同一秒内的多次 Filter 不会互相覆盖
+// 时钟精度不够的系统上也保证递增
+func nextFilterReportSeq() int64 {
+
+	filterReportSeqLocker.Lock()
+	defer filterReportSeqLocker.Unlock()
+	seq := time.Now().UnixNano()
+	if seq <= lastFilterReportSeq {
+		seq = lastFilterReportSeq + 1
+	}
+	lastFilterReportSeq = seq
+	return seq
+}
+
+var (
+	lastFilterReportSeq   int64
+	filterReportSeqLocker sync.Mutex
+)
+
+// findFilterReportKeys 这个 KeyName 的报告，最新的在前
+func findFilterReportKeys(store StateStore, keyName string) ([]string, error) {
+
+	prefix := fmt.Sprintf(filterReportFilePrefix, keyName)
+	keys, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	type reportKey struct {
+		key string
+		seq int64 // 之前的版本是 StartTime 的秒数，都比纳秒的序号小
+	}
+	reportKeys := make([]reportKey, 0, len(keys))
+	for _, key := range keys {
+		var seq int64
+		_, err = fmt.Sscanf(strings.TrimPrefix(key, prefix), "%d.json", &seq)
+		if err != nil {
+			// KeyName 是另一个 KeyName 的前缀的情况
+			continue
+		}
+		reportKeys = append(reportKeys, reportKey{key, seq})
+	}
+	sort.Slice(reportKeys, func(i, j int) bool {
+		return reportKeys[i].seq > reportKeys[j].seq
+	})
+	outKeys := make([]string, 0, len(reportKeys))
+	for _, nowKey := range reportKeys {
+		outKeys = append(outKeys, nowKey.key)
+	}
+	return outKeys, nil
+}
+
+const (
+	filterReportFilePrefix = "filter_report_%s_"
+	filterReportFileName   = filterReportFilePrefix + "%d.json"
+	maxFilterReportCount   = 10
+)
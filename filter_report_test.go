@@ -0,0 +1,96 @@
+package rod_helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/WQGroup/logger"
+)
+
+func TestClassifyPageLoadError(t *testing.T) {
+
+	category, statusCode := ClassifyPageLoadError(fmt.Errorf("wrap: %w", newPageLoadError(FailureStatusCode, 403, errors.New("403"))))
+	if category != FailureStatusCode || statusCode != 403 {
+		t.Fatal("classify PageLoadError error", category, statusCode)
+	}
+	if category, _ = ClassifyPageLoadError(context.DeadlineExceeded); category != FailureTimeout {
+		t.Fatal("classify timeout error", category)
+	}
+	if category, _ = ClassifyPageLoadError(newPageLoadError(FailureXPath, 200, ErrPageLoadFailed)); category != FailureXPath {
+		t.Fatal("classify xpath error", category)
+	}
+	if errors.Is(newPageLoadError(FailureXPath, 200, ErrPageLoadFailed), ErrPageLoadFailed) == false {
+		t.Fatal("PageLoadError should unwrap")
+	}
+}
+
+func TestFilterReport(t *testing.T) {
+
+	oldReport := newFilterReport("imdb", WebPageWithHttpClient)
+	oldReport.Proxies = append(oldReport.Proxies,
+		&ProxyFilterResult{ProxyID: "a", ProxyIndex: 0, Passed: true, AvgLatencyMs: 100},
+		&ProxyFilterResult{ProxyID: "b", ProxyIndex: 1, FailureCategory: FailureTimeout},
+	)
+	oldReport.finish()
+
+	newReport := newFilterReport("imdb", WebPageWithHttpClient)
+	newReport.Proxies = append(newReport.Proxies,
+		&ProxyFilterResult{ProxyID: "c", ProxyIndex: 2, Passed: true, AvgLatencyMs: 300},
+		&ProxyFilterResult{ProxyID: "a", ProxyIndex: 0, FailureCategory: FailureStatusCode},
+		&ProxyFilterResult{ProxyID: "b", ProxyIndex: 1, Passed: true, AvgLatencyMs: 100},
+	)
+	newReport.finish()
+	if newReport.Proxies[0].ProxyID != "a" || newReport.Stats.Passed != 2 || newReport.Stats.Failed != 1 ||
+		newReport.Stats.FailureCounts[FailureStatusCode] != 1 || newReport.Stats.AvgLatencyMs != 200 ||
+		newReport.Stats.MinLatencyMs != 100 || newReport.Stats.MaxLatencyMs != 300 {
+		t.Fatal("report stats error", newReport.Stats)
+	}
+
+	diff := CompareFilterReport(oldReport, newReport)
+	if len(diff.NewlyPassed) != 2 || len(diff.NewlyFailed) != 1 || diff.NewlyFailed[0] != "a" {
+		t.Fatal("compare report error", diff)
+	}
+}
+
+func TestPoolGetFilterReports(t *testing.T) {
+
+	// 默认的文件存储，报告和过滤结果分别保存在 ReportDir、ProxyCacheDir 中
+	options := NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{})
+	options.SetCacheRootDirPath(t.TempDir())
+	p := newEmptyPool(options, nil)
+
+	for i := 0; i < maxFilterReportCount+2; i++ {
+		report := newFilterReport("imdb", WebPageWithHttpClient)
+		report.StartTime = int64(1000 + i)
+		report.finish()
+		err := saveFilterReport(p.reportStore, report)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	reports, err := p.GetFilterReports("imdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != maxFilterReportCount || reports[0].StartTime != int64(1000+maxFilterReportCount+1) {
+		t.Fatal("get filter reports error", len(reports))
+	}
+	// 同一秒内的两次 Filter 不能互相覆盖
+	sameSecond := time.Now().Unix()
+	for i := 0; i < 2; i++ {
+		report := newFilterReport("same", WebPageWithHttpClient)
+		report.StartTime = sameSecond
+		report.Skipped = i == 1
+		err = saveFilterReport(p.reportStore, report)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	reports, err = p.GetFilterReports("same")
+	if err != nil || len(reports) != 2 || reports[0].Skipped == false {
+		t.Fatal("reports in the same second should not overwrite each other", err, len(reports))
+	}
+}
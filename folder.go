@@ -6,18 +6,9 @@ import (
 	"path/filepath"
 )
 
-// GetRodTmpRootFolder 在程序的根目录新建，rod 缓存用文件夹
+// GetRodTmpRootFolder 在程序的根目录新建，rod 缓存用文件夹，见 CacheLayout.RodUserDataDir
 func GetRodTmpRootFolder(nowProcessRoot string) string {
-
-	if nowProcessRoot == "" {
-		nowProcessRoot = "."
-	}
-	nowProcessRoot = filepath.Join(nowProcessRoot, RodCacheFolder)
-	err := os.MkdirAll(nowProcessRoot, os.ModePerm)
-	if err != nil {
-		logger.Panicln(err)
-	}
-	return nowProcessRoot
+	return mustMkdir(NewCacheLayout(nowProcessRoot).RodUserDataDir())
 }
 
 // ClearRodTmpRootFolder 清理 rod 缓存文件夹
@@ -60,54 +51,37 @@ func GetTmpFolderByName(nowProcessRoot, folderName string) string {
 	return tmpFolderFullPath
 }
 
-// GetADBlockFolder 在程序的根目录新建，adblock 缓存用文件夹
+// GetADBlockFolder 在程序的根目录新建，adblock 缓存用文件夹，见 CacheLayout.ADBlockDir
 func GetADBlockFolder(nowProcessRoot string) string {
-
-	if nowProcessRoot == "" {
-		nowProcessRoot = "."
-	}
-	nowProcessRoot = filepath.Join(nowProcessRoot, PluginFolder, ADBlockFolder)
-	err := os.MkdirAll(nowProcessRoot, os.ModePerm)
-	if err != nil {
-		logger.Panicln(err)
-	}
-	return nowProcessRoot
+	return mustMkdir(NewCacheLayout(nowProcessRoot).ADBlockDir())
 }
 
-// GetProxyCacheFolder 代理索引缓存目录
+// GetProxyCacheFolder 代理索引缓存目录，见 CacheLayout.ProxyCacheDir
 func GetProxyCacheFolder(nowProcessRoot string) string {
-
-	if nowProcessRoot == "" {
-		nowProcessRoot = "."
-	}
-	nowProcessRoot = filepath.Join(nowProcessRoot, ProxyCacheFolder)
-	err := os.MkdirAll(nowProcessRoot, os.ModePerm)
-	if err != nil {
-		logger.Panicln(err)
-	}
-	return nowProcessRoot
+	return mustMkdir(NewCacheLayout(nowProcessRoot).ProxyCacheDir())
 }
 
-// GetADBlockUnZipFolder 在程序的根目录新建，adblock 缓存用文件夹
+// GetADBlockUnZipFolder 在程序的根目录新建，adblock 缓存用文件夹，见 CacheLayout.ADBlockUnZipDir
 func GetADBlockUnZipFolder(nowProcessRoot string) string {
+	return mustMkdir(NewCacheLayout(nowProcessRoot).ADBlockUnZipDir())
+}
 
-	if nowProcessRoot == "" {
-		nowProcessRoot = "."
-	}
-	nowProcessRoot = GetADBlockFolder(nowProcessRoot)
-	nowProcessRoot = filepath.Join(nowProcessRoot, ADBlockUnZipFolder)
-	err := os.MkdirAll(nowProcessRoot, os.ModePerm)
+func mustMkdir(dirPath string) string {
+	err := os.MkdirAll(dirPath, os.ModePerm)
 	if err != nil {
 		logger.Panicln(err)
 	}
-	return nowProcessRoot
+	return dirPath
 }
 
-// 缓存文件的位置信息，都是在程序的根目录下的 cache 中
+// 缓存文件的位置信息，都在缓存的根目录下，见 CacheLayout
 const (
 	RodCacheFolder     = "rod"           // rod 的缓存目录
 	PluginFolder       = "Plugin"        // 插件的目录
 	ADBlockFolder      = "adblock"       // adblock
 	ADBlockUnZipFolder = "adblock_unzip" // adblock unzip
+	ExtensionFolder    = "extensions"    // ExtensionManager 缓存的插件
 	ProxyCacheFolder   = "proxy_cache"   // 代理索引缓存目录
+	ReportFolder       = "reports"       // Filter 报告目录
+	UACacheFolder      = "ua"            // UA 缓存目录
 )
@@ -0,0 +1,392 @@
+package rod_helper
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/pkg/errors"
+)
+
+// HijackConfig 页面请求拦截的设置，见 NewPageHijacker
+type HijackConfig struct {
+	ResourceTypes []proto.NetworkResourceType // 需要拦截的资源类型，为空的时候拦截全部类型，其他类型由浏览器直接发出
+	HijackUrls    []string                    // 需要拦截的 Url，通配符同 proto.FetchRequestPattern.URLPattern，为空的时候为 *
+	PassUrls      []string                    // 匹配的请求不拦截，直接由浏览器发出，通配符同上。视频等大文件请放行，拦截的请求 body 需要完整读入内存
+	Timeout       time.Duration               // 一个请求从发出到读取完 body 的超时时间，小于等于 0 的时候为 60 秒
+	MaxBodySize   int64                       // body 的大小上限，超过的请求失败，小于等于 0 不限制。拦截的 body 不是流式的，建议设置
+	OnTiming      func(timing HijackTiming)   // 每个请求结束的时候回调，会在多个协程中同时调用
+	Blocker       *RequestBlocker             // 匹配的请求直接失败，不受 ResourceTypes、HijackUrls 的限制，为空不拦截
+}
+
+func NewHijackConfig(resourceTypes ...proto.NetworkResourceType) HijackConfig {
+	return HijackConfig{ResourceTypes: resourceTypes}
+}
+
+func (c HijackConfig) getTimeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defProxyPageTimeOut
+	}
+	return c.Timeout
+}
+
+// fetchPatterns 资源类型和 Url 的组合，交给浏览器匹配，不需要拦截的请求不会暂停
+func (c HijackConfig) fetchPatterns() []*proto.FetchRequestPattern {
+
+	hijackUrls := c.HijackUrls
+	if len(hijackUrls) == 0 {
+		hijackUrls = []string{"*"}
+	}
+	resourceTypes := c.ResourceTypes
+	if len(resourceTypes) == 0 {
+		resourceTypes = []proto.NetworkResourceType{""}
+	}
+	patterns := make([]*proto.FetchRequestPattern, 0, len(hijackUrls)*len(resourceTypes))
+	for _, hijackUrl := range hijackUrls {
+		for _, resourceType := range resourceTypes {
+			patterns = append(patterns, &proto.FetchRequestPattern{
+				URLPattern:   hijackUrl,
+				ResourceType: resourceType,
+			})
+		}
+	}
+	return patterns
+}
+
+// HijackTiming 一个请求的耗时
+type HijackTiming struct {
+	Url          string
+	Method       string
+	ResourceType proto.NetworkResourceType
+	Hijacked     bool          // false 的时候是由浏览器直接发出的
+	Blocked      bool          // 被 HijackConfig.Blocker 拦截
+	StatusCode   int           // 代理返回的状态码
+	ConnReused   bool          // 是否复用了之前的连接
+	Proto        string        // HTTP/1.1 或者 HTTP/2.0
+	FirstByte    time.Duration // 从发出请求到收到第一个字节
+	Duration     time.Duration // 从收到拦截的事件到交给浏览器
+	Bytes        int64         // body 的大小
+	Err          error
+}
+
+// PageHijacker 页面的请求通过同一个代理的连接池发出，见 NewPageHijacker
+// 和 NewPageHijackRouter 一样，需要开启协程 Run()，不再使用的时候 Stop()
+type PageHijacker struct {
+	page          *rod.Page
+	client        *http.Client
+	config        HijackConfig
+	resourceTypes map[proto.NetworkResourceType]bool // 为空的时候拦截全部类型
+	hijackRegex   []*regexp.Regexp                   // 为空的时候拦截全部 Url
+	passRegex     []*regexp.Regexp
+	documentUrl   atomic.Value // 主框架最近加载的页面，Blocker 判断第三方请求时使用
+	wait          func()
+	cancel        context.CancelFunc
+}
+
+// NewPageHijacker 拦截 page 中符合 config 的请求，通过 proxyUrl 发出，proxyUrl 为空的时候直连
+// 同一个 proxyUrl 的页面共用一个保持连接的 http.Transport，https 的站点会尽量使用 HTTP/2
+// 拦截的请求不是流式返回给浏览器的：Fetch.fulfillRequest 需要一次性提交完整的 body，
+// 所以 body 会完整读入复用的缓冲区后再提交，而不是每个请求分配一次。大文件请用 PassUrls 放行或者设置 MaxBodySize
+func NewPageHijacker(page *rod.Page, proxyUrl string, config HijackConfig) (*PageHijacker, error) {
+
+	client, err := getHijackClient(proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+	h := &PageHijacker{
+		page:          page,
+		client:        client,
+		config:        config,
+		resourceTypes: make(map[proto.NetworkResourceType]bool),
+		hijackRegex:   globsToRegex(config.HijackUrls),
+		passRegex:     globsToRegex(config.PassUrls),
+	}
+	for _, resourceType := range config.ResourceTypes {
+		h.resourceTypes[resourceType] = true
+	}
+	patterns := config.fetchPatterns()
+	if config.Blocker != nil {
+		// 需要拦截的请求可能是任意类型，全部暂停，在 handle 中再区分
+		patterns = []*proto.FetchRequestPattern{{URLPattern: "*"}}
+	}
+	err = proto.FetchEnable{Patterns: patterns}.Call(page)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewPageHijacker FetchEnable")
+	}
+
+	ctx, cancel := context.WithCancel(page.GetContext())
+	h.cancel = cancel
+	h.wait = page.Context(ctx).EachEvent(func(e *proto.FetchRequestPaused) {
+		go h.handle(ctx, e)
+	})
+	return h, nil
+}
+
+// Run 开始处理请求，直到 Stop
+func (h *PageHijacker) Run() {
+	h.wait()
+}
+
+// Stop 停止拦截，之后的请求由浏览器直接发出
+func (h *PageHijacker) Stop() error {
+	h.cancel()
+	return proto.FetchDisable{}.Call(h.page)
+}
+
+func (h *PageHijacker) isBlocked(e *proto.FetchRequestPaused) bool {
+
+	documentUrl, _ := h.documentUrl.Load().(string)
+	req := newBlockRequest(e, h.page.FrameID, documentUrl)
+	if req.MainFrame == true {
+		h.documentUrl.Store(req.Url)
+	}
+	return h.config.Blocker.MatchRequest(req)
+}
+
+// shouldHijack 符合 ResourceTypes、HijackUrls 并且没有匹配 PassUrls 的请求才通过代理发出
+func (h *PageHijacker) shouldHijack(reqUrl string, resourceType proto.NetworkResourceType) bool {
+
+	if len(h.resourceTypes) > 0 && h.resourceTypes[resourceType] == false {
+		return false
+	}
+	if len(h.hijackRegex) > 0 {
+		matched := false
+		for _, reg := range h.hijackRegex {
+			if reg.MatchString(reqUrl) == true {
+				matched = true
+				break
+			}
+		}
+		if matched == false {
+			return false
+		}
+	}
+	for _, reg := range h.passRegex {
+		if reg.MatchString(reqUrl) == true {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *PageHijacker) handle(ctx context.Context, e *proto.FetchRequestPaused) {
+
+	start := time.Now()
+	timing := HijackTiming{
+		Url:          e.Request.URL,
+		Method:       e.Request.Method,
+		ResourceType: e.ResourceType,
+	}
+	defer func() {
+		timing.Duration = time.Since(start)
+		if h.config.OnTiming != nil {
+			h.config.OnTiming(timing)
+		}
+	}()
+
+	if h.config.Blocker != nil && h.isBlocked(e) == true {
+		timing.Blocked = true
+		timing.Err = proto.FetchFailRequest{RequestID: e.RequestID, ErrorReason: proto.NetworkErrorReasonBlockedByClient}.Call(h.page)
+		return
+	}
+	if h.shouldHijack(e.Request.URL, e.ResourceType) == false {
+		timing.Err = proto.FetchContinueRequest{RequestID: e.RequestID}.Call(h.page)
+		return
+	}
+
+	timing.Hijacked = true
+	fulfill, buf, err := loadHijackResponse(ctx, h.client, e, h.config.getTimeout(), h.config.MaxBodySize, &timing)
+	if buf != nil {
+		defer putHijackBuffer(buf)
+	}
+	if err != nil {
+		timing.Err = err
+		if ctx.Err() != nil {
+			return
+		}
+		_ = proto.FetchFailRequest{RequestID: e.RequestID, ErrorReason: proto.NetworkErrorReasonFailed}.Call(h.page)
+		return
+	}
+	timing.Err = fulfill.Call(h.page)
+}
+
+// loadHijackResponse 通过 client 发出浏览器暂停的请求，返回给浏览器的响应，body 引用了 buf，提交之后才能归还 buf
+// body 会完整读入 buf，不是流式的，见 NewPageHijacker
+func loadHijackResponse(ctx context.Context, client *http.Client, e *proto.FetchRequestPaused,
+	timeOut time.Duration, maxBodySize int64, timing *HijackTiming) (*proto.FetchFulfillRequest, *bytes.Buffer, error) {
+
+	var body io.Reader
+	if e.Request.HasPostData == true || e.Request.PostData != "" {
+		body = strings.NewReader(e.Request.PostData)
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, timeOut)
+	defer cancel()
+	reqStart := time.Now()
+	reqCtx = httptrace.WithClientTrace(reqCtx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			timing.ConnReused = info.Reused
+		},
+		GotFirstResponseByte: func() {
+			timing.FirstByte = time.Since(reqStart)
+		},
+	})
+	req, err := http.NewRequestWithContext(reqCtx, e.Request.Method, e.Request.URL, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range e.Request.Headers {
+		req.Header.Set(k, v.String())
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	timing.StatusCode = res.StatusCode
+	timing.Proto = res.Proto
+
+	if maxBodySize > 0 && res.ContentLength > maxBodySize {
+		return nil, nil, ErrHijackBodyTooLarge
+	}
+	reader := io.Reader(res.Body)
+	if maxBodySize > 0 {
+		reader = io.LimitReader(res.Body, maxBodySize+1)
+	}
+	buf := getHijackBuffer()
+	n, err := io.Copy(buf, reader)
+	timing.Bytes = n
+	if err != nil {
+		putHijackBuffer(buf)
+		return nil, nil, err
+	}
+	if maxBodySize > 0 && n > maxBodySize {
+		putHijackBuffer(buf)
+		return nil, nil, ErrHijackBodyTooLarge
+	}
+
+	fulfill := &proto.FetchFulfillRequest{
+		RequestID:       e.RequestID,
+		ResponseCode:    res.StatusCode,
+		ResponseHeaders: make([]*proto.FetchHeaderEntry, 0, len(res.Header)),
+		Body:            buf.Bytes(),
+	}
+	for k, vs := range res.Header {
+		for _, v := range vs {
+			fulfill.ResponseHeaders = append(fulfill.ResponseHeaders, &proto.FetchHeaderEntry{Name: k, Value: v})
+		}
+	}
+	return fulfill, buf, nil
+}
+
+// getHijackClient 同一个 proxyUrl 共用一个 http.Transport，重定向交给浏览器处理
+func getHijackClient(proxyUrl string) (*http.Client, error) {
+
+	transport, err := getHijackTransport(proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+func getHijackTransport(proxyUrl string) (*http.Transport, error) {
+
+	hijackTransportLocker.Lock()
+	defer hijackTransportLocker.Unlock()
+
+	transport, found := hijackTransports[proxyUrl]
+	if found == true {
+		return transport, nil
+	}
+	var proxy func(*http.Request) (*url.URL, error)
+	if proxyUrl != "" {
+		u, err := url.Parse(proxyUrl)
+		if err != nil {
+			return nil, errors.Wrap(err, "getHijackTransport parse proxy url")
+		}
+		// http.Transport 同时支持 http 和 socks5 代理
+		proxy = http.ProxyURL(u)
+	}
+	transport = &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        1000,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 15 * time.Second,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+	}
+	hijackTransports[proxyUrl] = transport
+	return transport, nil
+}
+
+// CloseHijackTransport 关闭这个代理的空闲连接，节点被移除之后不再保留
+func CloseHijackTransport(proxyUrl string) {
+
+	hijackTransportLocker.Lock()
+	transport, found := hijackTransports[proxyUrl]
+	delete(hijackTransports, proxyUrl)
+	hijackTransportLocker.Unlock()
+	if found == true {
+		transport.CloseIdleConnections()
+	}
+}
+
+func globsToRegex(globs []string) []*regexp.Regexp {
+	regs := make([]*regexp.Regexp, 0, len(globs))
+	for _, glob := range globs {
+		regs = append(regs, regexp.MustCompile(proto.PatternToReg(glob)))
+	}
+	return regs
+}
+
+func getHijackBuffer() *bytes.Buffer {
+	buf := hijackBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putHijackBuffer(buf *bytes.Buffer) {
+	// 太大的缓冲区不保留，避免一个大文件一直占用内存
+	if buf.Cap() > maxPooledHijackBufferSize {
+		return
+	}
+	hijackBufferPool.Put(buf)
+}
+
+var ErrHijackBodyTooLarge = errors.New("hijack response body is too large")
+
+var (
+	hijackTransports      = make(map[string]*http.Transport)
+	hijackTransportLocker sync.Mutex
+	hijackBufferPool      = sync.Pool{
+		New: func() interface{} {
+			return new(bytes.Buffer)
+		},
+	}
+)
+
+const maxPooledHijackBufferSize = 4 * 1024 * 1024
@@ -0,0 +1,118 @@
+package rod_helper
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestLoadHijackResponse(t *testing.T) {
+
+	// 作为 http 代理，不论请求的是哪个地址都直接返回，记录新建的连接数量
+	var newConnCount int32
+	proxy := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://page.test/target", http.StatusFound)
+			return
+		}
+		w.Header().Set("X-Path", r.URL.Path)
+		_, _ = w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	proxy.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&newConnCount, 1)
+		}
+	}
+	proxy.Start()
+	defer proxy.Close()
+	defer CloseHijackTransport(proxy.URL)
+
+	client, err := getHijackClient(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transportA, _ := getHijackTransport(proxy.URL)
+	transportB, _ := getHijackTransport("")
+	if client.Transport != transportA || transportA == transportB {
+		t.Fatal("transport should be shared per proxy url")
+	}
+
+	load := func(path string, maxBodySize int64) (*proto.FetchFulfillRequest, HijackTiming, error) {
+		timing := HijackTiming{}
+		e := &proto.FetchRequestPaused{
+			RequestID: "1",
+			Request:   &proto.NetworkRequest{URL: "http://page.test" + path, Method: http.MethodGet},
+		}
+		fulfill, buf, err := loadHijackResponse(context.Background(), client, e, 5*time.Second, maxBodySize, &timing)
+		if buf != nil {
+			defer putHijackBuffer(buf)
+			// 归还之前复制一份，避免被下一个请求覆盖
+			fulfill.Body = append([]byte(nil), fulfill.Body...)
+		}
+		return fulfill, timing, err
+	}
+
+	for i := 0; i < 3; i++ {
+		fulfill, timing, err := load("/image.png", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fulfill.ResponseCode != http.StatusOK || len(fulfill.Body) != 100 || timing.Bytes != 100 {
+			t.Fatal("load hijack response error", fulfill.ResponseCode, len(fulfill.Body))
+		}
+		if i > 0 && timing.ConnReused == false {
+			t.Fatal("connection should be reused")
+		}
+	}
+	if atomic.LoadInt32(&newConnCount) != 1 {
+		t.Fatal("keep alive connection count error", atomic.LoadInt32(&newConnCount))
+	}
+
+	// 重定向交给浏览器处理
+	fulfill, _, err := load("/redirect", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fulfill.ResponseCode != http.StatusFound {
+		t.Fatal("redirect should not be followed", fulfill.ResponseCode)
+	}
+
+	_, _, err = load("/large", 10)
+	if err != ErrHijackBodyTooLarge {
+		t.Fatal("max body size error", err)
+	}
+}
+
+func TestHijackConfigPatterns(t *testing.T) {
+
+	config := NewHijackConfig(proto.NetworkResourceTypeDocument, proto.NetworkResourceTypeScript)
+	config.HijackUrls = []string{"*://a.test/*", "*://b.test/*"}
+	patterns := config.fetchPatterns()
+	if len(patterns) != 4 || patterns[1].URLPattern != "*://a.test/*" || patterns[1].ResourceType != proto.NetworkResourceTypeScript {
+		t.Fatal("fetch patterns error", len(patterns))
+	}
+	if patterns = NewHijackConfig().fetchPatterns(); len(patterns) != 1 || patterns[0].URLPattern != "*" {
+		t.Fatal("default fetch patterns error")
+	}
+
+	h := &PageHijacker{passRegex: globsToRegex([]string{"*.woff2"})}
+	if h.shouldHijack("https://a.test/font.woff2", "") == true || h.shouldHijack("https://a.test/app.js", "") == false {
+		t.Fatal("pass urls error")
+	}
+	h = &PageHijacker{
+		resourceTypes: map[proto.NetworkResourceType]bool{proto.NetworkResourceTypeScript: true},
+		hijackRegex:   globsToRegex([]string{"*://a.test/*"}),
+	}
+	if h.shouldHijack("https://a.test/app.js", proto.NetworkResourceTypeScript) == false ||
+		h.shouldHijack("https://a.test/a.png", proto.NetworkResourceTypeImage) == true ||
+		h.shouldHijack("https://b.test/app.js", proto.NetworkResourceTypeScript) == true {
+		t.Fatal("hijack selection error")
+	}
+}
@@ -1,8 +1,10 @@
 package rod_helper
 
 import (
+	"context"
 	"crypto/tls"
 	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
 	"net/http"
 	"net/url"
 	"time"
@@ -60,6 +62,36 @@ func NewHttpClient(opt *HttpClientOptions) (*resty.Client, error) {
 	return httpClient, nil
 }
 
+// GetPublicIPWithHttpClient 同 GetPublicIP，使用 http client 访问，一个网站失败了会尝试下一个
+func GetPublicIPWithHttpClient(ctx context.Context, client *resty.Client, customDectIPSites []string) (string, error) {
+
+	publicIPSites := defPublicIPSites
+	if customDectIPSites != nil {
+		publicIPSites = customDectIPSites
+	}
+
+	var lastErr error
+	for _, publicIPSite := range publicIPSites {
+
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		res, err := client.R().SetContext(ctx).Get(publicIPSite)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		matcheds := ReMatchIP.FindAllString(string(res.Body()), -1)
+		if len(matcheds) >= 1 {
+			return matcheds[0], nil
+		}
+	}
+	if lastErr != nil {
+		return "", errors.Wrap(lastErr, "get public ip failed")
+	}
+	return "", errors.New("get public ip failed")
+}
+
 type HttpClientOptions struct {
 	htmlTimeOut    time.Duration
 	proxyType      ProxyType
@@ -90,6 +122,15 @@ func (h *HttpClientOptions) SetSocks5Proxy(SetSock5Proxy string) {
 	h.socks5ProxyUrl = SetSock5Proxy
 }
 
+// SetProxyInfo 根据节点的信息设置代理，优先使用 http 代理
+func (h *HttpClientOptions) SetProxyInfo(proxyInfo *XrayPoolProxyInfo) {
+	if proxyInfo.HttpUrl != "" {
+		h.SetHttpProxy(proxyInfo.HttpUrl)
+	} else if proxyInfo.SocksUrl != "" {
+		h.SetSocks5Proxy(proxyInfo.SocksUrl)
+	}
+}
+
 func (h *HttpClientOptions) ProxyUrl() (ProxyType, string) {
 	switch h.proxyType {
 	case None:
@@ -2,21 +2,31 @@ package rod_helper
 
 import (
 	"github.com/sirupsen/logrus"
+	"time"
 )
 
 type PoolOptions struct {
-	Log                  *logrus.Logger     // 日志
-	loadAdblock          bool               // 是否加载 adblock
-	loadPic              bool               // 是否加载图片
-	preLoadUrl           string             // 预加载的url
-	xrayPoolUrl          string             // xray pool url
-	xrayPoolPort         string             // xray pool port
-	browserInstanceCount int                // 浏览器最大的实例，xrayPoolUrl 有值的时候生效，用于爬虫。因为每启动一个实例就试用一个固定的代理，所以需要多个才行
-	cacheRootDirPath     string             // 缓存的根目录
-	browserFPath         string             // 浏览器的路径
-	timeConfig           TimeConfig         // 时间设置
-	successWordsConfig   SuccessWordsConfig // 成功的关键词
-	failWordsConfig      FailWordsConfig    // 失败的关键词
+	Log                  *logrus.Logger       // 日志
+	loadAdblock          bool                 // 是否加载 adblock
+	loadPic              bool                 // 是否加载图片
+	preLoadUrl           string               // 预加载的url
+	xrayPoolUrl          string               // xray pool url
+	xrayPoolPort         string               // xray pool port
+	browserInstanceCount int                  // 浏览器实例池最多的实例数量，见 Pool.AcquireBrowser
+	cacheRootDirPath     string               // 缓存的根目录，见 CacheLayout
+	cacheQuotaBytes      int64                // 缓存总大小的上限，小于等于 0 不限制
+	browserFPath         string               // 浏览器的路径
+	timeConfig           TimeConfig           // 时间设置
+	successWordsConfig   SuccessWordsConfig   // 成功的关键词
+	failWordsConfig      FailWordsConfig      // 失败的关键词
+	proxyRefreshInterval time.Duration        // 后台刷新代理列表的间隔，小于等于 0 不刷新
+	proxySource          ProxySource          // 代理节点的来源，为空的时候使用 xrayPoolUrl、xrayPoolPort 构建 XrayPoolSource
+	circuitBreakerConfig CircuitBreakerConfig // 以 (节点, KeyName) 为单位的熔断器设置
+	browserPoolConfig    BrowserPoolConfig    // 浏览器实例池的设置
+	hijackConfig         HijackConfig         // 通过代理加载的页面拦截哪些请求，见 NewPageHijacker
+	requestBlocker       *RequestBlocker      // 通过代理加载的页面中不需要发出的请求，比如图片、广告的域名
+	extensionDirs        []string             // 新建浏览器时额外加载的插件目录，见 ExtensionManager
+	stateStore           StateStore           // 过滤结果、节点状态、报告的存储，为空的时候保存在 CacheLayout 的 ProxyCacheDir、ReportDir 中
 }
 
 func NewPoolOptions(log *logrus.Logger, loadAdblock bool, loadPic bool, timeConfig TimeConfig) *PoolOptions {
@@ -25,7 +35,8 @@ func NewPoolOptions(log *logrus.Logger, loadAdblock bool, loadPic bool, timeConf
 		loadAdblock:          loadAdblock,
 		loadPic:              loadPic,
 		browserInstanceCount: 1,
-		timeConfig:           timeConfig}
+		timeConfig:           timeConfig,
+		circuitBreakerConfig: NewCircuitBreakerConfig(defFailureThreshold, defOpenDuration)}
 }
 
 func (r *PoolOptions) SetPreLoadUrl(url string) {
@@ -56,6 +67,7 @@ func (r *PoolOptions) XrayPoolPort() string {
 	return r.xrayPoolPort
 }
 
+// SetBrowserInstanceCount 设置浏览器实例池最多的实例数量，见 Pool.AcquireBrowser
 func (r *PoolOptions) SetBrowserInstanceCount(count int) {
 	r.browserInstanceCount = count
 }
@@ -63,6 +75,42 @@ func (r *PoolOptions) BrowserInstanceCount() int {
 	return r.browserInstanceCount
 }
 
+// SetBrowserPoolConfig 设置浏览器实例池中每个实例同时打开的页面数量、回收的策略
+func (r *PoolOptions) SetBrowserPoolConfig(config BrowserPoolConfig) {
+	r.browserPoolConfig = config
+}
+
+func (r *PoolOptions) BrowserPoolConfig() BrowserPoolConfig {
+	return r.browserPoolConfig
+}
+
+// SetHijackConfig 设置 TryLoadPage、NewPageWithProxy 拦截哪些请求走代理、超时时间以及每个请求耗时的回调
+func (r *PoolOptions) SetHijackConfig(config HijackConfig) {
+	r.hijackConfig = config
+}
+
+func (r *PoolOptions) HijackConfig() HijackConfig {
+	return r.hijackConfig
+}
+
+// SetRequestBlocker 设置 TryLoadPage、NewPageWithProxy 的页面中直接失败的请求，HijackConfig 中设置了 Blocker 的时候以那个为准
+func (r *PoolOptions) SetRequestBlocker(blocker *RequestBlocker) {
+	r.requestBlocker = blocker
+}
+
+func (r *PoolOptions) RequestBlocker() *RequestBlocker {
+	return r.requestBlocker
+}
+
+// SetExtensionDirs 设置新建浏览器时额外加载的插件目录，可以通过 ExtensionManager.LocalPaths 获取，和 LoadAdblock 可以同时使用
+func (r *PoolOptions) SetExtensionDirs(extensionDirs ...string) {
+	r.extensionDirs = extensionDirs
+}
+
+func (r *PoolOptions) ExtensionDirs() []string {
+	return r.extensionDirs
+}
+
 func (r *PoolOptions) SetLoadAdblock(loadAdblock bool) {
 	r.loadAdblock = loadAdblock
 }
@@ -95,6 +143,15 @@ func (r *PoolOptions) SetCacheRootDirPath(path string) {
 	r.cacheRootDirPath = path
 }
 
+// SetCacheQuota 设置缓存总大小的上限，单位是字节，超出的时候会删除不在使用的浏览器用户数据目录，见 CacheLayout.Cleanup
+func (r *PoolOptions) SetCacheQuota(quotaBytes int64) {
+	r.cacheQuotaBytes = quotaBytes
+}
+
+func (r *PoolOptions) CacheQuota() int64 {
+	return r.cacheQuotaBytes
+}
+
 func (r *PoolOptions) SetSuccessWordsConfig(successWordsConfig SuccessWordsConfig) {
 	r.successWordsConfig = successWordsConfig
 }
@@ -114,3 +171,39 @@ func (r *PoolOptions) GetFailWordsConfig() FailWordsConfig {
 func (r *PoolOptions) GetTimeConfig() TimeConfig {
 	return r.timeConfig
 }
+
+// SetProxyRefreshInterval 设置后台刷新 XrayPool 代理列表的间隔，小于等于 0 不刷新
+func (r *PoolOptions) SetProxyRefreshInterval(interval time.Duration) {
+	r.proxyRefreshInterval = interval
+}
+
+func (r *PoolOptions) ProxyRefreshInterval() time.Duration {
+	return r.proxyRefreshInterval
+}
+
+// SetProxySource 设置代理节点的来源，设置后 NewPool 不再需要 XrayPool
+func (r *PoolOptions) SetProxySource(source ProxySource) {
+	r.proxySource = source
+}
+
+func (r *PoolOptions) ProxySource() ProxySource {
+	return r.proxySource
+}
+
+// SetCircuitBreakerConfig 设置熔断器，需要在 NewPool 之前设置
+func (r *PoolOptions) SetCircuitBreakerConfig(config CircuitBreakerConfig) {
+	r.circuitBreakerConfig = config
+}
+
+func (r *PoolOptions) CircuitBreakerConfig() CircuitBreakerConfig {
+	return r.circuitBreakerConfig
+}
+
+// SetStateStore 设置过滤结果、节点状态、报告的存储，多个进程共用时使用 FileStateStore 是安全的，不需要持久化可以使用 MemoryStateStore
+func (r *PoolOptions) SetStateStore(store StateStore) {
+	r.stateStore = store
+}
+
+func (r *PoolOptions) StateStore() StateStore {
+	return r.stateStore
+}
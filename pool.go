@@ -5,18 +5,13 @@ import (
 	_ "embed"
 	"fmt"
 	"github.com/WQGroup/logger"
-	"github.com/go-resty/resty/v2"
 	"github.com/go-rod/rod/lib/proto"
-	"github.com/panjf2000/ants/v2"
-	"io/ioutil"
-	"path/filepath"
-	"regexp"
-	"strings"
+	"io"
+	"sort"
 
 	"github.com/pkg/errors"
 	"github.com/ysmood/gson"
 	"net/http"
-	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -28,84 +23,137 @@ import (
 
 type Pool struct {
 	log                       *logrus.Logger
-	rodOptions                *PoolOptions         // 参数
-	nowOrgProxyIndex          int                  // 当前使用的 http 代理的索引
-	httpProxyLocker           sync.Mutex           // http 代理的锁
-	lbHttpUrl                 string               // 负载均衡的 http proxy url
-	lbPort                    int                  // 负载均衡 http 端口
-	orgProxyInfos             []*XrayPoolProxyInfo // XrayPool 中的代理信息
-	filterProxyInfoIndexList  map[string][]int     // 过滤后的代理信息
-	nowFilterProxyInfoIndex   map[string]int       // 过滤后的代理信息的索引
-	filterProxyInfoUpdateTime map[string]int64     // 过滤后的代理信息的索引的更新时间
-	filterProxyLocker         sync.Mutex           // 过滤代理的锁
-	nowKeyName                string               // 当前使用的 keyName，如果是空，那么就是默认使用全部的代理列表，如果指定了，那么就是指定过滤后的列表
-}
-
-// NewPool 面向与爬虫的时候使用 Pool
+	rodOptions                *PoolOptions                    // 参数
+	httpProxyLocker           sync.Mutex                      // http 代理的锁
+	lbHttpUrl                 string                          // 负载均衡的 http proxy url
+	lbPort                    int                             // 负载均衡 http 端口
+	orgProxyInfos             []*XrayPoolProxyInfo            // XrayPool 中的代理信息
+	proxySource               ProxySource                     // 代理节点的来源
+	proxyIDIndex              map[string]int                  // 节点标识对应在 orgProxyInfos 中的索引
+	filterProxyIDList         map[string][]string             // 过滤后的代理节点标识
+	nowFilterProxyInfoIndex   map[string]int                  // 过滤后的代理信息的索引
+	filterProxyInfoUpdateTime map[string]int64                // 过滤后的代理信息的索引的更新时间
+	filterProxyLocker         sync.Mutex                      // 过滤代理的锁
+	nowKeyName                string                          // 当前使用的 keyName，如果是空，那么就是默认使用全部的代理列表，如果指定了，那么就是指定过滤后的列表
+	proxyChangedListeners     []func(event ProxyChangedEvent) // 代理列表变化的监听者
+	refreshStop               chan struct{}                   // 停止后台刷新
+	refreshWg                 sync.WaitGroup                  // 等待后台刷新退出
+	refreshLocker             sync.Mutex                      // 后台刷新启停的锁
+	proxyReady                chan struct{}                   // 有租约归还或者节点状态变化的时候关闭，用于唤醒等待者
+	closed                    bool                            // 已经 Close 了，等待节点的调用者都会返回 ErrPoolClosed
+	circuitBreaker            *CircuitBreaker                 // 以 (节点, KeyName) 为单位的熔断器
+	filterInfos               map[string]*FilterInfo          // Filter 时注册的测试页面，用于熔断后的探测
+	healthTracker             *HealthTracker                  // 以 (节点, KeyName) 为单位的健康信息
+	selectionStrategies       map[string]SelectionStrategy    // 每个 KeyName 选择节点的策略
+	defaultStrategy           SelectionStrategy               // 没有单独设置策略的 KeyName 使用的策略
+	keySelectors              map[string]*KeySelector         // 每个 KeyName 的选择器
+	selectorLocker            sync.Mutex                      // keySelectors 的锁
+	filterKeyLockers          map[string]*sync.Mutex          // 同一个 KeyName 同时只进行一个 Filter
+	scheduler                 *proxyScheduler                 // GetProxyInfoSync 的等待队列
+	filterProxyTestTime       map[string]map[string]int64     // 每个 KeyName 中节点最后一次通过测试的时间
+	filterTasks               map[string]*filterTask          // 后台定期重新过滤的任务
+	filterSchedulerCancel     context.CancelFunc              // 停止后台过滤
+	filterSchedulerWake       chan struct{}                   // 任务变化的时候唤醒后台过滤
+	filterSchedulerWg         sync.WaitGroup                  // 等待后台过滤退出
+	filterSchedulerLocker     sync.Mutex                      // 后台过滤任务的锁
+	lastExitGroups            map[string]string               // 每个 KeyName 最后给出的节点的出口分组
+	savedNodeStates           map[string]ProxyNodeState       // 本地保存的、还不在列表中的节点的运行时状态
+	nodeStateLocker           sync.Mutex                      // 保存节点状态的锁
+	nodeStateTimer            *time.Timer                     // 延迟保存节点状态，见 delaySaveProxyNodeState
+	nodeStateTimerStopped     bool                            // Close 之后不再延迟保存
+	nodeStateTimerLocker      sync.Mutex                      // nodeStateTimer 的锁
+	stateStore                StateStore                      // 过滤结果、节点状态的存储
+	reportStore               StateStore                      // Filter 报告的存储
+	cacheLayout               *CacheLayout                    // 缓存目录的布局
+	cacheCheckLocker          sync.Mutex                      // 检查缓存上限的锁
+	lastCacheCheckTime        time.Time                       // 最后一次检查缓存上限的时间
+	browserPool               *BrowserPool                    // 浏览器实例池，第一次使用的时候新建
+	browserPoolLocker         sync.Mutex                      // 新建浏览器实例池的锁
+}
+
+// NewPool 面向与爬虫的时候使用 Pool，没有通过 PoolOptions.SetProxySource 指定代理来源的时候，使用 XrayPool
 func NewPool(browserOptions *PoolOptions) *Pool {
 
-	// 从配置中，判断 XrayPool 是否启动
-	if browserOptions.XrayPoolUrl() == "" {
-		browserOptions.Log.Errorf("XrayPoolUrl is empty")
+	proxySource := browserOptions.ProxySource()
+	if proxySource == nil {
+		// 从配置中，判断 XrayPool 是否启动
+		if browserOptions.XrayPoolUrl() == "" {
+			browserOptions.Log.Errorf("XrayPoolUrl is empty")
+			return nil
+		}
+		if browserOptions.XrayPoolPort() == "" {
+			browserOptions.Log.Errorf("XrayPoolPort is empty")
+			return nil
+		}
+		proxySource = NewXrayPoolSource(browserOptions.XrayPoolUrl(), browserOptions.XrayPoolPort())
+	}
+	proxyInfos, err := proxySource.Fetch(context.Background())
+	if err != nil {
+		browserOptions.Log.Error(err)
 		return nil
 	}
-	if browserOptions.XrayPoolPort() == "" {
-		browserOptions.Log.Errorf("XrayPoolPort is empty")
+	if len(proxyInfos) < 1 {
+		browserOptions.Log.Error(proxySource.Name(), " ", ErrProxyInfosIsEmpty)
 		return nil
 	}
-	// 尝试从本地的 XrayPoolUrl 获取 代理信息
-	httpClient := resty.New().SetTransport(&http.Transport{
-		DisableKeepAlives:   true,
-		MaxIdleConns:        1000,
-		MaxIdleConnsPerHost: 1000,
-	})
 
-	var proxyResult ProxyResult
-	_, err := httpClient.R().
-		SetResult(&proxyResult).
-		Get(httpPrefix +
-			browserOptions.XrayPoolUrl() +
-			":" +
-			browserOptions.XrayPoolPort() +
-			"/v1/proxy_list")
+	b := newEmptyPool(browserOptions, proxySource)
+	// 清理崩溃的进程留下的浏览器用户数据目录
+	_, err = b.CleanupCache(defUserDataStaleTime)
 	if err != nil {
-		browserOptions.Log.Error(errors.New("Get error:" + err.Error()))
-		return nil
+		browserOptions.Log.Warningln("CleanupCache", err)
+	}
+	// 统一由 reconcileProxyInfos 去重以及设置 Index
+	b.reconcileProxyInfos(proxyInfos)
+	b.updateLoadBalance()
+	// 恢复上一次运行时节点的封禁、访问时间等状态
+	err = b.loadProxyNodeState()
+	if err != nil {
+		browserOptions.Log.Warningln("loadProxyNodeState", err)
 	}
 
-	if proxyResult.Status == "stopped" || len(proxyResult.OpenResultList) == 0 {
-		browserOptions.Log.Error("XrayPool Not Started!")
-		return nil
+	if browserOptions.ProxyRefreshInterval() > 0 {
+		b.StartProxyRefresh(browserOptions.ProxyRefreshInterval())
 	}
 
+	return b
+}
+
+// newEmptyPool 初始化一个还没有代理节点的 Pool
+func newEmptyPool(browserOptions *PoolOptions, proxySource ProxySource) *Pool {
+
 	b := &Pool{
 		log:           browserOptions.Log,
 		rodOptions:    browserOptions,
+		proxySource:   proxySource,
 		orgProxyInfos: make([]*XrayPoolProxyInfo, 0),
+		proxyIDIndex:  make(map[string]int),
 	}
-
-	for index, result := range proxyResult.OpenResultList {
-
-		// 单个节点的信息
-		tmpProxyInfos := XrayPoolProxyInfo{
-			Index:          index,
-			Name:           result.Name,
-			ProtoModel:     result.ProtoModel,
-			HttpUrl:        httpPrefix + browserOptions.XrayPoolUrl() + ":" + strconv.Itoa(result.HttpPort),
-			SocksUrl:       socksPrefix + browserOptions.XrayPoolUrl() + ":" + strconv.Itoa(result.SocksPort),
-			FirTimeAccess:  true,
-			skipAccessTime: 0,
-			lastAccessTime: 0,
-		}
-		b.orgProxyInfos = append(b.orgProxyInfos, &tmpProxyInfos)
-	}
-	b.lbPort = proxyResult.LBPort
-
-	b.lbHttpUrl = fmt.Sprintf(httpPrefix + browserOptions.XrayPoolUrl() + ":" + strconv.Itoa(b.lbPort))
-
-	b.filterProxyInfoIndexList = make(map[string][]int)
+	b.filterProxyIDList = make(map[string][]string)
 	b.nowFilterProxyInfoIndex = make(map[string]int)
 	b.filterProxyInfoUpdateTime = make(map[string]int64)
+	b.healthTracker = NewHealthTracker(defHealthAlpha)
+	b.selectionStrategies = make(map[string]SelectionStrategy)
+	b.defaultStrategy = NewRoundRobinStrategy()
+	b.keySelectors = make(map[string]*KeySelector)
+	b.scheduler = newProxyScheduler()
+	b.filterProxyTestTime = make(map[string]map[string]int64)
+	b.filterTasks = make(map[string]*filterTask)
+	b.filterSchedulerWake = make(chan struct{}, 1)
+	b.lastExitGroups = make(map[string]string)
+	b.savedNodeStates = make(map[string]ProxyNodeState)
+	b.cacheLayout = NewCacheLayout(browserOptions.CacheRootDirPath())
+	b.cacheLayout.SetQuota(browserOptions.CacheQuota())
+	b.stateStore = browserOptions.StateStore()
+	b.reportStore = b.stateStore
+	if b.stateStore == nil {
+		b.stateStore = NewFileStateStore(b.cacheLayout.ProxyCacheDir())
+		b.reportStore = NewFileStateStore(b.cacheLayout.ReportDir())
+	}
+	b.filterKeyLockers = make(map[string]*sync.Mutex)
+	b.circuitBreaker = NewCircuitBreaker(browserOptions.CircuitBreakerConfig())
+	b.filterInfos = make(map[string]*FilterInfo)
+	b.proxyReady = make(chan struct{})
 
 	return b
 }
@@ -117,270 +165,313 @@ func (b *Pool) GetOptions() *PoolOptions {
 
 // LBPort 负载均衡 http 端口
 func (b *Pool) LBPort() int {
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
 	return b.lbPort
 }
 
 // LBHttpUrl 负载均衡的 http proxy url
 func (b *Pool) LBHttpUrl() string {
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
 	return b.lbHttpUrl
 }
 
+// SetKeyName 设置 GetOneProxyInfo、GetProxyInfoSync 默认使用的 KeyName，多个协程爬取不同的网站请使用 ForKey
 func (b *Pool) SetKeyName(keyName string) error {
 
-	b.httpProxyLocker.Lock()
-	defer b.httpProxyLocker.Unlock()
-	b.nowKeyName = keyName
+	selector := b.ForKey(keyName)
 	// 当设置了现在需要获取的索引信息 KeyName 的时候
-	nowProxyInfoIndexs, ok := b.filterProxyInfoIndexList[b.nowKeyName]
+	b.filterProxyLocker.Lock()
+	nowProxyIDs, ok := b.filterProxyIDList[keyName]
+	b.filterProxyLocker.Unlock()
 	if ok == false {
 		return ErrKeyNameIsNotExist
 	}
-	if len(nowProxyInfoIndexs) < 1 {
+	if len(nowProxyIDs) < 1 {
 		return ErrProxyInfosIsEmpty
 	}
+
+	b.httpProxyLocker.Lock()
+	b.nowKeyName = keyName
+	b.httpProxyLocker.Unlock()
 	// 设置索引
-	b.nowFilterProxyInfoIndex[keyName] = b.filterProxyInfoIndexList[b.nowKeyName][0]
-	b.nowOrgProxyIndex = b.filterProxyInfoIndexList[b.nowKeyName][0]
+	selector.resetCursor()
 
 	return nil
 }
 
-// Filter 传入一批需要进行测试的 URL，然后过滤掉不可用的代理
-func (b *Pool) Filter(fInfo *FilterInfo, threadSize int, loadType TryLoadType) error {
+// nowSelector SetKeyName 设置的 KeyName 对应的选择器
+func (b *Pool) nowSelector() *KeySelector {
+	b.httpProxyLocker.Lock()
+	keyName := b.nowKeyName
+	b.httpProxyLocker.Unlock()
+	return b.ForKey(keyName)
+}
 
-	if len(b.orgProxyInfos) < 1 {
-		return ErrProxyInfosIsEmpty
+// Filter 传入一批需要进行测试的 URL，然后过滤掉不可用的代理，返回每个节点、每个页面的测试报告
+// 报告会保存在代理索引缓存的旁边，见 GetFilterReports。设置了 FilterInfo.Stages 的时候分阶段测试，threadSize 仅作为阶段的默认并发数，loadType 不再使用
+func (b *Pool) Filter(fInfo *FilterInfo, threadSize int, loadType TryLoadType) (*FilterReport, error) {
+	return b.FilterWithContext(context.Background(), fInfo, threadSize, loadType)
+}
+
+// FilterWithContext 同 Filter，ctx 结束的时候停止提交以及测试节点，保留之前的过滤结果并返回 ctx.Err() 以及已经完成的部分报告
+func (b *Pool) FilterWithContext(ctx context.Context, fInfo *FilterInfo, threadSize int, loadType TryLoadType) (*FilterReport, error) {
+
+	// 后台刷新可能会替换代理列表，这里使用当前的快照
+	proxyInfos := b.GetProxyInfos()
+	if len(proxyInfos) < 1 {
+		return nil, ErrProxyInfosIsEmpty
 	}
+	// 同一个 KeyName 同时只进行一个 Filter，不同的 KeyName 可以并发
+	keyLocker := b.getFilterKeyLocker(fInfo.KeyName)
+	keyLocker.Lock()
+	defer keyLocker.Unlock()
+
 	var err error
-	err = b.loadFilterProxyIndex()
+	err = b.loadFilterProxyIndex(fInfo.KeyName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// 但是还要考虑这个 fInfo.KeyName 是否有过滤列表了，且这个列表不为空
-	_, found := b.filterProxyInfoIndexList[fInfo.KeyName]
-	if found == true && len(b.filterProxyInfoIndexList[fInfo.KeyName]) > 0 {
-		// 如果找到了，才有必要判断下面这些
-		if b.filterProxyInfoUpdateTime[fInfo.KeyName] < time.Now().AddDate(0, 0, -1).Unix() {
-			// 如果缓存的时间超过了一天，那么就需要重新过滤
-		} else {
-			// 如果缓存的时间没有超过一天，那么就不需要重新过滤了
-			logger.Infoln("Pool.Filter", fInfo.KeyName, "Not Need Filter")
-			return nil
-		}
+	// 在写入新的结果之前创建选择器，避免之后第一次 ForKey 读取旧的缓存覆盖新的结果
+	selector := b.ForKey(fInfo.KeyName)
+	// 注册测试页面，熔断后的探测会使用
+	b.filterProxyLocker.Lock()
+	b.filterInfos[fInfo.KeyName] = fInfo
+	nowProxyIDs := b.filterProxyIDList[fInfo.KeyName]
+	updateTime := b.filterProxyInfoUpdateTime[fInfo.KeyName]
+	testTimes := make(map[string]int64)
+	for id, testTime := range b.filterProxyTestTime[fInfo.KeyName] {
+		testTimes[id] = testTime
+	}
+	b.filterProxyLocker.Unlock()
+	// 但是还要考虑这个 fInfo.KeyName 是否有过滤列表了，且这个列表不为空，以及是否在有效期内
+	now := time.Now()
+	if nextFilterTime(fInfo, nowProxyIDs, updateTime, testTimes).After(now) == true {
+		// 还在有效期内，那么就不需要重新过滤了
+		logger.Infoln("Pool.Filter", fInfo.KeyName, "Not Need Filter")
+		report := newFilterReport(fInfo.KeyName, loadType)
+		report.Skipped = true
+		report.finish()
+		return report, nil
 	}
 	// 如果没有找到，那么目标就应该是直接继续过滤
+	report := newFilterReport(fInfo.KeyName, loadType)
+	testProxyInfos := proxyInfos
+	if fInfo.Incremental == true && len(nowProxyIDs) > 0 {
+		// 增量模式，离过期还早的节点保留，其他的节点重新测试
+		report.Incremental = true
+		retestBefore := now.Add(fInfo.GetNearExpiry() - fInfo.GetCacheTTL()).Unix()
+		keptIDs := make(map[string]bool)
+		for _, id := range nowProxyIDs {
+			if proxyTestTime(testTimes, id, updateTime) > retestBefore {
+				keptIDs[id] = true
+				report.KeptProxyIDs = append(report.KeptProxyIDs, id)
+			}
+		}
+		testProxyInfos = make([]*XrayPoolProxyInfo, 0, len(proxyInfos))
+		for _, proxyInfo := range proxyInfos {
+			if keptIDs[proxyInfo.ID] == false {
+				testProxyInfos = append(testProxyInfos, proxyInfo)
+			}
+		}
+		logger.Infoln("Pool.Filter", fInfo.KeyName, "Incremental, Kept:", len(keptIDs), "Retest:", len(testProxyInfos))
+	}
 
-	statusCodeInfos := []StatusCodeInfo{
-		{
-			Codes:    []int{404},
-			Operator: Match,
-			WillDo:   Skip,
-		},
-		{
-			Codes:    []int{499},
-			Operator: GreatThan,
-			WillDo:   Skip,
-		},
-		{
-			Codes:    []int{403},
-			Operator: Match,
-			WillDo:   Repeat,
-		},
-	}
-
-	var nowBrowser *BrowserInfo
-	if loadType == WebPageWithBrowser {
-		nowBrowser, err = b.NewBrowser()
+	// 逐个阶段测试，只有通过了上一个阶段的节点才会进入下一个阶段
+	// 测试的结果先放在报告里，全部完成后再替换，中途取消不会影响之前的结果
+	logger.Infoln("Pool.Filter", fInfo.KeyName, "Start...")
+	proxyResults := make(map[string]*ProxyFilterResult)
+	survivors := testProxyInfos
+	for stageIndex, stage := range fInfo.GetStages(threadSize, loadType) {
+		if ctx.Err() != nil || len(survivors) < 1 {
+			break
+		}
+		survivors, err = b.runFilterStage(ctx, fInfo, stageIndex, stage, survivors, proxyResults, report)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		defer nowBrowser.Close()
+		report.StagePassed = append(report.StagePassed, len(survivors))
+		logger.Infoln("Pool.Filter", fInfo.KeyName, "Stage", stageIndex, "Passed:", len(survivors))
 	}
-	// 清理
-	b.filterProxyInfoIndexList[fInfo.KeyName] = make([]int, 0)
-	logger.Infoln("Pool.Filter", fInfo.KeyName, "Start...")
-	var wg sync.WaitGroup
-	p, err := ants.NewPoolWithFunc(threadSize, func(inData interface{}) {
-		deliveryInfo := inData.(DeliveryInfo)
-		defer func() {
-			deliveryInfo.Wg.Done()
-			logger.Infoln("Pool.Filter", deliveryInfo.ProxyInfo.Name, deliveryInfo.ProxyInfo.Index, "End")
-		}()
-
-		logger.Infoln("Pool.Filter", deliveryInfo.ProxyInfo.Name, deliveryInfo.ProxyInfo.Index, "Start...")
-
-		urlTestPassCount := 0
-		// 测试这节点
-		for _, pageInfo := range deliveryInfo.PageInfos {
-
-			if deliveryInfo.LoadType == WebPageWithHttpClient {
-				// 使用 http client 测试
-				speedResult, err := b.TryLoadUrl(deliveryInfo.ProxyInfo, pageInfo)
-				if err != nil {
-					// 只要一个失败就无需继续了
-					logger.Errorf("Pool.Filter TryLoadUrl error: %v", err)
-					break
-				}
-				logger.Infoln("Pool.Filter", deliveryInfo.ProxyInfo.Name, deliveryInfo.ProxyInfo.Index, pageInfo.Name, speedResult)
-
-			} else {
-				// 使用浏览器测试
-				speedResult, nowPage, err := b.TryLoadPage(deliveryInfo.Browser, deliveryInfo.ProxyInfo, pageInfo, statusCodeInfos, true)
-				if err != nil {
-					// 只要一个失败就无需继续了
-					logger.Errorf("Pool.Filter TryLoadPage error: %v", err)
-					break
-				}
-				if nowPage != nil {
-					_ = nowPage.Close()
+	if ctx.Err() == nil {
+		for _, proxyInfo := range survivors {
+			// 所有阶段都通过了，还需要经过下面的匿名程度、出口 IP 以及 KeepFastest 的淘汰
+			proxyResult := proxyResults[proxyInfo.ID]
+			proxyResult.Passed = true
+			proxyResult.AvgLatencyMs = proxyResult.avgPageLatencyMs()
+		}
+		// 被淘汰的节点也需要加入报告
+		passedSurvivors := survivors
+		if fInfo.MinAnonymity > AnonymityUnknown {
+			survivors, err = b.filterAnonymity(ctx, fInfo, survivors, proxyResults, threadSize)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if fInfo.VerifyExitIP == true {
+			err = b.resolveExitIPs(ctx, fInfo, survivors, proxyResults, threadSize)
+			if err != nil {
+				return nil, err
+			}
+			if fInfo.DedupExitIP == true {
+				// 增量模式下保留的节点的出口优先占用
+				keptExitGroups := make(map[string]bool)
+				b.httpProxyLocker.Lock()
+				for _, id := range report.KeptProxyIDs {
+					if index, found := b.proxyIDIndex[id]; found == true && b.orgProxyInfos[index].GetExitIP() != "" {
+						keptExitGroups[ExitIPGroup(b.orgProxyInfos[index].GetExitIP(), fInfo.ExitIPPrefix)] = true
+					}
 				}
-				logger.Infoln("Pool.Filter", deliveryInfo.ProxyInfo.Name, deliveryInfo.ProxyInfo.Index, pageInfo.Name, speedResult)
+				b.httpProxyLocker.Unlock()
+				survivors = dedupExitIP(fInfo, survivors, proxyResults, keptExitGroups)
 			}
-
-			urlTestPassCount += 1
 		}
-
-		if len(deliveryInfo.PageInfos) == urlTestPassCount {
-			// 需要所有的 PageInfos 都通过测试才能够继续
-			// 加入缓存列表
-			b.filterProxyLocker.Lock()
-			b.filterProxyInfoIndexList[fInfo.KeyName] = append(b.filterProxyInfoIndexList[fInfo.KeyName], deliveryInfo.ProxyInfo.Index)
-			b.filterProxyLocker.Unlock()
+		if fInfo.KeepFastest > 0 {
+			// 只保留最快的节点，增量模式下保留的节点优先占用名额
+			sort.SliceStable(survivors, func(i, j int) bool {
+				return proxyResults[survivors[i].ID].AvgLatencyMs < proxyResults[survivors[j].ID].AvgLatencyMs
+			})
+			keepCount := fInfo.KeepFastest - len(report.KeptProxyIDs)
+			if keepCount < 0 {
+				keepCount = 0
+			}
+			for i := keepCount; i < len(survivors); i++ {
+				proxyResult := proxyResults[survivors[i].ID]
+				proxyResult.Passed = false
+				proxyResult.FailureCategory = FailureSlow
+			}
 		}
-	})
-	if err != nil {
-		return err
-	}
-	// 过滤
-	for _, proxyInfo := range b.orgProxyInfos {
-		wg.Add(1)
-
-		err = p.Invoke(DeliveryInfo{
-			Browser:   nowBrowser,
-			ProxyInfo: proxyInfo,
-			PageInfos: fInfo.PageInfos,
-			Wg:        &wg,
-			LoadType:  loadType,
-		})
-		if err != nil {
-			logger.Errorf("Pool.Filter 工作池提交任务失败: %v", err)
-			return err
+		for _, proxyInfo := range passedSurvivors {
+			proxyResult := proxyResults[proxyInfo.ID]
+			if proxyResult.Passed == true {
+				// 最终留下的节点，之前的熔断也就不需要了，平均的耗时计入健康信息
+				b.recordProxySuccess(proxyInfo, fInfo.KeyName, time.Duration(proxyResult.AvgLatencyMs)*time.Millisecond)
+			}
+			report.Proxies = append(report.Proxies, proxyResult)
 		}
 	}
 
-	wg.Wait()
+	report.finish()
+	if ctx.Err() != nil {
+		logger.Infoln("Pool.Filter", fInfo.KeyName, "Canceled")
+		return report, ctx.Err()
+	}
+	passedProxyIDs := report.PassedProxyIDs()
+	newTestTimes := make(map[string]int64)
+	for _, id := range passedProxyIDs {
+		newTestTimes[id] = report.EndTime
+	}
+	for _, id := range report.KeptProxyIDs {
+		newTestTimes[id] = proxyTestTime(testTimes, id, updateTime)
+	}
+	// 复制一份，不能和报告共用底层的数组
+	passedProxyIDs = append(append([]string(nil), report.KeptProxyIDs...), passedProxyIDs...)
+	b.filterProxyLocker.Lock()
+	b.filterProxyIDList[fInfo.KeyName] = passedProxyIDs
+	b.filterProxyTestTime[fInfo.KeyName] = newTestTimes
 	// 设置索引
 	b.nowFilterProxyInfoIndex[fInfo.KeyName] = 0
 	// 设置这个缓存 KeyName 的更新时间
 	b.filterProxyInfoUpdateTime[fInfo.KeyName] = time.Now().Unix()
+	b.filterProxyLocker.Unlock()
+	selector.resetCursor()
 	// 缓存
-	b.saveFilterProxyIndex()
+	err = b.saveFilterProxyIndex(fInfo.KeyName)
+	if err != nil {
+		// 内存中的结果已经生效了，保存失败只影响重启后的恢复
+		logger.Errorln("Pool.Filter", fInfo.KeyName, err)
+	}
+	err = saveFilterReport(b.reportStore, report)
+	if err != nil {
+		logger.Errorln("Pool.Filter", fInfo.KeyName, "save filter report failed:", err)
+	}
 
-	if len(b.filterProxyInfoIndexList[fInfo.KeyName]) < 1 {
-		return errors.New("Pool.Filter " + fInfo.KeyName + " Filter Result is Empty")
+	if len(passedProxyIDs) < 1 {
+		return report, errors.New("Pool.Filter " + fInfo.KeyName + " Filter Result is Empty")
 	}
 
-	logger.Infoln("Pool.Filter", fInfo.KeyName, "End")
+	logger.Infoln("Pool.Filter", fInfo.KeyName, "End", "Passed:", report.Stats.Passed, "Total:", report.Stats.Total)
 
-	return nil
+	return report, nil
 }
 
-func (b *Pool) GetFilterProxyInfos(keyName string) ([]int, error) {
+// GetFilterProxyInfos 获取这个 KeyName 过滤后的代理节点，已经不在当前代理列表中的节点会被忽略
+func (b *Pool) GetFilterProxyInfos(keyName string) ([]*XrayPoolProxyInfo, error) {
+
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	b.filterProxyLocker.Lock()
+	defer b.filterProxyLocker.Unlock()
 	if len(b.orgProxyInfos) < 1 {
 		return nil, ErrProxyInfosIsEmpty
 	}
 
-	return b.filterProxyInfoIndexList[keyName], nil
-}
+	outProxyInfos := make([]*XrayPoolProxyInfo, 0)
+	for _, id := range b.filterProxyIDList[keyName] {
+		index, found := b.proxyIDIndex[id]
+		if found == false {
+			continue
+		}
+		outProxyInfos = append(outProxyInfos, b.orgProxyInfos[index])
+	}
 
-func (b *Pool) GetProxyInfos() []*XrayPoolProxyInfo {
-	return b.orgProxyInfos
+	return outProxyInfos, nil
 }
 
-// GetOneProxyInfo 轮询获取一个代理实例，直接给出这个代理的信息，不会考虑访问的频率问题
-func (b *Pool) GetOneProxyInfo() (*XrayPoolProxyInfo, error) {
-
+// GetProxyInfos 获取当前全部代理节点的快照
+func (b *Pool) GetProxyInfos() []*XrayPoolProxyInfo {
 	b.httpProxyLocker.Lock()
-	nowUnixTime := time.Now().Unix()
-
-	defer func() {
-		// 记录最后一次获取这个 Index ProxyInfo 的 UnixTime
-		b.orgProxyInfos[b.getNowProxyIndex()].lastAccessTime = nowUnixTime
-		// 下一个节点
-		b.addNowProxyIndex()
-		b.httpProxyLocker.Unlock()
-	}()
+	defer b.httpProxyLocker.Unlock()
 
-	if b.orgProxyInfos[b.getNowProxyIndex()].skipAccessTime > nowUnixTime {
-		// 这个节点需要跳过
-		return b.orgProxyInfos[b.getNowProxyIndex()], ErrSkipAccessTime
-	}
+	outProxyInfos := make([]*XrayPoolProxyInfo, len(b.orgProxyInfos))
+	copy(outProxyInfos, b.orgProxyInfos)
+	return outProxyInfos
+}
 
-	return b.orgProxyInfos[b.getNowProxyIndex()], nil
+// GetOneProxyInfo 按 SetKeyName 设置的 KeyName 获取一个代理实例，见 KeySelector.GetOneProxyInfo
+func (b *Pool) GetOneProxyInfo() (*XrayPoolProxyInfo, error) {
+	return b.nowSelector().GetOneProxyInfo()
 }
 
-// SetProxyNodeSkipByTime 设置这个节点，等待多少秒之后才可以被再次使用，仅仅针对 GetOneProxyInfo、GetProxyInfoSync 有效
-func (b *Pool) SetProxyNodeSkipByTime(index int, targetSkipTime int64) error {
+// SetProxyNodeSkipByTime 设置这个节点（XrayPoolProxyInfo.ID），targetSkipTime 这个 UnixTime 之后才可以被再次使用，仅仅针对 GetOneProxyInfo、GetProxyInfoSync 有效
+// 刷新代理列表后节点的 Index 可能变化，所以使用稳定的 ID
+func (b *Pool) SetProxyNodeSkipByTime(id string, targetSkipTime int64) error {
 
 	b.httpProxyLocker.Lock()
-	defer func() {
-		b.httpProxyLocker.Unlock()
-	}()
 
 	if len(b.orgProxyInfos) < 1 {
+		b.httpProxyLocker.Unlock()
 		return ErrProxyInfosIsEmpty
 	}
 
-	if index >= len(b.orgProxyInfos) {
-		return ErrIndexIsOutOfRange
+	index, found := b.proxyIDIndex[id]
+	if found == false {
+		b.httpProxyLocker.Unlock()
+		return ErrProxyNodeNotFound
 	}
 
-	b.log.Infoln("SetProxyNodeSkipByTime", index, targetSkipTime)
+	b.log.Infoln("SetProxyNodeSkipByTime", b.orgProxyInfos[index].Name, id, targetSkipTime)
 	b.orgProxyInfos[index].skipAccessTime = targetSkipTime
+	b.httpProxyLocker.Unlock()
+	// 封禁需要跨重启生效
+	b.saveProxyNodeState()
 	return nil
 }
 
-// GetProxyInfoSync 根据 TimeConfig 设置，寻找一个可用的节点。可以并发用，但是当前获取的节点，会根据访问时间，可能会有 sleep 阻塞等待
+// GetProxyInfoSync 根据 TimeConfig 设置，寻找一个可用的节点。可以并发用，没有可用节点的时候会排队阻塞等待，见 KeySelector.GetProxyInfoSync
 func (b *Pool) GetProxyInfoSync(baseUrl string) (*XrayPoolProxyInfo, error) {
+	return b.GetProxyInfoSyncWithContext(context.Background(), baseUrl)
+}
 
-	var outProxyInfo *XrayPoolProxyInfo
-	var err error
-	for {
-		// 获取下一个代理的信息
-		outProxyInfo, err = b.GetOneProxyInfo()
-		if err != nil {
-			// 这里的错误要区分一种，就是跳过的节点的情况xuejian
-			if errors.Is(err, ErrSkipAccessTime) {
-				// 可以接收的错误，等待循环
-				b.log.Debugln("Skip Access Time Proxy:", outProxyInfo.Name, outProxyInfo.Index, baseUrl)
-				<-time.After(time.Microsecond * 100)
-				continue
-			}
-			return nil, errors.Errorf("browser.GetOneProxyInfo error: %s", err.Error())
-		}
-
-		if outProxyInfo.FirTimeAccess == true {
-			// 第一次访问，不需要等待
-			outProxyInfo.FirTimeAccess = false
-			return outProxyInfo, nil
-		}
-
-		timeT := time.Unix(outProxyInfo.GetLastAccessTime(), 0)
-		dv := time.Now().Unix() - outProxyInfo.GetLastAccessTime()
-		b.log.Infoln("Now Proxy:", outProxyInfo.Name, outProxyInfo.Index, timeT.Format("2006-01-02 15:04:05"), baseUrl)
-		if dv > 0 && dv <= int64(b.rodOptions.timeConfig.OneProxyNodeUseInternalMinTime) {
-			// 如果没有超过，那么就等待一段时间，然后再去获取
-			// 休眠一下
-			sleepTime := b.rodOptions.timeConfig.GetOneProxyNodeUseInternalTime(int32(dv))
-			b.log.Infoln("Will Sleep", sleepTime.Seconds(), "s")
-			<-time.After(sleepTime)
-		} else if dv < 0 {
-			// 理论上就不该到这个分支
-			b.log.Warningln(outProxyInfo.Name, outProxyInfo.Index, "LastAccessTime is bigger than now time")
-		}
+// GetProxyInfoSyncWithContext 同 GetProxyInfoSync，ctx 结束的时候中断等待，返回 ctx.Err()
+func (b *Pool) GetProxyInfoSyncWithContext(ctx context.Context, baseUrl string) (*XrayPoolProxyInfo, error) {
+	return b.nowSelector().GetProxyInfoSyncWithContext(ctx, baseUrl)
+}
 
-		return outProxyInfo, nil
-	}
+// GetProxyInfoSyncWithPriority 同 GetProxyInfoSyncWithContext，priority 越大越先分配到节点
+func (b *Pool) GetProxyInfoSyncWithPriority(ctx context.Context, baseUrl string, priority WaitPriority) (*XrayPoolProxyInfo, error) {
+	return b.nowSelector().GetProxyInfoSyncWithPriority(ctx, baseUrl, priority)
 }
 
 // PageStatusCodeCheck 页面状态码检查
@@ -394,7 +485,7 @@ func (b *Pool) PageStatusCodeCheck(e *proto.NetworkResponseReceived, statusCodeI
 
 		if codeInfo.NeedPunishment == true {
 			// 需要进行惩罚
-			err := b.SetProxyNodeSkipByTime(nowProxyInfo.Index, b.rodOptions.timeConfig.GetProxyNodeSkipAccessTime())
+			err := b.punishProxyNode(nowProxyInfo)
 			if err != nil {
 				return codeInfo.WillDo, err
 			}
@@ -509,7 +600,7 @@ func (b *Pool) HasSuccessWord(page *rod.Page, nowProxyInfo *XrayPoolProxyInfo) (
 	if contained == false {
 
 		// 如果没有包含成功的关键词，那么给予惩罚时间，这样就会暂时跳过这个代理节点
-		err = b.SetProxyNodeSkipByTime(nowProxyInfo.Index, b.rodOptions.timeConfig.GetProxyNodeSkipAccessTime())
+		err = b.punishProxyNode(nowProxyInfo)
 		if err != nil {
 			return false, err
 		}
@@ -549,7 +640,7 @@ func (b *Pool) HasFailedWord(page *rod.Page, nowProxyInfo *XrayPoolProxyInfo) (b
 	contained, index := ContainedWords(pageContent, b.rodOptions.failWordsConfig.Words)
 	if contained == true {
 		// 如果包含了失败的关键词，那么就需要统计出来，到底最近访问这个节点的频率是如何的，提供给人来判断调整
-		err = b.SetProxyNodeSkipByTime(nowProxyInfo.Index, b.rodOptions.timeConfig.GetProxyNodeSkipAccessTime())
+		err = b.punishProxyNode(nowProxyInfo)
 		if err != nil {
 			return false, "", err
 		}
@@ -581,10 +672,8 @@ func (b *Pool) HasFailedWordBase(page *rod.Page, words []string) (bool, string,
 // NewBrowser 每次新建一个 Browser ，不使用代理
 func (b *Pool) NewBrowser() (*BrowserInfo, error) {
 
-	cachePath := b.rodOptions.CacheRootDirPath()
-	oneBrowserInfo, err := NewBrowserBase(cachePath,
-		b.rodOptions.BrowserFPath(), "",
-		b.rodOptions.LoadAdblock(), b.rodOptions.LoadPicture())
+	b.checkCacheQuota()
+	oneBrowserInfo, err := b.newBrowserBase("")
 	if err != nil {
 		return nil, errors.New("NewBrowser.NewBrowserBase error:" + err.Error())
 	}
@@ -592,19 +681,57 @@ func (b *Pool) NewBrowser() (*BrowserInfo, error) {
 	return oneBrowserInfo, nil
 }
 
+// newBrowserBase 新建一个浏览器，加载 PoolOptions 中设置的插件，LoadAdblock 的时候同时加载 adblock
+func (b *Pool) newBrowserBase(httpProxyUrl string) (*BrowserInfo, error) {
+
+	extensionDirs := b.rodOptions.ExtensionDirs()
+	if len(extensionDirs) == 0 {
+		return NewBrowserBase(b.cacheLayout.RootDirPath(),
+			b.rodOptions.BrowserFPath(), httpProxyUrl,
+			b.rodOptions.LoadAdblock(), b.rodOptions.LoadPicture())
+	}
+
+	dirPaths := make([]string, 0, len(extensionDirs)+1)
+	if b.rodOptions.LoadAdblock() == true {
+		err := rod.Try(func() {
+			dirPaths = append(dirPaths, GetADBlockLocalPath(b.cacheLayout.RootDirPath(), httpProxyUrl))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	dirPaths = append(dirPaths, extensionDirs...)
+	return NewBrowserBaseWithExtensions(b.cacheLayout.RootDirPath(),
+		b.rodOptions.BrowserFPath(), httpProxyUrl, dirPaths, b.rodOptions.LoadPicture())
+}
+
+// BrowserPool 浏览器实例池，最多 PoolOptions.BrowserInstanceCount 个实例，不使用代理
+func (b *Pool) BrowserPool() *BrowserPool {
+
+	b.browserPoolLocker.Lock()
+	defer b.browserPoolLocker.Unlock()
+	if b.browserPool == nil {
+		b.browserPool = NewBrowserPool(b.rodOptions.BrowserInstanceCount(), b.rodOptions.BrowserPoolConfig(), b.NewBrowser, b.log)
+	}
+	return b.browserPool
+}
+
+// AcquireBrowser 从浏览器实例池中租用一个实例，避免每次都启动新的浏览器，使用完毕后需要调用 BrowserLease.Release
+func (b *Pool) AcquireBrowser(ctx context.Context) (*BrowserLease, error) {
+	return b.BrowserPool().Acquire(ctx)
+}
+
 // NewBrowserWithRandomProxy 每次新建一个 Browser ，使用 HttpProxy 列表中的一个作为代理
 func (b *Pool) NewBrowserWithRandomProxy() (*BrowserInfo, error) {
 
-	b.httpProxyLocker.Lock()
-	defer func() {
-		b.addNowProxyIndex()
-		b.httpProxyLocker.Unlock()
-	}()
+	// 需要跳过的节点也可以使用，这里只是轮换代理
+	nowProxyInfo, err := b.GetOneProxyInfo()
+	if err != nil && errors.Is(err, ErrSkipAccessTime) == false {
+		return nil, errors.New("NewBrowserWithRandomProxy.GetOneProxyInfo error:" + err.Error())
+	}
 
-	cachePath := b.rodOptions.CacheRootDirPath()
-	oneBrowserInfo, err := NewBrowserBase(cachePath,
-		b.rodOptions.BrowserFPath(), b.orgProxyInfos[b.getNowProxyIndex()].HttpUrl,
-		b.rodOptions.LoadAdblock(), b.rodOptions.LoadPicture())
+	b.checkCacheQuota()
+	oneBrowserInfo, err := b.newBrowserBase(nowProxyInfo.ProxyUrl())
 	if err != nil {
 		return nil, errors.New("NewBrowserWithRandomProxy.NewBrowserBase error:" + err.Error())
 	}
@@ -615,10 +742,24 @@ func (b *Pool) NewBrowserWithRandomProxy() (*BrowserInfo, error) {
 // TryLoadPage 只关心以现在有的信息去尝试加载一个页面，不考虑其中可能遇到验证码的情况
 func (b *Pool) TryLoadPage(browserInfo *BrowserInfo, nowProxyInfo *XrayPoolProxyInfo,
 	pageInfo PageInfo, statusCodeInfos []StatusCodeInfo, needRedirect bool) (int, *rod.Page, error) {
+	return b.TryLoadPageWithContext(context.Background(), browserInfo, nowProxyInfo, pageInfo, statusCodeInfos, needRedirect)
+}
+
+// TryLoadPageWithContext 同 TryLoadPage，ctx 结束的时候中断导航以及等待，返回 ctx.Err()
+// 失败的原因可以通过 ClassifyPageLoadError 获取
+func (b *Pool) TryLoadPageWithContext(ctx context.Context, browserInfo *BrowserInfo, nowProxyInfo *XrayPoolProxyInfo,
+	pageInfo PageInfo, statusCodeInfos []StatusCodeInfo, needRedirect bool) (int, *rod.Page, error) {
+	speedResult, _, page, err := b.tryLoadPage(ctx, browserInfo, nowProxyInfo, pageInfo, statusCodeInfos, needRedirect)
+	return speedResult, page, err
+}
+
+// tryLoadPage 同 TryLoadPageWithContext，额外返回页面的状态码
+func (b *Pool) tryLoadPage(ctx context.Context, browserInfo *BrowserInfo, nowProxyInfo *XrayPoolProxyInfo,
+	pageInfo PageInfo, statusCodeInfos []StatusCodeInfo, needRedirect bool) (int, int, *rod.Page, error) {
 
 	var err error
+	var statusCode int
 	var page *rod.Page
-	var client *resty.Client
 	var e *proto.NetworkResponseReceived
 
 	timeOut := pageInfo.GetPageTimeOut()
@@ -628,16 +769,11 @@ func (b *Pool) TryLoadPage(browserInfo *BrowserInfo, nowProxyInfo *XrayPoolProxy
 	// --------------------------------- 1. 加载页面 ---------------------------------
 	// 新建一个 page 使用
 	logger.Infoln("NowProxy:", nowProxyInfo.Name)
-	opt := NewHttpClientOptions(timeOut)
-	opt.SetHttpProxy(nowProxyInfo.HttpUrl)
-	client, err = NewHttpClient(opt)
-	if err != nil {
-		return -1, nil, err
-	}
+	hijackConfig := b.getHijackConfig(timeOut)
 	start := time.Now()
 	page, err = NewPage(browserInfo.Browser)
 	if err != nil {
-		return -1, nil, err
+		return -1, statusCode, nil, err
 	}
 	defer func() {
 		if err != nil && page != nil {
@@ -652,64 +788,85 @@ func (b *Pool) TryLoadPage(browserInfo *BrowserInfo, nowProxyInfo *XrayPoolProxy
 		Height:      gson.Int(900),
 		WindowState: proto.BrowserWindowStateNormal,
 	})
-	router := NewPageHijackRouter(page, true, client.GetClient())
+	var router *PageHijacker
+	router, err = NewPageHijacker(page, nowProxyInfo.ProxyUrl(), hijackConfig)
+	if err != nil {
+		return -1, statusCode, nil, err
+	}
 	defer func() {
 		_ = router.Stop()
 	}()
 	go router.Run()
 	// 设置代理
-	page, e, err = PageNavigate(
-		page, true, pageInfo.Url,
+	page, e, err = PageNavigateWithContext(
+		ctx, page, true, pageInfo.Url,
 		timeOut,
 	)
+	if ctx.Err() != nil {
+		err = ctx.Err()
+		return -1, statusCode, nil, err
+	}
 	if err != nil {
 		// 这里可能会出现超时，但是实际上是成功的，所以这里不需要返回错误
 		if errors.Is(err, context.DeadlineExceeded) == false {
 			// 不是超时错误，那么就返回错误，跳过
-			return -1, nil, err
+			err = newPageLoadError(FailureNetwork, statusCode, err)
+			return -1, statusCode, nil, err
 		}
 	}
-	err = page.Timeout(timeOut).WaitLoad()
+	err = page.Context(ctx).Timeout(timeOut).WaitLoad()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+		return -1, statusCode, nil, err
+	}
 	if err != nil {
 		// 这里可能会出现超时，但是实际上是成功的，所以这里不需要返回错误
 		if errors.Is(err, context.DeadlineExceeded) == false {
 			// 不是超时错误，那么就返回错误，跳过
-			return -1, nil, err
+			err = newPageLoadError(FailureNetwork, statusCode, err)
+			return -1, statusCode, nil, err
 		}
 	}
 	// ------------------判断返回值是否符合期望------------------
 	logger.Infoln(pageInfo.Name, "PageStatusCodeCheck: ", pageInfo.Url)
+	if e != nil && e.Response != nil {
+		statusCode = e.Response.Status
+	}
 	var StatusCodeCheck PageCheck
 	StatusCodeCheck, err = b.PageStatusCodeCheckBase(e, statusCodeInfos, pageInfo.Url)
 	if err != nil {
-		return -1, nil, err
+		return -1, statusCode, nil, err
 	}
 	switch StatusCodeCheck {
 	case Skip:
 		// 跳过后续的逻辑，不需要再次访问
 		logger.Warningln("PageStatusCodeCheck Skip NeedSkipProxyIndexList", nowProxyInfo.Index, nowProxyInfo.Name)
-		err = errors.New(pageInfo.Name + " PageStatusCodeCheck Skip")
-		return -1, nil, err
+		err = newPageLoadError(FailureStatusCode, statusCode, errors.New(pageInfo.Name+" PageStatusCodeCheck Skip"))
+		return -1, statusCode, nil, err
 	case Repeat:
 		logger.Warningln("PageStatusCodeCheck Repeat NeedSkipProxyIndexList", nowProxyInfo.Index, nowProxyInfo.Name)
 		// 重新访问，需要再次请求这个页面
-		err = errors.New(pageInfo.Name + " PageStatusCodeCheck Repeat")
-		return -1, nil, err
+		err = newPageLoadError(FailureStatusCode, statusCode, errors.New(pageInfo.Name+" PageStatusCodeCheck Repeat"))
+		return -1, statusCode, nil, err
 	}
 	// 激活界面
 	_, err = page.Activate()
 	if err != nil {
 		err = errors.New(pageInfo.Name + " Activate Error: " + err.Error())
-		return -1, nil, err
+		return -1, statusCode, nil, err
 	}
 	// ------------------会循环检测是否加载完毕，关键 Ele 出现即可------------------
 	logger.Infoln(pageInfo.Name, "HasPageLoaded: ", pageInfo.Url)
-	pageLoaded := HasPageLoaded(page, pageInfo.ExistElementXPaths, pageInfo.PageTimeOut)
+	var pageLoaded bool
+	pageLoaded, err = HasPageLoadedWithContext(ctx, page, pageInfo.ExistElementXPaths, pageInfo.PageTimeOut)
+	if err != nil {
+		return -1, statusCode, nil, err
+	}
 	logger.Infoln(pageInfo.Name, "HasPageLoaded: ", pageInfo.Url, pageLoaded)
 	// 要在 StatusCode 检查之后再判断
 	if pageLoaded == false {
-		err = ErrPageLoadFailed
-		return -1, nil, err
+		err = newPageLoadError(FailureXPath, statusCode, ErrPageLoadFailed)
+		return -1, statusCode, nil, err
 	}
 	// ------------------是否包含成功关键词------------------
 	if pageInfo.HasSuccessWord() == true {
@@ -719,40 +876,59 @@ func (b *Pool) TryLoadPage(browserInfo *BrowserInfo, nowProxyInfo *XrayPoolProxy
 		logger.Infoln("HasSuccessWords: ", pageInfo.Url, bok)
 		if err != nil {
 			err = errors.New(fmt.Sprintf("hasSuccessWord error: %s", err.Error()))
-			return -1, nil, err
+			return -1, statusCode, nil, err
 		}
 		if bok == false {
 			// 需要再次请求这个页面
-			err = errors.New(pageInfo.Name + " Not Contained SuccessWord")
-			return -1, nil, err
+			err = newPageLoadError(FailureSuccessWord, statusCode, errors.New(pageInfo.Name+" Not Contained SuccessWord"))
+			return -1, statusCode, nil, err
 		}
 	}
 	elapsed := time.Since(start)
 	speedResult := int(float32(elapsed.Nanoseconds()) / 1e6)
 
-	return speedResult, page, nil
+	return speedResult, statusCode, page, nil
 }
 
 // TryLoadUrl 实现一个 http client 访问 url 的功能
 func (b *Pool) TryLoadUrl(nowProxyInfo *XrayPoolProxyInfo, pageInfo PageInfo) (int, error) {
+	return b.TryLoadUrlWithContext(context.Background(), nowProxyInfo, pageInfo)
+}
+
+// TryLoadUrlWithContext 同 TryLoadUrl，ctx 结束的时候中断请求，返回 ctx.Err()
+// 失败的原因可以通过 ClassifyPageLoadError 获取
+func (b *Pool) TryLoadUrlWithContext(ctx context.Context, nowProxyInfo *XrayPoolProxyInfo, pageInfo PageInfo) (int, error) {
+	speedResult, _, err := b.tryLoadUrl(ctx, nowProxyInfo, pageInfo)
+	return speedResult, err
+}
+
+// tryLoadUrl 同 TryLoadUrlWithContext，额外返回页面的状态码
+func (b *Pool) tryLoadUrl(ctx context.Context, nowProxyInfo *XrayPoolProxyInfo, pageInfo PageInfo) (int, int, error) {
 
 	logger.Infoln("NowProxy:", nowProxyInfo.Name)
 	opt := NewHttpClientOptions(pageInfo.GetPageTimeOut())
-	opt.SetHttpProxy(nowProxyInfo.HttpUrl)
+	opt.SetProxyInfo(nowProxyInfo)
 	client, err := NewHttpClient(opt)
 	if err != nil {
-		return -1, err
+		return -1, 0, err
 	}
 
 	start := time.Now()
-	req := client.R()
+	req := client.R().SetContext(ctx)
 	if len(pageInfo.Header) > 0 {
 		req.SetHeaders(pageInfo.Header)
 	}
 	res, err := req.Get(pageInfo.Url)
 	elapsed := time.Since(start)
+	if ctx.Err() != nil {
+		return -1, 0, ctx.Err()
+	}
 	if err != nil {
-		return -1, err
+		category, _ := ClassifyPageLoadError(err)
+		if category == FailureOther {
+			category = FailureNetwork
+		}
+		return -1, 0, newPageLoadError(category, 0, err)
 	}
 
 	speedResult := int(float32(elapsed.Nanoseconds()) / 1e6)
@@ -765,147 +941,171 @@ func (b *Pool) TryLoadUrl(nowProxyInfo *XrayPoolProxyInfo, pageInfo PageInfo) (i
 		if contained == false {
 			// 需要再次请求这个页面
 			err = errors.New(pageInfo.Name + " Not Contained SuccessWord")
-			return -1, err
+			return -1, res.StatusCode(), newPageLoadError(FailureSuccessWord, res.StatusCode(), err)
 		}
 	} else {
 		// 如果不需要判断成功关键词，那么就需要判断状态码
 		if res.StatusCode() != http.StatusOK {
-			return -1, errors.New("StatusCode is not 200, StatusCode: " + strconv.Itoa(res.StatusCode()) + ", Url: " + pageInfo.Url)
+			err = errors.New("StatusCode is not 200, StatusCode: " + strconv.Itoa(res.StatusCode()) + ", Url: " + pageInfo.Url)
+			return -1, res.StatusCode(), newPageLoadError(FailureStatusCode, res.StatusCode(), err)
 		}
 	}
 
-	return speedResult, nil
+	return speedResult, res.StatusCode(), nil
 }
 
 func (b *Pool) Close() {
 
+	b.StopProxyRefresh()
+	b.StopFilterScheduler()
+	b.browserPoolLocker.Lock()
+	if b.browserPool != nil {
+		b.browserPool.Close()
+	}
+	b.browserPoolLocker.Unlock()
+	b.httpProxyLocker.Lock()
+	// 唤醒所有排队等待节点以及租约的调用者，返回 ErrPoolClosed
+	b.closed = true
+	b.notifyProxyReady()
+	for keyName := range b.scheduler.timers {
+		b.stopSchedulerTimer(keyName)
+	}
+	for _, info := range b.orgProxyInfos {
+		CloseHijackTransport(info.ProxyUrl())
+	}
+	b.httpProxyLocker.Unlock()
+	b.stopDelaySaveProxyNodeState()
+	b.saveProxyNodeState()
+	if closer, ok := b.proxySource.(io.Closer); ok == true {
+		_ = closer.Close()
+	}
+
+	// 缓存目录中还有代理缓存、插件等，只清理不再使用的浏览器用户数据目录
 	time.AfterFunc(time.Second*5, func() {
-		_ = os.RemoveAll(b.rodOptions.CacheRootDirPath())
+		_, _ = b.CleanupCache(defUserDataStaleTime)
 	})
 }
 
-func (b *Pool) getNowProxyIndex() int {
-
-	if b.nowOrgProxyIndex >= len(b.orgProxyInfos)-1 {
-		b.nowOrgProxyIndex = 0
-	}
-	return b.nowOrgProxyIndex
+// CacheLayout 缓存目录的布局
+func (b *Pool) CacheLayout() *CacheLayout {
+	return b.cacheLayout
 }
 
-func (b *Pool) addNowProxyIndex() {
+// CleanupCache 清理超过 staleAfter 没有变化的浏览器用户数据目录，并检查缓存上限，见 CacheLayout.Cleanup
+func (b *Pool) CleanupCache(staleAfter time.Duration) (*CacheCleanupResult, error) {
 
-	defer func() {
-		if b.nowKeyName == "" {
-			// 全部的 index
-			if b.nowOrgProxyIndex >= len(b.orgProxyInfos)-1 {
-				b.nowOrgProxyIndex = 0
-			}
-		} else {
-			// 具体一个 KeyName 的 index
-			// 需要将对应的 KeyName 的 index 清单中的索引对应到全列表的索引
-			if b.nowOrgProxyIndex >= len(b.orgProxyInfos) {
-				b.nowOrgProxyIndex = b.filterProxyInfoIndexList[b.nowKeyName][0]
-			}
-		}
-	}()
-
-	if b.nowKeyName == "" {
-		// 全部的 index
-		b.nowOrgProxyIndex++
-	} else {
-		// 具体一个 KeyName 的 index
-		// 需要将对应的 KeyName 的 index 清单中的索引对应到全列表的索引
-		b.nowFilterProxyInfoIndex[b.nowKeyName]++
-		// 避免越界
-		if b.nowFilterProxyInfoIndex[b.nowKeyName] > len(b.filterProxyInfoIndexList[b.nowKeyName])-1 {
-			b.nowFilterProxyInfoIndex[b.nowKeyName] = 0
-		}
-		//logger.Infoln("addNowProxyIndex", b.nowKeyName)
-		//logger.Infoln("addNowProxyIndex", len(b.filterProxyInfoIndexList[b.nowKeyName]))
-		//logger.Infoln("addNowProxyIndex", b.nowFilterProxyInfoIndex[b.nowKeyName])
-
-		b.nowOrgProxyIndex = b.filterProxyInfoIndexList[b.nowKeyName][b.nowFilterProxyInfoIndex[b.nowKeyName]]
+	result, err := b.cacheLayout.Cleanup(staleAfter)
+	if result != nil && len(result.RemovedDirs) > 0 {
+		b.log.Infoln("Pool.CleanupCache Removed:", len(result.RemovedDirs), "Usage:", result.UsageBytes)
 	}
+	return result, err
 }
 
-// saveFilterProxyIndex 本次当前的缓存索引信息到本地文件
-func (b *Pool) saveFilterProxyIndex() {
+// checkCacheQuota 设置了缓存上限的时候，新建浏览器之前检查一下，超出上限只记录日志
+func (b *Pool) checkCacheQuota() {
 
-	proxyCacheFolder := GetProxyCacheFolder("")
-	if IsDir(proxyCacheFolder) == false {
-		err := os.MkdirAll(proxyCacheFolder, os.ModePerm)
-		if err != nil {
-			logger.Panicln("save proxy filter cache info failed: ", err)
-		}
+	if b.cacheLayout.Quota() <= 0 {
+		return
 	}
+	b.cacheCheckLocker.Lock()
+	if time.Since(b.lastCacheCheckTime) < cacheQuotaCheckTime {
+		b.cacheCheckLocker.Unlock()
+		return
+	}
+	b.lastCacheCheckTime = time.Now()
+	b.cacheCheckLocker.Unlock()
 
-	for keyName, _ := range b.filterProxyInfoIndexList {
-
-		needSave := NewProxyCache()
-		needSave.FilterProxyInfoIndexList = b.filterProxyInfoIndexList[keyName]
-		needSave.NowFilterProxyInfoIndex = b.nowFilterProxyInfoIndex[keyName]
-		needSave.UpdateTime = b.filterProxyInfoUpdateTime[keyName]
-		saveFPath := filepath.Join(proxyCacheFolder, fmt.Sprintf(proxyCacheFileName, keyName))
-		err := ToFile(saveFPath, needSave)
-		if err != nil {
-			logger.Panicln("save proxy filter cache info failed: ", err)
-		}
+	_, err := b.CleanupCache(defUserDataStaleTime)
+	if err != nil {
+		b.log.Warningln("Pool.CleanupCache", err)
 	}
 }
 
-// loadFilterProxyIndex 加载本地可能存在的缓存索引清单文件
-func (b *Pool) loadFilterProxyIndex() error {
+// getFilterKeyLocker 获取这个 KeyName 的 Filter 锁
+func (b *Pool) getFilterKeyLocker(keyName string) *sync.Mutex {
 
-	cacheFiles, err := findCacheJsonFile(GetProxyCacheFolder(""))
-	if err != nil {
-		return err
+	b.filterProxyLocker.Lock()
+	defer b.filterProxyLocker.Unlock()
+	keyLocker, found := b.filterKeyLockers[keyName]
+	if found == false {
+		keyLocker = &sync.Mutex{}
+		b.filterKeyLockers[keyName] = keyLocker
 	}
+	return keyLocker
+}
 
-	for _, file := range cacheFiles {
+// saveFilterProxyIndex 保存这个 KeyName 当前的过滤结果
+func (b *Pool) saveFilterProxyIndex(keyName string) error {
 
-		// 解析这个文件名对应的是哪个 KeyName
-		fileName := filepath.Base(file)
-		fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName))
-		fileName = strings.TrimPrefix(fileName, "proxy_cache_")
-		pc := NewProxyCache()
-		err = ToStruct(file, pc)
-		if err != nil {
-			return err
+	needSave := NewProxyCache()
+	b.filterProxyLocker.Lock()
+	needSave.FilterProxyIDList = append(needSave.FilterProxyIDList, b.filterProxyIDList[keyName]...)
+	needSave.UpdateTime = b.filterProxyInfoUpdateTime[keyName]
+	for id, testTime := range b.filterProxyTestTime[keyName] {
+		needSave.ProxyTestTimes[id] = testTime
+	}
+	b.filterProxyLocker.Unlock()
+	b.httpProxyLocker.Lock()
+	for _, id := range needSave.FilterProxyIDList {
+		if index, found := b.proxyIDIndex[id]; found == true && b.orgProxyInfos[index].GetExitIP() != "" {
+			needSave.ProxyExitIPs[id] = b.orgProxyInfos[index].GetExitIP()
 		}
-		// 缓存
-		b.filterProxyInfoIndexList[fileName] = pc.FilterProxyInfoIndexList
-		b.nowFilterProxyInfoIndex[fileName] = pc.NowFilterProxyInfoIndex
-		b.filterProxyInfoUpdateTime[fileName] = pc.UpdateTime
 	}
+	b.httpProxyLocker.Unlock()
+	needSave.NowFilterProxyInfoIndex = b.ForKey(keyName).getCursor()
 
+	err := b.stateStore.Save(fmt.Sprintf(proxyCacheFileName, keyName), needSave)
+	if err != nil {
+		return errors.New("save proxy filter cache info failed: " + err.Error())
+	}
 	return nil
 }
 
-// findCacheJsonFile 搜索当前目录下所有匹配的缓存文件
-func findCacheJsonFile(dirRootPath string) ([]string, error) {
+// loadFilterProxyIndex 加载这个 KeyName 之前保存的过滤结果，不存在则忽略
+func (b *Pool) loadFilterProxyIndex(keyName string) error {
 
-	// 创建一个正则表达式来匹配文件名
-	re := regexp.MustCompile(`^proxy_cache_.*\.json$`)
-	// 遍历文件夹
-	fileList, err := ioutil.ReadDir(dirRootPath)
+	pc := NewProxyCache()
+	err := b.stateStore.Load(fmt.Sprintf(proxyCacheFileName, keyName), pc)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, ErrStateNotFound) == true {
+			return nil
+		}
+		return err
 	}
-	outFiles := make([]string, 0)
-	// 遍历文件列表
-	for _, file := range fileList {
-		if file.IsDir() {
-			// 如果是文件夹，跳过
-			continue
+
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	b.filterProxyLocker.Lock()
+	defer b.filterProxyLocker.Unlock()
+	// 与当前的代理列表对齐，丢弃已经不存在的节点
+	ids, needRefilter := reconcileProxyCache(pc, b.proxyIDIndex, b.orgProxyInfos)
+	if needRefilter == true {
+		// 旧版本的位置索引不可信，让下一次 Filter 重新过滤
+		logger.Infoln("loadFilterProxyIndex", keyName, "migrate index based cache, need refilter")
+		pc.UpdateTime = 0
+	}
+	if pc.NowFilterProxyInfoIndex >= len(ids) {
+		pc.NowFilterProxyInfoIndex = 0
+	}
+	// 缓存
+	b.filterProxyIDList[keyName] = ids
+	b.nowFilterProxyInfoIndex[keyName] = pc.NowFilterProxyInfoIndex
+	b.filterProxyInfoUpdateTime[keyName] = pc.UpdateTime
+	testTimes := make(map[string]int64)
+	for _, id := range ids {
+		if testTime, found := pc.ProxyTestTimes[id]; found == true && needRefilter == false {
+			testTimes[id] = testTime
 		}
-		if re.MatchString(file.Name()) {
-			// 如果文件名匹配正则表达式，则处理该文件
-			filePath := filepath.Join(dirRootPath, file.Name())
-			outFiles = append(outFiles, filePath)
+	}
+	b.filterProxyTestTime[keyName] = testTimes
+	for _, id := range ids {
+		// 出口 IP 以最近一次解析的为准
+		if exitIP, found := pc.ProxyExitIPs[id]; found == true && b.orgProxyInfos[b.proxyIDIndex[id]].GetExitIP() == "" {
+			b.orgProxyInfos[b.proxyIDIndex[id]].setExitIP(exitIP)
 		}
 	}
 
-	return outFiles, nil
+	return nil
 }
 
 var ErrKeyNameIsNotExist = errors.New("key name is not exist")
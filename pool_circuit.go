@@ -0,0 +1,72 @@
+package rod_helper
+
+import (
+	"time"
+)
+
+// GetCircuitBreaker 获取 (节点, KeyName) 的熔断器
+func (b *Pool) GetCircuitBreaker() *CircuitBreaker {
+	return b.circuitBreaker
+}
+
+// proxyAllowedByCircuit 这个节点在 keyName 中是否可以使用，冷却结束的节点会在后台使用这个 KeyName 注册的测试页面探测
+func (b *Pool) proxyAllowedByCircuit(proxyInfo *XrayPoolProxyInfo, keyName string) (bool, time.Time) {
+
+	if keyName == "" {
+		return true, time.Time{}
+	}
+	allowed, retryTime, needProbe := b.circuitBreaker.Allow(proxyInfo.ID, keyName)
+	if needProbe == true {
+		go b.probeCircuit(proxyInfo, keyName)
+	}
+	return allowed, retryTime
+}
+
+// probeCircuit 半开状态的探测，通过了才恢复使用，否则再次熔断
+func (b *Pool) probeCircuit(proxyInfo *XrayPoolProxyInfo, keyName string) {
+
+	b.filterProxyLocker.Lock()
+	fInfo := b.filterInfos[keyName]
+	b.filterProxyLocker.Unlock()
+
+	passed := true
+	if fInfo != nil {
+		for _, pageInfo := range fInfo.PageInfos {
+			_, err := b.TryLoadUrl(proxyInfo, pageInfo)
+			if err != nil {
+				b.log.Infoln("Pool.probeCircuit", proxyInfo.Name, keyName, pageInfo.Name, err)
+				passed = false
+				break
+			}
+		}
+	}
+
+	if passed == true {
+		b.recordProxySuccess(proxyInfo, keyName, 0)
+	} else {
+		b.recordProxyFailure(proxyInfo, keyName)
+	}
+	b.log.Infoln("Pool.probeCircuit", proxyInfo.Name, keyName, b.circuitBreaker.State(proxyInfo.ID, keyName))
+	b.delaySaveProxyNodeState()
+
+	b.httpProxyLocker.Lock()
+	b.notifyProxyReady()
+	b.httpProxyLocker.Unlock()
+}
+
+// punishProxyNode 惩罚这个节点。设置了 KeyName 的时候只在这个 KeyName 中熔断，否则全局跳过这个节点
+func (b *Pool) punishProxyNode(proxyInfo *XrayPoolProxyInfo) error {
+
+	b.httpProxyLocker.Lock()
+	keyName := b.nowKeyName
+	b.httpProxyLocker.Unlock()
+
+	if keyName == "" {
+		return b.SetProxyNodeSkipByTime(proxyInfo.ID, b.rodOptions.timeConfig.GetProxyNodeSkipAccessTime())
+	}
+	b.log.Infoln("punishProxyNode", proxyInfo.Name, proxyInfo.ID, keyName)
+	b.circuitBreaker.Trip(proxyInfo.ID, keyName, time.Duration(b.rodOptions.timeConfig.ProxyNodeSkipAccessTime)*time.Second)
+	// 熔断需要跨重启生效
+	b.saveProxyNodeState()
+	return nil
+}
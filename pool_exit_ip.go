@@ -0,0 +1,168 @@
+package rod_helper
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/WQGroup/logger"
+	"github.com/panjf2000/ants/v2"
+)
+
+// ResolveExitIP 通过这个节点访问 GetPublicIP 的网站，解析并记录节点的出口 IP，customDectIPSites 为空的时候使用默认的网站
+func (b *Pool) ResolveExitIP(ctx context.Context, proxyInfo *XrayPoolProxyInfo, customDectIPSites []string) (string, error) {
+
+	opt := NewHttpClientOptions(exitIPTimeOut)
+	opt.SetProxyInfo(proxyInfo)
+	client, err := NewHttpClient(opt)
+	if err != nil {
+		return "", err
+	}
+	exitIP, err := GetPublicIPWithHttpClient(ctx, client, customDectIPSites)
+	if err != nil {
+		return "", err
+	}
+
+	b.httpProxyLocker.Lock()
+	b.currentProxyInfo(proxyInfo).setExitIP(exitIP)
+	b.httpProxyLocker.Unlock()
+	return exitIP, nil
+}
+
+// ExitIPGroup 出口 IP 所在的分组，prefixBits 为 0 或者超过 IP 长度的时候就是 IP 本身
+func ExitIPGroup(exitIP string, prefixBits int) string {
+
+	ip := net.ParseIP(exitIP)
+	if ip == nil {
+		return exitIP
+	}
+	ipBits := net.IPv6len * 8
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		ipBits = net.IPv4len * 8
+	}
+	if prefixBits <= 0 || prefixBits >= ipBits {
+		return ip.String()
+	}
+	ipNet := net.IPNet{IP: ip.Mask(net.CIDRMask(prefixBits, ipBits)), Mask: net.CIDRMask(prefixBits, ipBits)}
+	return ipNet.String()
+}
+
+// resolveExitIPs Filter 的时候解析 proxyInfos 的出口 IP，解析失败的节点不会被淘汰，只是没有出口 IP
+func (b *Pool) resolveExitIPs(ctx context.Context, fInfo *FilterInfo, proxyInfos []*XrayPoolProxyInfo,
+	proxyResults map[string]*ProxyFilterResult, threadSize int) error {
+
+	var locker sync.Mutex
+	var wg sync.WaitGroup
+	p, err := ants.NewPool(threadSize)
+	if err != nil {
+		return err
+	}
+	defer p.Release()
+	for _, proxyInfo := range proxyInfos {
+		if ctx.Err() != nil {
+			break
+		}
+		nowProxyInfo := proxyInfo
+		wg.Add(1)
+		err = p.Submit(func() {
+			defer wg.Done()
+			exitIP, err := b.ResolveExitIP(ctx, nowProxyInfo, fInfo.ExitIPSites)
+			if err != nil {
+				logger.Warningln("Pool.Filter ResolveExitIP", nowProxyInfo.Name, nowProxyInfo.Index, err)
+				return
+			}
+			locker.Lock()
+			proxyResults[nowProxyInfo.ID].ExitIP = exitIP
+			locker.Unlock()
+		})
+		if err != nil {
+			wg.Done()
+			wg.Wait()
+			return err
+		}
+	}
+	wg.Wait()
+	return nil
+}
+
+// dedupExitIP 同一组出口的节点只保留最快的一个，keptExitGroups 是已经被占用的分组，返回保留的节点
+func dedupExitIP(fInfo *FilterInfo, survivors []*XrayPoolProxyInfo, proxyResults map[string]*ProxyFilterResult,
+	keptExitGroups map[string]bool) []*XrayPoolProxyInfo {
+
+	sorted := make([]*XrayPoolProxyInfo, len(survivors))
+	copy(sorted, survivors)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return proxyResults[sorted[i].ID].AvgLatencyMs < proxyResults[sorted[j].ID].AvgLatencyMs
+	})
+
+	usedExitGroups := make(map[string]bool)
+	for exitGroup := range keptExitGroups {
+		usedExitGroups[exitGroup] = true
+	}
+	dedupSurvivors := make([]*XrayPoolProxyInfo, 0, len(survivors))
+	for _, proxyInfo := range sorted {
+		proxyResult := proxyResults[proxyInfo.ID]
+		if proxyResult.ExitIP == "" {
+			// 没有解析到出口的节点无法判断，保留
+			dedupSurvivors = append(dedupSurvivors, proxyInfo)
+			continue
+		}
+		exitGroup := ExitIPGroup(proxyResult.ExitIP, fInfo.ExitIPPrefix)
+		if usedExitGroups[exitGroup] == true {
+			proxyResult.Passed = false
+			proxyResult.FailureCategory = FailureDuplicateIP
+			continue
+		}
+		usedExitGroups[exitGroup] = true
+		dedupSurvivors = append(dedupSurvivors, proxyInfo)
+	}
+	return dedupSurvivors
+}
+
+// avoidLastExit 从可用的节点中去掉与这个 KeyName 上一次给出的节点同一组出口的节点，全部都是同一组的时候不去掉
+// 需要在 httpProxyLocker 中调用
+func (b *Pool) avoidLastExit(keyName string, eligible []*XrayPoolProxyInfo) []*XrayPoolProxyInfo {
+
+	lastExitGroup, found := b.lastExitGroups[keyName]
+	if found == false || len(eligible) < 2 {
+		return eligible
+	}
+	prefixBits := b.exitIPPrefix(keyName)
+	otherExits := make([]*XrayPoolProxyInfo, 0, len(eligible))
+	for _, proxyInfo := range eligible {
+		if proxyInfo.GetExitIP() == "" || ExitIPGroup(proxyInfo.GetExitIP(), prefixBits) != lastExitGroup {
+			otherExits = append(otherExits, proxyInfo)
+		}
+	}
+	if len(otherExits) < 1 {
+		return eligible
+	}
+	return otherExits
+}
+
+// recordLastExit 记录这个 KeyName 最后给出的节点的出口分组，需要在 httpProxyLocker 中调用
+func (b *Pool) recordLastExit(keyName string, proxyInfo *XrayPoolProxyInfo) {
+
+	if proxyInfo.GetExitIP() == "" {
+		delete(b.lastExitGroups, keyName)
+		return
+	}
+	b.lastExitGroups[keyName] = ExitIPGroup(proxyInfo.GetExitIP(), b.exitIPPrefix(keyName))
+}
+
+// exitIPPrefix 这个 KeyName 出口分组使用的前缀长度
+func (b *Pool) exitIPPrefix(keyName string) int {
+
+	b.filterProxyLocker.Lock()
+	defer b.filterProxyLocker.Unlock()
+	fInfo, found := b.filterInfos[keyName]
+	if found == false {
+		return 0
+	}
+	return fInfo.ExitIPPrefix
+}
+
+const exitIPTimeOut = 15 * time.Second
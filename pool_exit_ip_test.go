@@ -0,0 +1,85 @@
+package rod_helper
+
+import (
+	"context"
+	"github.com/WQGroup/logger"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExitIPGroup(t *testing.T) {
+
+	if ExitIPGroup("1.2.3.4", 0) != "1.2.3.4" || ExitIPGroup("1.2.3.4", 24) != "1.2.3.0/24" {
+		t.Fatal("ipv4 exit group error")
+	}
+	if ExitIPGroup("2001:db8::1", 64) != "2001:db8::/64" {
+		t.Fatal("ipv6 exit group error", ExitIPGroup("2001:db8::1", 64))
+	}
+}
+
+func TestPoolExitIP(t *testing.T) {
+
+	InitFakeUA(true, "", "")
+	newIPProxy := func(exitIP string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("your ip: " + exitIP))
+		}))
+	}
+	proxyA, proxyB, proxyC := newIPProxy("1.2.3.4"), newIPProxy("1.2.3.5"), newIPProxy("5.6.7.8")
+	defer proxyA.Close()
+	defer proxyB.Close()
+	defer proxyC.Close()
+
+	source, err := NewStaticProxySource("static", proxyA.URL, proxyB.URL, proxyC.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	p := newEmptyPool(NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{}), source)
+	p.reconcileProxyInfos(proxyInfos)
+
+	fInfo := &FilterInfo{KeyName: "exit", VerifyExitIP: true, ExitIPSites: []string{"http://ip.test/"}, ExitIPPrefix: 24, DedupExitIP: true}
+	proxyResults := make(map[string]*ProxyFilterResult)
+	for i, proxyInfo := range proxyInfos {
+		proxyResults[proxyInfo.ID] = &ProxyFilterResult{ProxyID: proxyInfo.ID, Passed: true, AvgLatencyMs: int64(300 - i*100)}
+	}
+	// 解析的同时读取出口 IP，-race 的时候不能有数据竞争
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for i := 0; i < 100; i++ {
+			for _, proxyInfo := range proxyInfos {
+				_ = proxyInfo.GetExitIP()
+			}
+		}
+	}()
+	err = p.resolveExitIPs(context.Background(), fInfo, proxyInfos, proxyResults, 2)
+	<-readDone
+	if err != nil || proxyInfos[0].GetExitIP() != "1.2.3.4" || proxyResults[proxyInfos[2].ID].ExitIP != "5.6.7.8" {
+		t.Fatal("resolve exit ip error", err)
+	}
+	// A、B 在同一个 /24 网段，保留更快的 B
+	survivors := dedupExitIP(fInfo, proxyInfos, proxyResults, nil)
+	if len(survivors) != 2 || proxyResults[proxyInfos[0].ID].FailureCategory != FailureDuplicateIP {
+		t.Fatal("dedup exit ip error", len(survivors))
+	}
+
+	// 选择节点的时候不会连续给出同一个出口的节点
+	p.filterProxyLocker.Lock()
+	p.filterInfos[fInfo.KeyName] = fInfo
+	p.filterProxyIDList[fInfo.KeyName] = []string{proxyInfos[0].ID, proxyInfos[1].ID, proxyInfos[2].ID}
+	p.filterProxyLocker.Unlock()
+	lastExitGroup := ""
+	for i := 0; i < 6; i++ {
+		proxyInfo, err := p.ForKey(fInfo.KeyName).GetOneProxyInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		exitGroup := ExitIPGroup(proxyInfo.GetExitIP(), fInfo.ExitIPPrefix)
+		if exitGroup == lastExitGroup {
+			t.Fatal("same exit in a row", exitGroup)
+		}
+		lastExitGroup = exitGroup
+	}
+}
@@ -0,0 +1,168 @@
+package rod_helper
+
+import (
+	"context"
+	"time"
+)
+
+// filterTask 后台定期重新过滤的任务
+type filterTask struct {
+	fInfo      *FilterInfo
+	threadSize int
+	loadType   TryLoadType
+	retryTime  time.Time // 上一次过滤失败后，下一次重试的时间
+}
+
+// AddFilterTask 注册需要在后台定期重新过滤的 KeyName，同一个 KeyName 重复注册会替换之前的任务
+// StartFilterScheduler 之后，按照 FilterInfo 的有效期在过期之前重新 Filter，建议同时设置 FilterInfo.Incremental
+func (b *Pool) AddFilterTask(fInfo *FilterInfo, threadSize int, loadType TryLoadType) {
+
+	b.filterSchedulerLocker.Lock()
+	b.filterTasks[fInfo.KeyName] = &filterTask{fInfo: fInfo, threadSize: threadSize, loadType: loadType}
+	b.filterSchedulerLocker.Unlock()
+	b.wakeFilterScheduler()
+}
+
+// RemoveFilterTask 移除后台过滤的任务，正在进行的过滤不会被中断
+func (b *Pool) RemoveFilterTask(keyName string) {
+
+	b.filterSchedulerLocker.Lock()
+	delete(b.filterTasks, keyName)
+	b.filterSchedulerLocker.Unlock()
+}
+
+// StartFilterScheduler 启动后台过滤，启动的时候就会过滤没有结果或者已经过期的 KeyName，重复调用会先停止之前的后台过滤
+func (b *Pool) StartFilterScheduler() {
+
+	b.StopFilterScheduler()
+
+	b.filterSchedulerLocker.Lock()
+	defer b.filterSchedulerLocker.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	b.filterSchedulerCancel = cancel
+	b.filterSchedulerWg.Add(1)
+	go func() {
+		defer b.filterSchedulerWg.Done()
+		for {
+			nextTime := b.runFilterTasks(ctx)
+			timer := time.NewTimer(time.Until(nextTime))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-b.filterSchedulerWake:
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	}()
+}
+
+// StopFilterScheduler 停止后台过滤，会中断正在进行的过滤并等待退出，之前的过滤结果不受影响
+func (b *Pool) StopFilterScheduler() {
+
+	b.filterSchedulerLocker.Lock()
+	if b.filterSchedulerCancel != nil {
+		b.filterSchedulerCancel()
+		b.filterSchedulerCancel = nil
+	}
+	b.filterSchedulerLocker.Unlock()
+
+	b.filterSchedulerWg.Wait()
+}
+
+// runFilterTasks 过滤已经到期的任务，返回下一个任务到期的时间
+func (b *Pool) runFilterTasks(ctx context.Context) time.Time {
+
+	b.filterSchedulerLocker.Lock()
+	tasks := make([]*filterTask, 0, len(b.filterTasks))
+	for _, task := range b.filterTasks {
+		tasks = append(tasks, task)
+	}
+	b.filterSchedulerLocker.Unlock()
+
+	nextTime := time.Now().Add(filterSchedulerMaxWaitTime)
+	for _, task := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		taskTime := b.nextFilterTime(task.fInfo)
+		if task.retryTime.After(taskTime) == true {
+			taskTime = task.retryTime
+		}
+		if taskTime.After(time.Now()) == true {
+			if taskTime.Before(nextTime) == true {
+				nextTime = taskTime
+			}
+			continue
+		}
+
+		_, err := b.FilterWithContext(ctx, task.fInfo, task.threadSize, task.loadType)
+		if err != nil {
+			b.log.Warningln("Pool.FilterScheduler", task.fInfo.KeyName, err)
+			// 失败的时候不要立即重试
+			task.retryTime = time.Now().Add(filterSchedulerRetryTime)
+			if task.retryTime.Before(nextTime) == true {
+				nextTime = task.retryTime
+			}
+			continue
+		}
+		task.retryTime = time.Time{}
+		taskTime = b.nextFilterTime(task.fInfo)
+		if taskTime.Before(nextTime) == true {
+			nextTime = taskTime
+		}
+	}
+	return nextTime
+}
+
+func (b *Pool) wakeFilterScheduler() {
+	select {
+	case b.filterSchedulerWake <- struct{}{}:
+	default:
+	}
+}
+
+// nextFilterTime 这个 KeyName 下一次需要 Filter 的时间
+func (b *Pool) nextFilterTime(fInfo *FilterInfo) time.Time {
+
+	b.filterProxyLocker.Lock()
+	defer b.filterProxyLocker.Unlock()
+	return nextFilterTime(fInfo, b.filterProxyIDList[fInfo.KeyName],
+		b.filterProxyInfoUpdateTime[fInfo.KeyName], b.filterProxyTestTime[fInfo.KeyName])
+}
+
+// nextFilterTime 没有过滤结果的时候需要立即 Filter，否则在过滤结果过期的时候
+// 增量模式下，还需要在最早通过测试的节点快要过期的时候 Filter
+func nextFilterTime(fInfo *FilterInfo, proxyIDs []string, updateTime int64, testTimes map[string]int64) time.Time {
+
+	if len(proxyIDs) < 1 {
+		return time.Time{}
+	}
+	nextTime := time.Unix(updateTime, 0).Add(fInfo.GetCacheTTL())
+	if fInfo.Incremental == false {
+		return nextTime
+	}
+	retestAfter := fInfo.GetCacheTTL() - fInfo.GetNearExpiry()
+	for _, id := range proxyIDs {
+		retestTime := time.Unix(proxyTestTime(testTimes, id, updateTime), 0).Add(retestAfter)
+		if retestTime.Before(nextTime) == true {
+			nextTime = retestTime
+		}
+	}
+	return nextTime
+}
+
+// proxyTestTime 节点最后一次通过测试的时间，旧版本的缓存没有记录的时候使用整个列表的更新时间
+func proxyTestTime(testTimes map[string]int64, proxyID string, updateTime int64) int64 {
+	if testTime, found := testTimes[proxyID]; found == true {
+		return testTime
+	}
+	return updateTime
+}
+
+const (
+	defFilterCacheTTL          = 24 * time.Hour
+	filterSchedulerMaxWaitTime = 10 * time.Minute // 没有任务到期的时候，最长多久再检查一次
+	filterSchedulerRetryTime   = time.Minute      // 过滤失败后多久重试
+)
@@ -0,0 +1,31 @@
+package rod_helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextFilterTime(t *testing.T) {
+
+	fInfo := &FilterInfo{KeyName: "imdb", CacheTTL: 4 * time.Hour}
+	if nextFilterTime(fInfo, nil, time.Now().Unix(), nil).IsZero() == false {
+		t.Fatal("empty list should filter now")
+	}
+
+	now := time.Now()
+	updateTime := now.Add(-time.Hour).Unix()
+	testTimes := map[string]int64{"a": updateTime, "b": now.Add(-3*time.Hour - 30*time.Minute).Unix()}
+	// 不是增量模式，只看整个列表的更新时间
+	if nextFilterTime(fInfo, []string{"a", "b"}, updateTime, testTimes).Unix() != now.Add(3*time.Hour).Unix() {
+		t.Fatal("full mode next filter time error")
+	}
+	// 增量模式，b 在一小时之内过期，需要立即重新测试
+	fInfo.Incremental = true
+	if nextFilterTime(fInfo, []string{"a", "b"}, updateTime, testTimes).After(now) == true {
+		t.Fatal("near expiry proxy should be retested")
+	}
+	// 没有记录测试时间的节点使用列表的更新时间
+	if nextFilterTime(fInfo, []string{"a", "c"}, updateTime, testTimes).Unix() != now.Add(2*time.Hour).Unix() {
+		t.Fatal("incremental mode next filter time error")
+	}
+}
@@ -0,0 +1,215 @@
+package rod_helper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/WQGroup/logger"
+	"github.com/go-rod/rod"
+	"github.com/panjf2000/ants/v2"
+)
+
+// runFilterStage 使用这个阶段的设置测试 proxyInfos，返回通过的节点
+// 没有通过的节点会记录到报告中，通过的节点的结果留在 proxyResults 中，由下一个阶段继续补充
+func (b *Pool) runFilterStage(ctx context.Context, fInfo *FilterInfo, stageIndex int, stage FilterStage,
+	proxyInfos []*XrayPoolProxyInfo, proxyResults map[string]*ProxyFilterResult, report *FilterReport) ([]*XrayPoolProxyInfo, error) {
+
+	var err error
+	var nowBrowser *BrowserInfo
+	if stage.LoadType == WebPageWithBrowser {
+		nowBrowser, err = b.NewBrowser()
+		if err != nil {
+			return nil, err
+		}
+		defer nowBrowser.Close()
+	}
+	for _, proxyInfo := range proxyInfos {
+		if _, found := proxyResults[proxyInfo.ID]; found == false {
+			proxyResults[proxyInfo.ID] = &ProxyFilterResult{
+				ProxyID:     proxyInfo.ID,
+				ProxyName:   proxyInfo.Name,
+				ProxyIndex:  proxyInfo.Index,
+				PageResults: make([]PageResult, 0),
+			}
+		}
+	}
+
+	var locker sync.Mutex
+	survivors := make([]*XrayPoolProxyInfo, 0)
+	var wg sync.WaitGroup
+	p, err := ants.NewPoolWithFunc(stage.ThreadSize, func(inData interface{}) {
+		deliveryInfo := inData.(DeliveryInfo)
+		defer func() {
+			deliveryInfo.Wg.Done()
+			logger.Infoln("Pool.Filter", deliveryInfo.ProxyInfo.Name, deliveryInfo.ProxyInfo.Index, "Stage", stageIndex, "End")
+		}()
+
+		logger.Infoln("Pool.Filter", deliveryInfo.ProxyInfo.Name, deliveryInfo.ProxyInfo.Index, "Stage", stageIndex, "Start...")
+
+		// 每个节点的结果只有自己的协程会修改
+		proxyResult := proxyResults[deliveryInfo.ProxyInfo.ID]
+		totalWeight := 0.0
+		for _, pageInfo := range deliveryInfo.PageInfos {
+			totalWeight += pageInfo.GetWeight()
+		}
+		passedWeight := 0.0
+		passedCount := 0
+		remainWeight := totalWeight
+		requiredFailed := false
+		failureCategory := FailureNone
+		// 测试这节点
+		for _, pageInfo := range deliveryInfo.PageInfos {
+
+			if ctx.Err() != nil {
+				// 已经取消了，无需继续
+				break
+			}
+			pageResults, err := b.tryFilterPage(ctx, deliveryInfo, stageIndex, pageInfo)
+			proxyResult.PageResults = append(proxyResult.PageResults, pageResults...)
+			remainWeight -= pageInfo.GetWeight()
+			if err != nil {
+				failureCategory, _ = ClassifyPageLoadError(err)
+				if pageInfo.Optional == false {
+					// 必须通过的页面失败了就无需继续了
+					requiredFailed = true
+					break
+				}
+				if fInfo.MinPassRatio > 0 && passedWeight+remainWeight < fInfo.MinPassRatio*totalWeight {
+					// 剩下的页面全部通过也达不到比例，无需继续了
+					break
+				}
+				continue
+			}
+			passedWeight += pageInfo.GetWeight()
+			passedCount++
+		}
+
+		if ctx.Err() != nil {
+			// 取消导致的失败不计入健康信息，也不计入报告
+			return
+		}
+		locker.Lock()
+		defer locker.Unlock()
+		// 全部都是 Optional 的页面并且 MinPassRatio 为 0 的时候，至少也需要通过一个页面
+		if requiredFailed == false && passedCount > 0 && passedWeight >= fInfo.MinPassRatio*totalWeight {
+			// 通过了这个阶段才能够进入下一个阶段
+			survivors = append(survivors, deliveryInfo.ProxyInfo)
+		} else {
+			proxyResult.FailureCategory = failureCategory
+			b.healthTracker.RecordFailure(deliveryInfo.ProxyInfo.ID, fInfo.KeyName)
+			report.Proxies = append(report.Proxies, proxyResult)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer p.Release()
+	// 过滤
+	pageInfos := stage.GetPageInfos(fInfo.PageInfos)
+	for _, proxyInfo := range proxyInfos {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+
+		err = p.Invoke(DeliveryInfo{
+			Browser:   nowBrowser,
+			ProxyInfo: proxyInfo,
+			PageInfos: pageInfos,
+			Wg:        &wg,
+			LoadType:  stage.LoadType,
+		})
+		if err != nil {
+			logger.Errorf("Pool.Filter 工作池提交任务失败: %v", err)
+			wg.Done()
+			wg.Wait()
+			return nil, err
+		}
+	}
+	wg.Wait()
+
+	return survivors, nil
+}
+
+// tryFilterPage 测试一个页面，失败后按 PageInfo.RetryCount 重试，每次重试都使用新的 http client 或者新的 page，返回每一次尝试的结果
+func (b *Pool) tryFilterPage(ctx context.Context, deliveryInfo DeliveryInfo, stageIndex int, pageInfo PageInfo) ([]PageResult, error) {
+
+	pageResults := make([]PageResult, 0, 1)
+	var err error
+	for attempt := 0; attempt <= pageInfo.RetryCount; attempt++ {
+
+		if attempt > 0 {
+			// 等待一段时间再重试
+			select {
+			case <-ctx.Done():
+				return pageResults, ctx.Err()
+			case <-time.After(time.Duration(pageInfo.RetryInterval) * time.Second):
+			}
+		}
+		var speedResult int
+		var statusCode int
+		start := time.Now()
+		if deliveryInfo.LoadType == WebPageWithHttpClient {
+			// 使用 http client 测试
+			speedResult, statusCode, err = b.tryLoadUrl(ctx, deliveryInfo.ProxyInfo, pageInfo)
+			if err != nil {
+				logger.Errorf("Pool.Filter TryLoadUrl error: %v", err)
+			}
+		} else {
+			// 使用浏览器测试
+			var nowPage *rod.Page
+			speedResult, statusCode, nowPage, err = b.tryLoadPage(ctx, deliveryInfo.Browser, deliveryInfo.ProxyInfo, pageInfo, filterStatusCodeInfos, true)
+			if err != nil {
+				logger.Errorf("Pool.Filter TryLoadPage error: %v", err)
+			}
+			if nowPage != nil {
+				_ = nowPage.Close()
+			}
+		}
+
+		pageResult := PageResult{
+			Stage:      stageIndex,
+			Attempt:    attempt,
+			PageName:   pageInfo.Name,
+			Url:        pageInfo.Url,
+			Passed:     err == nil,
+			StatusCode: statusCode,
+			LatencyMs:  time.Since(start).Milliseconds(),
+			Time:       time.Now().Unix(),
+		}
+		if err == nil {
+			pageResult.LatencyMs = int64(speedResult)
+			pageResults = append(pageResults, pageResult)
+			logger.Infoln("Pool.Filter", deliveryInfo.ProxyInfo.Name, deliveryInfo.ProxyInfo.Index, pageInfo.Name, speedResult)
+			return pageResults, nil
+		}
+		pageResult.FailureCategory, _ = ClassifyPageLoadError(err)
+		pageResult.Error = err.Error()
+		pageResults = append(pageResults, pageResult)
+		if ctx.Err() != nil {
+			// 取消了就不需要重试了
+			return pageResults, err
+		}
+	}
+	return pageResults, err
+}
+
+// filterStatusCodeInfos Filter 使用浏览器测试的时候，状态码的检查规则
+var filterStatusCodeInfos = []StatusCodeInfo{
+	{
+		Codes:    []int{404},
+		Operator: Match,
+		WillDo:   Skip,
+	},
+	{
+		Codes:    []int{499},
+		Operator: GreatThan,
+		WillDo:   Skip,
+	},
+	{
+		Codes:    []int{403},
+		Operator: Match,
+		WillDo:   Repeat,
+	},
+}
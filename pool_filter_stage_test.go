@@ -0,0 +1,165 @@
+package rod_helper
+
+import (
+	"context"
+	"github.com/WQGroup/logger"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolRunFilterStage(t *testing.T) {
+
+	InitFakeUA(true, "", "")
+	// httptest 的服务作为 http 代理使用，收到的是完整的 Url
+	goodProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("stage page " + r.URL.Path))
+	}))
+	defer goodProxy.Close()
+	badProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer badProxy.Close()
+
+	source, err := NewStaticProxySource("static", goodProxy.URL, badProxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	p := newEmptyPool(NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{}), source)
+	p.reconcileProxyInfos(proxyInfos)
+
+	fInfo := NewFilterInfo("stage", []PageInfo{{Name: "first", Url: "http://stage.test/first", PageTimeOut: 5}})
+	fInfo.Stages = []FilterStage{
+		{LoadType: WebPageWithHttpClient},
+		{LoadType: WebPageWithHttpClient, PageInfos: []PageInfo{{Name: "second", Url: "http://stage.test/second", PageTimeOut: 5, SuccessWord: []string{"/second"}}}},
+	}
+	stages := fInfo.GetStages(2, WebPageWithBrowser)
+	if len(stages) != 2 || stages[1].ThreadSize != 2 {
+		t.Fatal("GetStages error", stages)
+	}
+
+	report := newFilterReport(fInfo.KeyName, WebPageWithHttpClient)
+	proxyResults := make(map[string]*ProxyFilterResult)
+	survivors, err := p.runFilterStage(context.Background(), fInfo, 0, stages[0], proxyInfos, proxyResults, report)
+	if err != nil || len(survivors) != 1 || survivors[0] != proxyInfos[0] {
+		t.Fatal("first stage error", err, len(survivors))
+	}
+	if len(report.Proxies) != 1 || report.Proxies[0].FailureCategory != FailureStatusCode || report.Proxies[0].PageResults[0].StatusCode != http.StatusForbidden {
+		t.Fatal("first stage report error")
+	}
+	// 只有通过的节点进入第二个阶段
+	survivors, err = p.runFilterStage(context.Background(), fInfo, 1, stages[1], survivors, proxyResults, report)
+	if err != nil || len(survivors) != 1 {
+		t.Fatal("second stage error", err, len(survivors))
+	}
+	pageResults := proxyResults[proxyInfos[0].ID].PageResults
+	if len(pageResults) != 2 || pageResults[1].Stage != 1 || pageResults[1].PageName != "second" {
+		t.Fatal("second stage page results error", pageResults)
+	}
+}
+
+func TestPoolFilterPassCriteria(t *testing.T) {
+
+	InitFakeUA(true, "", "")
+	var requestCount int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			// 第一次失败，重试的时候成功
+			if atomic.AddInt32(&requestCount, 1) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+		case "/optional":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer proxy.Close()
+
+	source, err := NewStaticProxySource("static", proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	p := newEmptyPool(NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{}), source)
+	p.reconcileProxyInfos(proxyInfos)
+
+	fInfo := NewFilterInfo("criteria", []PageInfo{
+		{Name: "flaky", Url: "http://criteria.test/flaky", PageTimeOut: 5, RetryCount: 1, Weight: 2},
+		{Name: "optional", Url: "http://criteria.test/optional", PageTimeOut: 5, Optional: true},
+	})
+	runStage := func() (int, *FilterReport, map[string]*ProxyFilterResult) {
+		atomic.StoreInt32(&requestCount, 0)
+		report := newFilterReport(fInfo.KeyName, WebPageWithHttpClient)
+		proxyResults := make(map[string]*ProxyFilterResult)
+		survivors, err := p.runFilterStage(context.Background(), fInfo, 0, fInfo.GetStages(1, WebPageWithHttpClient)[0], proxyInfos, proxyResults, report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(survivors), report, proxyResults
+	}
+
+	// 可选的页面失败不影响通过
+	passed, _, proxyResults := runStage()
+	pageResults := proxyResults[proxyInfos[0].ID].PageResults
+	if passed != 1 || len(pageResults) != 3 || pageResults[1].Attempt != 1 || pageResults[1].Passed == false {
+		t.Fatal("retry or optional page error", passed, pageResults)
+	}
+	// 通过的权重占比 2/3，达不到要求
+	fInfo.MinPassRatio = 0.8
+	passed, report, _ := runStage()
+	if passed != 0 || len(report.Proxies) != 1 || report.Proxies[0].FailureCategory != FailureStatusCode {
+		t.Fatal("min pass ratio error", passed)
+	}
+	// 全部都是可选的页面，一个都没有通过的时候不能通过
+	fInfo.PageInfos = []PageInfo{{Name: "optional", Url: "http://criteria.test/optional", PageTimeOut: 5, Optional: true}}
+	fInfo.MinPassRatio = 0
+	passed, report, _ = runStage()
+	if passed != 0 || len(report.Proxies) != 1 || report.Proxies[0].FailureCategory != FailureStatusCode {
+		t.Fatal("all optional pages failed should not pass", passed)
+	}
+}
+
+func TestPoolFilterRecordSuccessAfterElimination(t *testing.T) {
+
+	InitFakeUA(true, "", "")
+	fastProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer fastProxy.Close()
+	slowProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer slowProxy.Close()
+
+	source, err := NewStaticProxySource("static", fastProxy.URL, slowProxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	options := NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{})
+	options.SetStateStore(NewMemoryStateStore())
+	p := newEmptyPool(options, source)
+	p.reconcileProxyInfos(proxyInfos)
+
+	fInfo := NewFilterInfo("fastest", []PageInfo{{Name: "page", Url: "http://fastest.test/", PageTimeOut: 5}})
+	fInfo.KeepFastest = 1
+	report, err := p.Filter(fInfo, 2, WebPageWithHttpClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passedIDs := report.PassedProxyIDs()
+	if len(passedIDs) != 1 || passedIDs[0] != proxyInfos[0].ID {
+		t.Fatal("keep fastest error", passedIDs)
+	}
+	// 被 KeepFastest 淘汰的节点不能计入成功
+	if p.healthTracker.Get(proxyInfos[0].ID, fInfo.KeyName).Samples != 1 || p.healthTracker.Get(proxyInfos[1].ID, fInfo.KeyName).Samples != 0 {
+		t.Fatal("eliminated node should not get health credit")
+	}
+}
@@ -0,0 +1,166 @@
+package rod_helper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeySelector 某个 KeyName 的节点选择器，有自己的轮询位置以及锁，多个协程可以同时通过不同的 KeySelector 爬取不同的网站
+type KeySelector struct {
+	pool    *Pool
+	keyName string
+	cursor  int        // 没有可用节点的时候，轮询给出节点的位置
+	locker  sync.Mutex // cursor 的锁
+}
+
+// ForKey 获取这个 KeyName 的选择器，keyName 为空对应全部的代理列表。同一个 KeyName 返回同一个选择器
+func (b *Pool) ForKey(keyName string) *KeySelector {
+
+	b.selectorLocker.Lock()
+	defer b.selectorLocker.Unlock()
+	selector, found := b.keySelectors[keyName]
+	if found == true {
+		return selector
+	}
+	// 本进程还没有 Filter 过这个 KeyName，尝试读取本地的缓存，内存中已经有的时候不能被旧的缓存覆盖
+	b.filterProxyLocker.Lock()
+	_, loaded := b.filterProxyIDList[keyName]
+	b.filterProxyLocker.Unlock()
+	if keyName != "" && loaded == false {
+		err := b.loadFilterProxyIndex(keyName)
+		if err != nil {
+			b.log.Debugln("Pool.ForKey", keyName, err)
+		}
+	}
+	b.filterProxyLocker.Lock()
+	cursor := b.nowFilterProxyInfoIndex[keyName]
+	b.filterProxyLocker.Unlock()
+
+	selector = &KeySelector{pool: b, keyName: keyName, cursor: cursor}
+	b.keySelectors[keyName] = selector
+	return selector
+}
+
+// KeyName 选择器对应的 KeyName
+func (k *KeySelector) KeyName() string {
+	return k.keyName
+}
+
+// SetSelectionStrategy 设置这个 KeyName 选择节点的策略
+func (k *KeySelector) SetSelectionStrategy(strategy SelectionStrategy) {
+	k.pool.SetSelectionStrategy(k.keyName, strategy)
+}
+
+// GetFilterProxyInfos 获取这个 KeyName 过滤后的代理节点
+func (k *KeySelector) GetFilterProxyInfos() ([]*XrayPoolProxyInfo, error) {
+	if k.keyName == "" {
+		return k.pool.GetProxyInfos(), nil
+	}
+	return k.pool.GetFilterProxyInfos(k.keyName)
+}
+
+// Acquire 租用这个 KeyName 的一个节点，见 Pool.Acquire
+func (k *KeySelector) Acquire(ctx context.Context) (*Lease, error) {
+	return k.pool.Acquire(ctx, k.keyName)
+}
+
+// GetOneProxyInfo 按这个 KeyName 的 SelectionStrategy 获取一个代理实例，直接给出这个代理的信息，不会考虑访问的频率问题
+// 被 Acquire 租用的节点不会给出，全部节点都被租用的时候返回 ErrProxyNodesLeased
+// 全部节点都需要跳过的时候，轮询给出一个节点以及 ErrSkipAccessTime
+func (k *KeySelector) GetOneProxyInfo() (*XrayPoolProxyInfo, error) {
+	return k.getOneProxyInfo()
+}
+
+// GetProxyInfoSync 根据 TimeConfig 设置，寻找一个可用的节点。可以并发用，没有可用节点的时候会排队阻塞等待，先到先得
+func (k *KeySelector) GetProxyInfoSync(baseUrl string) (*XrayPoolProxyInfo, error) {
+	return k.GetProxyInfoSyncWithContext(context.Background(), baseUrl)
+}
+
+// GetProxyInfoSyncWithContext 同 GetProxyInfoSync，ctx 结束的时候中断等待，返回 ctx.Err()
+func (k *KeySelector) GetProxyInfoSyncWithContext(ctx context.Context, baseUrl string) (*XrayPoolProxyInfo, error) {
+	return k.GetProxyInfoSyncWithPriority(ctx, baseUrl, WaitPriorityNormal)
+}
+
+// GetProxyInfoSyncWithPriority 同 GetProxyInfoSyncWithContext，priority 越大越先分配到节点，相同优先级先到先得
+// 节点在分配的时候就按 TimeConfig 决定了下一次可以使用的时间，返回之后可以直接使用，不需要再等待
+func (k *KeySelector) GetProxyInfoSyncWithPriority(ctx context.Context, baseUrl string, priority WaitPriority) (*XrayPoolProxyInfo, error) {
+
+	b := k.pool
+	outProxyInfo, err := b.waitProxyInfo(ctx, k.keyName, priority)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "browser.GetOneProxyInfo error")
+	}
+	b.log.Infoln("Now Proxy:", outProxyInfo.Name, outProxyInfo.Index, k.keyName, baseUrl)
+	return outProxyInfo, nil
+}
+
+// getOneProxyInfo 同 GetOneProxyInfo
+func (k *KeySelector) getOneProxyInfo() (*XrayPoolProxyInfo, error) {
+
+	b := k.pool
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	nowUnixTime := time.Now().Unix()
+
+	candidates, err := b.leaseCandidates(k.keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	eligible := make([]*XrayPoolProxyInfo, 0, len(candidates))
+	notLeased := make([]*XrayPoolProxyInfo, 0, len(candidates))
+	for _, proxyInfo := range candidates {
+		if proxyInfo.leased == true {
+			// 被租约独占的节点不能给出去
+			continue
+		}
+		notLeased = append(notLeased, proxyInfo)
+		if proxyInfo.skipAccessTime > nowUnixTime {
+			// 这个节点需要跳过
+			continue
+		}
+		if allowed, _ := b.proxyAllowedByCircuit(proxyInfo, k.keyName); allowed == false {
+			// 这个节点在当前的 KeyName 中熔断了
+			continue
+		}
+		eligible = append(eligible, proxyInfo)
+	}
+	if len(eligible) > 0 {
+		selected := b.selectProxy(k.keyName, eligible)
+		// 记录最后一次获取这个 ProxyInfo 的 UnixTime
+		selected.FirTimeAccess = false
+		selected.lastAccessTime = nowUnixTime
+		b.delaySaveProxyNodeState()
+		return selected, nil
+	}
+
+	if len(notLeased) < 1 {
+		return nil, ErrProxyNodesLeased
+	}
+	// 没有可以使用的节点，在没有被租用的节点中轮询给出一个
+	k.locker.Lock()
+	nowProxyInfo := notLeased[k.cursor%len(notLeased)]
+	k.cursor = (k.cursor + 1) % len(notLeased)
+	k.locker.Unlock()
+	return nowProxyInfo, ErrSkipAccessTime
+}
+
+// getCursor 当前轮询的位置，用于保存缓存
+func (k *KeySelector) getCursor() int {
+	k.locker.Lock()
+	defer k.locker.Unlock()
+	return k.cursor
+}
+
+// resetCursor 重置轮询的位置
+func (k *KeySelector) resetCursor() {
+	k.locker.Lock()
+	defer k.locker.Unlock()
+	k.cursor = 0
+}
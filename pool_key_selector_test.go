@@ -0,0 +1,120 @@
+package rod_helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/WQGroup/logger"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolForKey(t *testing.T) {
+
+	source, err := NewStaticProxySource("static", "http://127.0.0.1:10809", "http://127.0.0.1:10810", "http://127.0.0.1:10811")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	p := newEmptyPool(NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{}), source)
+	p.reconcileProxyInfos(proxyInfos)
+	p.filterProxyLocker.Lock()
+	p.filterProxyIDList["a"] = []string{proxyInfos[0].ID, proxyInfos[1].ID}
+	p.filterProxyLocker.Unlock()
+
+	if p.ForKey("a") != p.ForKey("a") {
+		t.Fatal("ForKey should return the same selector")
+	}
+	if err = p.SetKeyName("not_exist"); errors.Is(err, ErrKeyNameIsNotExist) == false {
+		t.Fatal("SetKeyName should fail", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				proxyInfo, err := p.ForKey("a").GetOneProxyInfo()
+				if err != nil && errors.Is(err, ErrSkipAccessTime) == false {
+					t.Error(err)
+					return
+				}
+				if proxyInfo.ID == proxyInfos[2].ID {
+					t.Error("proxy not in filter list")
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := p.ForKey("").GetOneProxyInfo()
+				if err != nil && errors.Is(err, ErrSkipAccessTime) == false {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			lease, err := p.ForKey("a").Acquire(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			p.reconcileProxyInfos(proxyInfos)
+			lease.Release()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPoolForKeyNotOverwriteMemory(t *testing.T) {
+
+	source, err := NewStaticProxySource("static", "http://127.0.0.1:10809", "http://127.0.0.1:10810")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	options := NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{})
+	options.SetStateStore(NewMemoryStateStore())
+	p := newEmptyPool(options, source)
+	p.reconcileProxyInfos(proxyInfos)
+
+	// 本地有一个旧的缓存
+	oldUpdateTime := time.Now().Add(-48 * time.Hour).Unix()
+	for _, keyName := range []string{"a", "b"} {
+		pc := NewProxyCache()
+		pc.UpdateTime = oldUpdateTime
+		pc.FilterProxyIDList = []string{proxyInfos[0].ID}
+		err = p.stateStore.Save(fmt.Sprintf(proxyCacheFileName, keyName), pc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 内存中已经有新的过滤结果，第一次 ForKey 不能用旧的缓存覆盖
+	newUpdateTime := time.Now().Unix()
+	p.filterProxyLocker.Lock()
+	p.filterProxyIDList["a"] = []string{proxyInfos[1].ID}
+	p.filterProxyInfoUpdateTime["a"] = newUpdateTime
+	p.filterProxyLocker.Unlock()
+	p.ForKey("a")
+	p.filterProxyLocker.Lock()
+	ids, updateTime := p.filterProxyIDList["a"], p.filterProxyInfoUpdateTime["a"]
+	p.filterProxyLocker.Unlock()
+	if len(ids) != 1 || ids[0] != proxyInfos[1].ID || updateTime != newUpdateTime {
+		t.Fatal("ForKey should not overwrite the filter result in memory", ids, updateTime)
+	}
+
+	// 内存中没有的时候读取本地的缓存
+	p.ForKey("b")
+	p.filterProxyLocker.Lock()
+	ids, updateTime = p.filterProxyIDList["b"], p.filterProxyInfoUpdateTime["b"]
+	p.filterProxyLocker.Unlock()
+	if len(ids) != 1 || ids[0] != proxyInfos[0].ID || updateTime != oldUpdateTime {
+		t.Fatal("ForKey should load the cache", ids, updateTime)
+	}
+}
@@ -0,0 +1,220 @@
+package rod_helper
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Lease 独占使用一个代理节点的租约，使用完毕后必须调用 Success、Fail、Banned、Release 其中之一归还
+type Lease struct {
+	pool        *Pool
+	proxyInfo   *XrayPoolProxyInfo
+	keyName     string
+	acquireTime time.Time
+	released    bool
+	locker      sync.Mutex
+}
+
+// ProxyInfo 租用的代理节点
+func (l *Lease) ProxyInfo() *XrayPoolProxyInfo {
+	return l.proxyInfo
+}
+
+// KeyName 租用时指定的 KeyName
+func (l *Lease) KeyName() string {
+	return l.keyName
+}
+
+// AcquireTime 租用的时间
+func (l *Lease) AcquireTime() time.Time {
+	return l.acquireTime
+}
+
+// Success 这次请求成功了，latency 是实际请求的耗时，会归还租约
+func (l *Lease) Success(latency time.Duration) {
+	l.finish(func(proxyInfo *XrayPoolProxyInfo) {
+		proxyInfo.stats.SuccessCount++
+		proxyInfo.stats.LastLatency = latency
+		l.pool.recordProxySuccess(proxyInfo, l.keyName, latency)
+	})
+}
+
+// Fail 这次请求失败了，会归还租约
+func (l *Lease) Fail(reason error) {
+	l.finish(func(proxyInfo *XrayPoolProxyInfo) {
+		proxyInfo.stats.FailCount++
+		if reason != nil {
+			proxyInfo.stats.LastFailReason = reason.Error()
+		}
+		l.pool.recordProxyFailure(proxyInfo, l.keyName)
+	})
+}
+
+// Banned 这个节点被目标网站封禁了，retryAfter 之后才可以再次使用，小于等于 0 的时候使用 TimeConfig.ProxyNodeSkipAccessTime，会归还租约
+// 指定了 KeyName 的时候只在这个 KeyName 中熔断，不影响其他网站使用这个节点
+func (l *Lease) Banned(retryAfter time.Duration) {
+	l.finish(func(proxyInfo *XrayPoolProxyInfo) {
+		proxyInfo.stats.BannedCount++
+		l.pool.healthTracker.RecordFailure(l.proxyInfo.ID, l.keyName)
+		if l.keyName != "" {
+			if retryAfter <= 0 {
+				retryAfter = time.Duration(l.pool.rodOptions.timeConfig.ProxyNodeSkipAccessTime) * time.Second
+			}
+			l.pool.circuitBreaker.Trip(l.proxyInfo.ID, l.keyName, retryAfter)
+			l.pool.log.Infoln("Lease.Banned", l.proxyInfo.Name, l.proxyInfo.Index, l.keyName, retryAfter)
+			return
+		}
+		skipAccessTime := l.pool.rodOptions.timeConfig.GetProxyNodeSkipAccessTime()
+		if retryAfter > 0 {
+			skipAccessTime = time.Now().Add(retryAfter).Unix()
+		}
+		proxyInfo.skipAccessTime = skipAccessTime
+		l.pool.log.Infoln("Lease.Banned", l.proxyInfo.Name, l.proxyInfo.Index, l.keyName, skipAccessTime)
+	})
+	// 封禁、熔断需要跨重启生效
+	l.pool.saveProxyNodeState()
+}
+
+// Release 不反馈结果，仅归还租约，重复调用无影响
+func (l *Lease) Release() {
+	l.finish(nil)
+}
+
+// finish 归还租约，刷新后节点可能换成了新的对象，record 修改的是当前列表中的节点
+func (l *Lease) finish(record func(proxyInfo *XrayPoolProxyInfo)) {
+
+	l.locker.Lock()
+	defer l.locker.Unlock()
+	if l.released == true {
+		return
+	}
+	l.released = true
+
+	b := l.pool
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	proxyInfo := b.currentProxyInfo(l.proxyInfo)
+	if record != nil {
+		record(proxyInfo)
+	}
+	proxyInfo.stats.InFlight--
+	proxyInfo.leased = false
+	b.notifyProxyReady()
+}
+
+// ProxyStats 一个代理节点通过租约反馈的统计信息
+type ProxyStats struct {
+	InFlight       int           // 正在使用的租约数量
+	SuccessCount   int64         // 成功的次数
+	FailCount      int64         // 失败的次数
+	BannedCount    int64         // 被封禁的次数
+	LastLatency    time.Duration // 最后一次成功的耗时
+	LastFailReason string        // 最后一次失败的原因
+}
+
+// GetProxyStats 获取这个节点的统计信息
+func (b *Pool) GetProxyStats(proxyInfo *XrayPoolProxyInfo) ProxyStats {
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	return b.currentProxyInfo(proxyInfo).stats
+}
+
+// Acquire 租用一个代理节点，keyName 为空的时候从全部的代理中选择，否则从 Filter 后的列表中选择
+// 同一个节点同时只会租给一个使用者，并且会遵守 TimeConfig 中的使用间隔以及封禁时间，没有可用的节点会阻塞等待，直到 ctx 结束
+// 多个节点可用的时候，按这个 KeyName 的 SelectionStrategy 选择
+func (b *Pool) Acquire(ctx context.Context, keyName string) (*Lease, error) {
+
+	for {
+		proxyInfo, waitTime, released, err := b.tryAcquire(keyName)
+		if err != nil {
+			return nil, err
+		}
+		if proxyInfo != nil {
+			return &Lease{
+				pool:        b,
+				proxyInfo:   proxyInfo,
+				keyName:     keyName,
+				acquireTime: time.Now(),
+			}, nil
+		}
+		// 等待有节点归还，或者有节点到了可以使用的时间
+		timer := time.NewTimer(waitTime)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// tryAcquire 尝试租用一个节点，没有可用节点的时候返回需要等待的时间
+func (b *Pool) tryAcquire(keyName string) (*XrayPoolProxyInfo, time.Duration, <-chan struct{}, error) {
+
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+
+	candidates, err := b.leaseCandidates(keyName)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	now := time.Now()
+	proxyInfo, waitTime := b.pickReadyProxy(keyName, candidates, now)
+	if proxyInfo == nil {
+		return nil, waitTime, b.proxyReady, nil
+	}
+
+	proxyInfo.leased = true
+	proxyInfo.stats.InFlight++
+	proxyInfo.lastAccessTime = now.Unix()
+	proxyInfo.FirTimeAccess = false
+	b.delaySaveProxyNodeState()
+	return proxyInfo, 0, nil, nil
+}
+
+// leaseCandidates 获取可以租用的节点列表，Close 之后返回 ErrPoolClosed，需要在 httpProxyLocker 中调用
+func (b *Pool) leaseCandidates(keyName string) ([]*XrayPoolProxyInfo, error) {
+
+	if b.closed == true {
+		return nil, ErrPoolClosed
+	}
+	if len(b.orgProxyInfos) < 1 {
+		return nil, ErrProxyInfosIsEmpty
+	}
+	if keyName == "" {
+		return b.orgProxyInfos, nil
+	}
+
+	b.filterProxyLocker.Lock()
+	defer b.filterProxyLocker.Unlock()
+	ids, found := b.filterProxyIDList[keyName]
+	if found == false {
+		return nil, ErrKeyNameIsNotExist
+	}
+	candidates := make([]*XrayPoolProxyInfo, 0, len(ids))
+	for _, id := range ids {
+		index, found := b.proxyIDIndex[id]
+		if found == false {
+			continue
+		}
+		candidates = append(candidates, b.orgProxyInfos[index])
+	}
+	if len(candidates) < 1 {
+		return nil, ErrProxyInfosIsEmpty
+	}
+	return candidates, nil
+}
+
+// notifyProxyReady 有租约归还或者节点状态变化，唤醒所有等待租约的调用者，并重新调度 GetProxyInfoSync 的等待队列，需要在 httpProxyLocker 中调用
+func (b *Pool) notifyProxyReady() {
+	close(b.proxyReady)
+	b.proxyReady = make(chan struct{})
+	b.dispatchAllProxyWaiters()
+}
+
+// leaseMaxWaitTime 没有可用节点的时候，最长等待多久再检查一次
+const leaseMaxWaitTime = 5 * time.Second
@@ -0,0 +1,77 @@
+package rod_helper
+
+import (
+	"context"
+	"errors"
+	"github.com/WQGroup/logger"
+	"testing"
+	"time"
+)
+
+func TestPoolAcquire(t *testing.T) {
+
+	source, err := NewStaticProxySource("static", "http://127.0.0.1:10809", "http://127.0.0.1:10810")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	opt := NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{})
+	// 封禁会保存节点状态，不要写到当前目录
+	opt.SetStateStore(NewMemoryStateStore())
+	p := newEmptyPool(opt, source)
+	p.reconcileProxyInfos(proxyInfos)
+
+	leaseA, err := p.Acquire(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaseB, err := p.Acquire(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaseA.ProxyInfo() == leaseB.ProxyInfo() {
+		t.Fatal("one proxy leased twice")
+	}
+	// 全部被租用，需要等待归还
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = p.Acquire(ctx, "")
+	cancel()
+	if errors.Is(err, context.DeadlineExceeded) == false {
+		t.Fatal("acquire should wait", err)
+	}
+	// 被租用的节点也不会通过 GetOneProxyInfo、GetProxyInfoSync 给出去
+	_, err = p.GetOneProxyInfo()
+	if errors.Is(err, ErrProxyNodesLeased) == false {
+		t.Fatal("GetOneProxyInfo should not return leased proxy", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = p.GetProxyInfoSyncWithContext(ctx, "leased")
+	cancel()
+	if errors.Is(err, context.DeadlineExceeded) == false {
+		t.Fatal("GetProxyInfoSync should wait for leased proxy", err)
+	}
+
+	go leaseA.Success(10 * time.Millisecond)
+	leaseC, err := p.Acquire(context.Background(), "")
+	if err != nil || leaseC.ProxyInfo() != leaseA.ProxyInfo() {
+		t.Fatal("acquire after release error", err)
+	}
+	if p.GetProxyStats(leaseA.ProxyInfo()).SuccessCount != 1 {
+		t.Fatal("success not recorded")
+	}
+	// 封禁后不会再被租用
+	leaseC.Banned(time.Hour)
+	for i := 0; i < 4; i++ {
+		// 只剩被封禁的节点，轮询时也不会给出被租用的节点
+		proxyInfo, err := p.GetOneProxyInfo()
+		if proxyInfo != leaseC.ProxyInfo() || errors.Is(err, ErrSkipAccessTime) == false {
+			t.Fatal("GetOneProxyInfo returned a leased proxy", err)
+		}
+	}
+	leaseB.Release()
+	leaseD, err := p.Acquire(context.Background(), "")
+	if err != nil || leaseD.ProxyInfo() != leaseB.ProxyInfo() {
+		t.Fatal("banned proxy leased", err)
+	}
+	leaseD.Release()
+}
@@ -0,0 +1,178 @@
+package rod_helper
+
+import (
+	"time"
+
+	"github.com/WQGroup/logger"
+	"github.com/pkg/errors"
+)
+
+// ProxyNodeState 节点需要跨重启保留的运行时状态
+type ProxyNodeState struct {
+	FirTimeAccess  bool  `json:"first_time_access"`
+	SkipAccessTime int64 `json:"skip_access_time"`
+	LastAccessTime int64 `json:"last_access_time"`
+	// Circuits 在 KeyName 中熔断的冷却结束 UnixTime，key 是 KeyName
+	Circuits map[string]int64 `json:"circuits,omitempty"`
+}
+
+// expired 封禁、熔断都已经结束，并且很久没有使用过的节点，状态没有保留的必要了
+func (s ProxyNodeState) expired(now time.Time) bool {
+	for _, openUntil := range s.Circuits {
+		if openUntil > now.Unix() {
+			return false
+		}
+	}
+	return s.SkipAccessTime <= now.Unix() && now.Sub(time.Unix(s.LastAccessTime, 0)) > nodeStateExpireTime
+}
+
+// ProxyNodeStateCache 保存在 StateStore 中的节点状态，key 是节点的 ID
+type ProxyNodeStateCache struct {
+	UpdateTime int64                     `json:"update_time"`
+	Nodes      map[string]ProxyNodeState `json:"nodes"`
+}
+
+func NewProxyNodeStateCache() *ProxyNodeStateCache {
+	return &ProxyNodeStateCache{Nodes: make(map[string]ProxyNodeState)}
+}
+
+// SaveProxyNodeState 保存所有节点的 skipAccessTime、lastAccessTime、FirTimeAccess 以及各个 KeyName 中的熔断，NewPool 的时候会恢复
+// 当前不在列表中的节点，之前保存的状态在过期之前会继续保留，来源恢复这个节点的时候还能用上
+func (b *Pool) SaveProxyNodeState() error {
+
+	b.nodeStateLocker.Lock()
+	defer b.nodeStateLocker.Unlock()
+
+	now := time.Now()
+	needSave := NewProxyNodeStateCache()
+	needSave.UpdateTime = now.Unix()
+	b.httpProxyLocker.Lock()
+	// 熔断以 CircuitBreaker 中的为准，不在列表中的节点的熔断也在里面
+	circuits := b.circuitBreaker.openCircuits(now)
+	for id, state := range b.savedNodeStates {
+		state.Circuits = circuits[id]
+		if state.expired(now) == false {
+			needSave.Nodes[id] = state
+		}
+	}
+	for _, proxyInfo := range b.orgProxyInfos {
+		state := ProxyNodeState{
+			FirTimeAccess:  proxyInfo.FirTimeAccess,
+			SkipAccessTime: proxyInfo.skipAccessTime,
+			LastAccessTime: proxyInfo.lastAccessTime,
+			Circuits:       circuits[proxyInfo.ID],
+		}
+		if proxyInfo.FirTimeAccess == true && proxyInfo.skipAccessTime <= now.Unix() && len(state.Circuits) < 1 {
+			// 没有使用过的节点不需要保存
+			delete(needSave.Nodes, proxyInfo.ID)
+			continue
+		}
+		if state.expired(now) == true {
+			delete(needSave.Nodes, proxyInfo.ID)
+			continue
+		}
+		needSave.Nodes[proxyInfo.ID] = state
+	}
+	for id, nodeCircuits := range circuits {
+		if _, found := needSave.Nodes[id]; found == true {
+			continue
+		}
+		// 只有熔断的节点
+		needSave.Nodes[id] = ProxyNodeState{FirTimeAccess: true, Circuits: nodeCircuits}
+	}
+	b.httpProxyLocker.Unlock()
+
+	return b.stateStore.Save(proxyNodeStateFileName, needSave)
+}
+
+// saveProxyNodeState 节点状态有重要的变化时保存，比如被封禁，失败只记录日志
+func (b *Pool) saveProxyNodeState() {
+	err := b.SaveProxyNodeState()
+	if err != nil {
+		b.log.Warningln("Pool.SaveProxyNodeState", err)
+	}
+}
+
+// delaySaveProxyNodeState 节点的访问时间、熔断有变化时调用，nodeStateSaveDelay 内的多次变化只保存一次，避免异常退出丢失状态
+func (b *Pool) delaySaveProxyNodeState() {
+
+	b.nodeStateTimerLocker.Lock()
+	defer b.nodeStateTimerLocker.Unlock()
+	if b.nodeStateTimer != nil || b.nodeStateTimerStopped == true {
+		return
+	}
+	b.nodeStateTimer = time.AfterFunc(nodeStateSaveDelay, func() {
+		b.nodeStateTimerLocker.Lock()
+		b.nodeStateTimer = nil
+		b.nodeStateTimerLocker.Unlock()
+		b.saveProxyNodeState()
+	})
+}
+
+// stopDelaySaveProxyNodeState 停止延迟保存，Close 的时候会直接保存
+func (b *Pool) stopDelaySaveProxyNodeState() {
+
+	b.nodeStateTimerLocker.Lock()
+	defer b.nodeStateTimerLocker.Unlock()
+	b.nodeStateTimerStopped = true
+	if b.nodeStateTimer != nil {
+		b.nodeStateTimer.Stop()
+		b.nodeStateTimer = nil
+	}
+}
+
+// loadProxyNodeState 恢复本地保存的节点状态，不存在则忽略。还不在列表中的节点的状态先留着，见 restoreProxyNodeState
+func (b *Pool) loadProxyNodeState() error {
+
+	sc := NewProxyNodeStateCache()
+	err := b.stateStore.Load(proxyNodeStateFileName, sc)
+	if err != nil {
+		if errors.Is(err, ErrStateNotFound) == true {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	for id, state := range sc.Nodes {
+		if state.expired(now) == true {
+			continue
+		}
+		// 熔断直接恢复到 CircuitBreaker，不需要等节点出现在列表中
+		for keyName, openUntil := range state.Circuits {
+			if openUntil > now.Unix() {
+				b.circuitBreaker.restore(id, keyName, time.Unix(openUntil, 0))
+			}
+		}
+		b.savedNodeStates[id] = state
+	}
+	for _, proxyInfo := range b.orgProxyInfos {
+		b.restoreProxyNodeState(proxyInfo)
+	}
+	logger.Infoln("loadProxyNodeState", len(b.savedNodeStates))
+	return nil
+}
+
+// restoreProxyNodeState 如果保存过这个节点的状态就恢复，需要在 httpProxyLocker 中调用
+func (b *Pool) restoreProxyNodeState(proxyInfo *XrayPoolProxyInfo) {
+
+	state, found := b.savedNodeStates[proxyInfo.ID]
+	if found == false {
+		return
+	}
+	delete(b.savedNodeStates, proxyInfo.ID)
+	if state.expired(time.Now()) == true {
+		return
+	}
+	proxyInfo.FirTimeAccess = state.FirTimeAccess
+	proxyInfo.skipAccessTime = state.SkipAccessTime
+	proxyInfo.lastAccessTime = state.LastAccessTime
+}
+
+const (
+	proxyNodeStateFileName = "proxy_node_state.json"
+	nodeStateExpireTime    = 24 * time.Hour   // 封禁结束后，超过这个时间没有使用的节点的状态不再保留
+	nodeStateSaveDelay     = 30 * time.Second // 访问时间等变化后，延迟这么久保存一次
+)
@@ -0,0 +1,150 @@
+package rod_helper
+
+import (
+	"context"
+	"github.com/WQGroup/logger"
+	"testing"
+	"time"
+)
+
+func TestPoolProxyNodeState(t *testing.T) {
+
+	source, err := NewStaticProxySource("static", "http://127.0.0.1:1080", "http://127.0.0.1:1081", "http://127.0.0.1:1082")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 两个 Pool 共用一个存储，模拟重启
+	opt := NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{})
+	opt.SetStateStore(NewMemoryStateStore())
+	newTestPool := func() *Pool {
+		proxyInfos, _ := source.Fetch(context.Background())
+		p := newEmptyPool(opt, source)
+		p.reconcileProxyInfos(proxyInfos)
+		return p
+	}
+
+	now := time.Now()
+	p := newTestPool()
+	proxyInfos := p.GetProxyInfos()
+	// 0 被封禁，1 刚刚使用过，2 很久之前使用过，状态已经过期
+	err = p.SetProxyNodeSkipByTime(proxyInfos[0].ID, now.Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.httpProxyLocker.Lock()
+	proxyInfos[1].FirTimeAccess = false
+	proxyInfos[1].lastAccessTime = now.Unix()
+	proxyInfos[2].FirTimeAccess = false
+	proxyInfos[2].lastAccessTime = now.Add(-2 * nodeStateExpireTime).Unix()
+	p.httpProxyLocker.Unlock()
+	err = p.SaveProxyNodeState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启后恢复
+	p2 := newTestPool()
+	err = p2.loadProxyNodeState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := p2.GetProxyInfos()
+	if restored[0].skipAccessTime != now.Add(time.Hour).Unix() || restored[0].FirTimeAccess == false {
+		t.Fatal("restore skipAccessTime error", restored[0].skipAccessTime)
+	}
+	if restored[1].GetLastAccessTime() != now.Unix() || restored[1].FirTimeAccess == true {
+		t.Fatal("restore lastAccessTime error", restored[1].GetLastAccessTime())
+	}
+	if restored[2].GetLastAccessTime() != 0 || restored[2].FirTimeAccess == false {
+		t.Fatal("expired state should not be restored")
+	}
+
+	// 来源暂时去掉了被封禁的节点，恢复之后状态依然在
+	p2.reconcileProxyInfos(restored[1:])
+	proxyInfos, _ = source.Fetch(context.Background())
+	p2.reconcileProxyInfos(proxyInfos)
+	if p2.GetProxyInfos()[0].skipAccessTime != now.Add(time.Hour).Unix() {
+		t.Fatal("removed node state lost")
+	}
+}
+
+func TestPoolProxyNodeCircuitState(t *testing.T) {
+
+	source, err := NewStaticProxySource("static", "http://127.0.0.1:1080", "http://127.0.0.1:1081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemoryStateStore()
+	opt := NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{})
+	opt.SetStateStore(store)
+	newTestPool := func() *Pool {
+		proxyInfos, _ := source.Fetch(context.Background())
+		p := newEmptyPool(opt, source)
+		p.reconcileProxyInfos(proxyInfos)
+		p.filterProxyIDList["imdb"] = []string{proxyInfos[0].ID, proxyInfos[1].ID}
+		return p
+	}
+
+	p := newTestPool()
+	proxyInfos := p.GetProxyInfos()
+	// 来源去掉的节点，熔断也要保存
+	p.circuitBreaker.Trip("removed", "imdb", time.Hour)
+	// 只在 imdb 中被封禁，不需要 Close 也会保存
+	lease, err := p.Acquire(context.Background(), "imdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease.Banned(time.Hour)
+	bannedID := lease.ProxyInfo().ID
+
+	p2 := newTestPool()
+	err = p2.loadProxyNodeState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2.circuitBreaker.State(bannedID, "imdb") != CircuitOpen {
+		t.Fatal("key banned state lost", p2.circuitBreaker.State(bannedID, "imdb"))
+	}
+	if p2.circuitBreaker.State(bannedID, "other") != CircuitClosed {
+		t.Fatal("circuit should only open in imdb")
+	}
+	if p2.circuitBreaker.State("removed", "imdb") != CircuitOpen {
+		t.Fatal("removed node circuit lost")
+	}
+	for _, proxyInfo := range proxyInfos {
+		if proxyInfo.ID == bannedID {
+			continue
+		}
+		selected, err := p2.ForKey("imdb").GetOneProxyInfo()
+		if err != nil || selected.ID != proxyInfo.ID {
+			t.Fatal("banned node should be skipped after restart", err)
+		}
+	}
+
+	// 访问时间的变化会延迟保存，Close 的时候直接保存
+	p3 := newTestPool()
+	err = p3.loadProxyNodeState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	selected, err := p3.ForKey("imdb").GetOneProxyInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p3.nodeStateTimerLocker.Lock()
+	pending := p3.nodeStateTimer != nil
+	p3.nodeStateTimerLocker.Unlock()
+	if pending == false {
+		t.Fatal("access state should be saved later")
+	}
+	p3.stopDelaySaveProxyNodeState()
+	p3.saveProxyNodeState()
+	sc := NewProxyNodeStateCache()
+	err = store.Load(proxyNodeStateFileName, sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Nodes[selected.ID].LastAccessTime != selected.GetLastAccessTime() || len(sc.Nodes[bannedID].Circuits) != 1 {
+		t.Fatal("node state not saved", sc.Nodes)
+	}
+}
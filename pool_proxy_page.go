@@ -0,0 +1,113 @@
+package rod_helper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// ProxyPage 所有请求都通过租用的代理节点发出的页面，同一个浏览器中的多个 ProxyPage 可以使用不同的代理
+// 使用完毕后调用 Close，页面被关闭的时候租约也会归还
+type ProxyPage struct {
+	Page   *rod.Page
+	lease  *Lease
+	router *PageHijacker
+	cancel context.CancelFunc // 停止监听页面关闭
+	closed bool
+	locker sync.Mutex
+}
+
+// NewPageWithProxy 从这个 KeyName 租用一个节点，在 browserInfo 中打开一个所有请求都通过这个节点的页面，见 NewPageWithProxyContext
+func (b *Pool) NewPageWithProxy(browserInfo *BrowserInfo, keyName string) (*ProxyPage, error) {
+	return b.NewPageWithProxyContext(context.Background(), browserInfo, keyName)
+}
+
+// NewPageWithProxyContext keyName 为空的时候从全部的代理中租用，没有可用的节点会等待，直到 ctx 结束，见 Pool.Acquire
+// 请求的结果可以通过 ProxyPage.Lease 反馈，Close 的时候还没有反馈的租约直接归还
+func (b *Pool) NewPageWithProxyContext(ctx context.Context, browserInfo *BrowserInfo, keyName string) (*ProxyPage, error) {
+
+	lease, err := b.Acquire(ctx, keyName)
+	if err != nil {
+		return nil, err
+	}
+	hijackConfig := b.getHijackConfig(b.rodOptions.timeConfig.GetOnePageTimeOut())
+	page, err := NewPage(browserInfo.Browser)
+	if err != nil {
+		lease.Release()
+		return nil, err
+	}
+	router, err := NewPageHijacker(page, lease.ProxyInfo().ProxyUrl(), hijackConfig)
+	if err != nil {
+		_ = page.Close()
+		lease.Release()
+		return nil, err
+	}
+
+	proxyPage := &ProxyPage{Page: page, lease: lease, router: router}
+	go proxyPage.router.Run()
+	// 页面被关闭的时候归还租约
+	watchCtx, cancel := context.WithCancel(context.Background())
+	proxyPage.cancel = cancel
+	err = proto.TargetSetDiscoverTargets{Discover: true}.Call(browserInfo.Browser)
+	if err != nil {
+		b.log.Warningln("NewPageWithProxy TargetSetDiscoverTargets", err)
+	} else {
+		wait := browserInfo.Browser.Context(watchCtx).EachEvent(func(e *proto.TargetTargetDestroyed) bool {
+			return e.TargetID == page.TargetID
+		})
+		go func() {
+			wait()
+			if watchCtx.Err() == nil {
+				_ = proxyPage.Close()
+			}
+		}()
+	}
+	b.log.Infoln("NewPageWithProxy", lease.ProxyInfo().Name, lease.ProxyInfo().Index, keyName)
+	return proxyPage, nil
+}
+
+// Lease 这个页面租用的节点，可以通过 Success、Fail、Banned 反馈请求的结果，反馈后页面依然可以使用，只是不再占用这个节点
+func (p *ProxyPage) Lease() *Lease {
+	return p.lease
+}
+
+// ProxyInfo 这个页面使用的代理节点
+func (p *ProxyPage) ProxyInfo() *XrayPoolProxyInfo {
+	return p.lease.ProxyInfo()
+}
+
+// Close 关闭页面并归还租约，重复调用无影响
+func (p *ProxyPage) Close() error {
+
+	p.locker.Lock()
+	if p.closed == true {
+		p.locker.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.locker.Unlock()
+
+	p.cancel()
+	_ = p.router.Stop()
+	err := p.Page.Close()
+	p.lease.Release()
+	return err
+}
+
+// getHijackConfig PoolOptions 中的拦截设置，没有设置超时时间的时候使用 timeOut
+func (b *Pool) getHijackConfig(timeOut time.Duration) HijackConfig {
+
+	hijackConfig := b.rodOptions.HijackConfig()
+	if hijackConfig.Timeout <= 0 {
+		hijackConfig.Timeout = timeOut
+	}
+	if hijackConfig.Blocker == nil {
+		hijackConfig.Blocker = b.rodOptions.RequestBlocker()
+	}
+	return hijackConfig
+}
+
+const defProxyPageTimeOut = 60 * time.Second
@@ -0,0 +1,85 @@
+package rod_helper
+
+import (
+	"context"
+	"github.com/WQGroup/logger"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoolNewPageWithProxy(t *testing.T) {
+
+	InitFakeUA(true, "", "")
+	// 两个 http 代理返回不同的内容，用来区分页面走的是哪个代理
+	newProxy := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("<html><body>" + name + "</body></html>"))
+		}))
+	}
+	proxyA, proxyB := newProxy("proxy-a"), newProxy("proxy-b")
+	defer proxyA.Close()
+	defer proxyB.Close()
+
+	source, err := NewStaticProxySource("static", proxyA.URL, proxyB.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{OnePageTimeOut: 15})
+	opt.SetStateStore(NewMemoryStateStore())
+	proxyInfos, _ := source.Fetch(context.Background())
+	p := newEmptyPool(opt, source)
+	p.reconcileProxyInfos(proxyInfos)
+
+	browserInfo, err := p.NewBrowser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer browserInfo.Close()
+
+	// 同一个浏览器中的两个页面使用不同的代理
+	pageA, err := p.NewPageWithProxy(browserInfo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageB, err := p.NewPageWithProxy(browserInfo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pageA.ProxyInfo().ID == pageB.ProxyInfo().ID {
+		t.Fatal("pages should use different proxies")
+	}
+	for _, proxyPage := range []*ProxyPage{pageA, pageB} {
+		_, _, err = PageNavigate(proxyPage.Page, false, "http://page.test/", 15*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		html, err := proxyPage.Page.HTML()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantName := "proxy-a"
+		if proxyPage.ProxyInfo().HttpUrl == proxyB.URL {
+			wantName = "proxy-b"
+		}
+		if strings.Contains(html, wantName) == false {
+			t.Fatal("page not loaded through its proxy", wantName, html)
+		}
+	}
+
+	// 关闭页面后租约归还，这个节点可以再次租用
+	err = pageA.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lease, err := p.Acquire(ctx, "")
+	if err != nil || lease.ProxyInfo().ID != pageA.ProxyInfo().ID {
+		t.Fatal("lease should be released when page closed", err)
+	}
+	lease.Release()
+	_ = pageB.Close()
+}
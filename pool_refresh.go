@@ -0,0 +1,244 @@
+package rod_helper
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ProxyChangedEvent 刷新代理列表后，新增以及移除的节点
+type ProxyChangedEvent struct {
+	Added   []*XrayPoolProxyInfo // 新增的节点
+	Removed []*XrayPoolProxyInfo // 移除的节点
+}
+
+// HasChanged 是否有节点的变化
+func (e ProxyChangedEvent) HasChanged() bool {
+	return len(e.Added) > 0 || len(e.Removed) > 0
+}
+
+// AddProxyChangedListener 注册代理列表变化的监听，在刷新的协程中回调，不要在回调中阻塞
+func (b *Pool) AddProxyChangedListener(listener func(event ProxyChangedEvent)) {
+	b.refreshLocker.Lock()
+	defer b.refreshLocker.Unlock()
+	b.proxyChangedListeners = append(b.proxyChangedListeners, listener)
+}
+
+// StartProxyRefresh 启动后台刷新，按间隔重新从 ProxySource 获取代理列表，重复调用会先停止之前的刷新
+func (b *Pool) StartProxyRefresh(interval time.Duration) {
+
+	if interval <= 0 {
+		return
+	}
+	b.StopProxyRefresh()
+
+	b.refreshLocker.Lock()
+	defer b.refreshLocker.Unlock()
+	stop := make(chan struct{})
+	b.refreshStop = stop
+	b.refreshWg.Add(1)
+	// 支持主动通知变化的来源，不需要等到下一次的间隔
+	var changed <-chan struct{}
+	if watcher, ok := b.proxySource.(ProxySourceWatcher); ok == true {
+		changed = watcher.Changed()
+	}
+	go func() {
+		defer b.refreshWg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			case <-changed:
+			}
+			_, err := b.RefreshProxyInfos()
+			if err != nil {
+				b.log.Warningln("Pool.StartProxyRefresh", b.proxySource.Name(), err)
+			}
+		}
+	}()
+}
+
+// StopProxyRefresh 停止后台刷新，会等待正在进行的刷新结束
+func (b *Pool) StopProxyRefresh() {
+
+	b.refreshLocker.Lock()
+	if b.refreshStop != nil {
+		close(b.refreshStop)
+		b.refreshStop = nil
+	}
+	b.refreshLocker.Unlock()
+
+	b.refreshWg.Wait()
+}
+
+// RefreshProxyInfos 立即从 ProxySource 获取一次代理列表，并与当前的列表对齐
+func (b *Pool) RefreshProxyInfos() (ProxyChangedEvent, error) {
+
+	newProxyInfos, err := b.proxySource.Fetch(context.Background())
+	if err != nil {
+		// 获取失败的时候保留现有的列表，不要清空
+		return ProxyChangedEvent{}, err
+	}
+	if len(newProxyInfos) < 1 {
+		return ProxyChangedEvent{}, ErrProxyInfosIsEmpty
+	}
+
+	event := b.reconcileProxyInfos(newProxyInfos)
+	b.updateLoadBalance()
+
+	if event.HasChanged() == false {
+		return event, nil
+	}
+	b.log.Infoln("Pool.RefreshProxyInfos Added:", len(event.Added), "Removed:", len(event.Removed))
+	// 新增的节点可以分配给正在等待的调用者
+	b.httpProxyLocker.Lock()
+	b.notifyProxyReady()
+	b.httpProxyLocker.Unlock()
+
+	b.refreshLocker.Lock()
+	listeners := make([]func(event ProxyChangedEvent), len(b.proxyChangedListeners))
+	copy(listeners, b.proxyChangedListeners)
+	b.refreshLocker.Unlock()
+	for _, listener := range listeners {
+		listener(event)
+	}
+
+	return event, nil
+}
+
+// reconcileProxyInfos 用新的代理列表替换当前的列表，依然存在的节点保留其运行时的状态以及过滤结果
+func (b *Pool) reconcileProxyInfos(newProxyInfos []*XrayPoolProxyInfo) ProxyChangedEvent {
+
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	b.filterProxyLocker.Lock()
+	defer b.filterProxyLocker.Unlock()
+
+	event := ProxyChangedEvent{
+		Added:   make([]*XrayPoolProxyInfo, 0),
+		Removed: make([]*XrayPoolProxyInfo, 0),
+	}
+
+	oldProxyInfos := make(map[string]*XrayPoolProxyInfo)
+	for _, info := range b.orgProxyInfos {
+		oldProxyInfos[info.ID] = info
+	}
+
+	mergedProxyInfos := make([]*XrayPoolProxyInfo, 0, len(newProxyInfos))
+	proxyIDIndex := make(map[string]int)
+	for _, newInfo := range newProxyInfos {
+		if _, found := proxyIDIndex[newInfo.ID]; found == true {
+			// 重复的节点只保留第一个
+			continue
+		}
+		index := len(mergedProxyInfos)
+		oldInfo, found := oldProxyInfos[newInfo.ID]
+		if found == true {
+			delete(oldProxyInfos, newInfo.ID)
+			// 已经给出去的节点对象不能再修改，调用者会不加锁读取 Index、Name 等字段
+			// 没有变化的时候沿用之前的对象，否则新建一个对象，沿用 lastAccessTime、skipAccessTime 等状态
+			if oldInfo.Index == index && sameProxyNode(oldInfo, newInfo) == true {
+				newInfo = oldInfo
+			} else {
+				newInfo = renewProxyNode(oldInfo, newInfo, index)
+			}
+		} else {
+			if newInfo.Index != index {
+				newInfo.Index = index
+			}
+			b.restoreProxyNodeState(newInfo)
+			event.Added = append(event.Added, newInfo)
+		}
+		proxyIDIndex[newInfo.ID] = newInfo.Index
+		mergedProxyInfos = append(mergedProxyInfos, newInfo)
+	}
+	for _, info := range b.orgProxyInfos {
+		if _, found := oldProxyInfos[info.ID]; found == true {
+			event.Removed = append(event.Removed, info)
+			CloseHijackTransport(info.ProxyUrl())
+			// 来源之后可能恢复这个节点，状态先留着
+			if info.FirTimeAccess == false || info.skipAccessTime > time.Now().Unix() {
+				b.savedNodeStates[info.ID] = ProxyNodeState{
+					FirTimeAccess:  info.FirTimeAccess,
+					SkipAccessTime: info.skipAccessTime,
+					LastAccessTime: info.lastAccessTime,
+				}
+			}
+		}
+	}
+
+	b.orgProxyInfos = mergedProxyInfos
+	b.proxyIDIndex = proxyIDIndex
+
+	// 过滤结果中移除已经不存在的节点
+	for keyName, ids := range b.filterProxyIDList {
+		keepIDs := make([]string, 0, len(ids))
+		for _, id := range ids {
+			if _, found := proxyIDIndex[id]; found == true {
+				keepIDs = append(keepIDs, id)
+			} else {
+				delete(b.filterProxyTestTime[keyName], id)
+			}
+		}
+		b.filterProxyIDList[keyName] = keepIDs
+		if b.nowFilterProxyInfoIndex[keyName] >= len(keepIDs) {
+			b.nowFilterProxyInfoIndex[keyName] = 0
+		}
+	}
+
+	return event
+}
+
+// currentProxyInfo 这个节点在当前列表中的对象，刷新后可能已经换成了新的对象，不在列表中的时候返回传入的对象
+// 修改节点的运行时状态之前都需要通过这里获取，需要在 httpProxyLocker 中调用
+func (b *Pool) currentProxyInfo(proxyInfo *XrayPoolProxyInfo) *XrayPoolProxyInfo {
+	if index, found := b.proxyIDIndex[proxyInfo.ID]; found == true {
+		return b.orgProxyInfos[index]
+	}
+	return proxyInfo
+}
+
+// sameProxyNode 来源给出的信息是否有变化
+func sameProxyNode(oldInfo, newInfo *XrayPoolProxyInfo) bool {
+	return oldInfo.Name == newInfo.Name && oldInfo.ProtoModel == newInfo.ProtoModel &&
+		oldInfo.HttpUrl == newInfo.HttpUrl && oldInfo.SocksUrl == newInfo.SocksUrl
+}
+
+// renewProxyNode 以 newInfo 的信息新建一个节点对象，沿用 oldInfo 的运行时状态，需要在 httpProxyLocker 中调用
+func renewProxyNode(oldInfo, newInfo *XrayPoolProxyInfo, index int) *XrayPoolProxyInfo {
+
+	nodeInfo := &XrayPoolProxyInfo{
+		Index:          index,
+		ID:             newInfo.ID,
+		Name:           newInfo.Name,
+		ProtoModel:     newInfo.ProtoModel,
+		SocksUrl:       newInfo.SocksUrl,
+		HttpUrl:        newInfo.HttpUrl,
+		FirTimeAccess:  oldInfo.FirTimeAccess,
+		skipAccessTime: oldInfo.skipAccessTime,
+		lastAccessTime: oldInfo.lastAccessTime,
+		nextAccessTime: oldInfo.nextAccessTime,
+		leased:         oldInfo.leased,
+		stats:          oldInfo.stats,
+	}
+	nodeInfo.setExitIP(oldInfo.GetExitIP())
+	nodeInfo.setAnonymity(oldInfo.GetAnonymity())
+	return nodeInfo
+}
+
+// updateLoadBalance 来源是 XrayPool 的时候，更新负载均衡的端口
+func (b *Pool) updateLoadBalance() {
+
+	xraySource, ok := b.proxySource.(*XrayPoolSource)
+	if ok == false {
+		return
+	}
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	b.lbPort = xraySource.LBPort()
+	b.lbHttpUrl = fmt.Sprintf(httpPrefix + xraySource.XrayPoolUrl() + ":" + strconv.Itoa(b.lbPort))
+}
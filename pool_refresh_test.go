@@ -0,0 +1,138 @@
+package rod_helper
+
+import (
+	"context"
+	"errors"
+	"github.com/WQGroup/logger"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolReconcileProxyInfos(t *testing.T) {
+
+	newInfo := func(name string, port int) *XrayPoolProxyInfo {
+		return &XrayPoolProxyInfo{ID: NewProxyID(name, "vmess", port, port+1000), Name: name, FirTimeAccess: true}
+	}
+	a, b, c := newInfo("a", 1), newInfo("b", 2), newInfo("c", 3)
+	a.skipAccessTime, b.lastAccessTime = 100, 200
+
+	p := newEmptyPool(NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{}), nil)
+	p.reconcileProxyInfos([]*XrayPoolProxyInfo{a, b})
+	p.filterProxyIDList["imdb"] = []string{a.ID, b.ID}
+	p.nowFilterProxyInfoIndex["imdb"] = 1
+	// b 被移除，a 的顺序变化，c 是新增的
+	event := p.reconcileProxyInfos([]*XrayPoolProxyInfo{c, newInfo("a", 1)})
+	if len(event.Added) != 1 || event.Added[0].ID != c.ID || len(event.Removed) != 1 || event.Removed[0].ID != b.ID {
+		t.Fatal("reconcile event error", event)
+	}
+	// a 的位置变化了，已经给出去的对象不修改，新的对象沿用之前的状态
+	infos := p.GetProxyInfos()
+	if len(infos) != 2 || infos[1] == a || infos[1].ID != a.ID || infos[1].Index != 1 || infos[1].skipAccessTime != 100 || a.Index != 0 {
+		t.Fatal("reconcile state error")
+	}
+	filterInfos, err := p.GetFilterProxyInfos("imdb")
+	if err != nil || len(filterInfos) != 1 || filterInfos[0].ID != a.ID || p.nowFilterProxyInfoIndex["imdb"] != 0 {
+		t.Fatal("reconcile filter list error", filterInfos, err)
+	}
+}
+
+// reorderProxySource 每次获取的顺序都反过来
+type reorderProxySource struct {
+	proxyUrls  []string
+	fetchCount int32
+}
+
+func (r *reorderProxySource) Name() string {
+	return "reorder"
+}
+
+func (r *reorderProxySource) Fetch(ctx context.Context) ([]*XrayPoolProxyInfo, error) {
+	proxyUrls := make([]string, len(r.proxyUrls))
+	copy(proxyUrls, r.proxyUrls)
+	if atomic.AddInt32(&r.fetchCount, 1)%2 == 0 {
+		for i, j := 0, len(proxyUrls)-1; i < j; i, j = i+1, j-1 {
+			proxyUrls[i], proxyUrls[j] = proxyUrls[j], proxyUrls[i]
+		}
+	}
+	source, err := NewStaticProxySource("static", proxyUrls...)
+	if err != nil {
+		return nil, err
+	}
+	return source.Fetch(ctx)
+}
+
+func TestPoolRefreshReorder(t *testing.T) {
+
+	source := &reorderProxySource{proxyUrls: []string{"http://127.0.0.1:10809", "http://127.0.0.1:10810", "http://127.0.0.1:10811"}}
+	p := newEmptyPool(NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{}), source)
+	_, err := p.RefreshProxyInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 刷新的同时不加锁读取已经给出去的节点，-race 的时候不能有数据竞争
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				proxyInfo, err := p.GetOneProxyInfo()
+				if err != nil && errors.Is(err, ErrSkipAccessTime) == false {
+					t.Error(err)
+					return
+				}
+				_ = proxyInfo.Index
+				_ = proxyInfo.Name
+				_ = proxyInfo.ProxyUrl()
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		_, err = p.RefreshProxyInfos()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// 顺序变化后，通过之前拿到的节点封禁，封禁的还是这个节点
+	proxyInfo := p.GetProxyInfos()[0]
+	lease, err := p.Acquire(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.RefreshProxyInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.SetProxyNodeSkipByTime(proxyInfo.ID, time.Now().Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nowInfo := range p.GetProxyInfos() {
+		p.httpProxyLocker.Lock()
+		banned := nowInfo.skipAccessTime > time.Now().Unix()
+		p.httpProxyLocker.Unlock()
+		if banned != (nowInfo.ID == proxyInfo.ID) {
+			t.Fatal("SetProxyNodeSkipByTime banned the wrong node", nowInfo.Name)
+		}
+	}
+	// 租约归还的是当前列表中的节点
+	leaseID := lease.ProxyInfo().ID
+	lease.Success(time.Millisecond)
+	for _, nowInfo := range p.GetProxyInfos() {
+		if nowInfo.ID == leaseID && (p.GetProxyStats(nowInfo).SuccessCount != 1 || p.GetProxyStats(nowInfo).InFlight != 0) {
+			t.Fatal("lease should be returned to the current node")
+		}
+	}
+}
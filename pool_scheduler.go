@@ -0,0 +1,255 @@
+package rod_helper
+
+import (
+	"container/heap"
+	"context"
+	"time"
+)
+
+// WaitPriority GetProxyInfoSync 等待节点时的优先级，越大越先分配到节点，相同优先级先到先得
+type WaitPriority int
+
+const (
+	WaitPriorityLow    WaitPriority = -10
+	WaitPriorityNormal WaitPriority = 0
+	WaitPriorityUrgent WaitPriority = 10
+)
+
+// proxyScheduler 等待节点的调度信息，以 KeyName 为单位排队，需要在 httpProxyLocker 中使用
+type proxyScheduler struct {
+	queues map[string]*proxyWaitQueue // 每个 KeyName 的等待队列
+	timers map[string]*time.Timer     // 每个 KeyName 最近一个节点可以使用的时候唤醒调度
+	seq    uint64                     // 入队的顺序
+}
+
+func newProxyScheduler() *proxyScheduler {
+	return &proxyScheduler{
+		queues: make(map[string]*proxyWaitQueue),
+		timers: make(map[string]*time.Timer),
+	}
+}
+
+// proxyWaiter 一个等待节点的调用者
+type proxyWaiter struct {
+	keyName   string
+	priority  WaitPriority
+	seq       uint64
+	index     int                // 在等待队列中的位置，-1 表示已经出队
+	proxyInfo *XrayPoolProxyInfo // 分配到的节点
+	oldAccess proxyAccessState   // 分配之前节点的访问状态，取消的时候回滚
+	err       error
+	ready     chan struct{} // 分配到节点或者出错的时候关闭
+}
+
+// proxyAccessState 节点与访问频率相关的状态
+type proxyAccessState struct {
+	firTimeAccess  bool
+	lastAccessTime int64
+	nextAccessTime time.Time
+}
+
+// proxyWaitQueue 优先级高的在前，相同优先级先入队的在前
+type proxyWaitQueue []*proxyWaiter
+
+func (q proxyWaitQueue) Len() int {
+	return len(q)
+}
+
+func (q proxyWaitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q proxyWaitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *proxyWaitQueue) Push(x interface{}) {
+	waiter := x.(*proxyWaiter)
+	waiter.index = len(*q)
+	*q = append(*q, waiter)
+}
+
+func (q *proxyWaitQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	waiter := old[n-1]
+	old[n-1] = nil
+	waiter.index = -1
+	*q = old[:n-1]
+	return waiter
+}
+
+// waitProxyInfo 在 keyName 的等待队列中排队，直到分配到一个可以使用的节点，或者 ctx 结束
+func (b *Pool) waitProxyInfo(ctx context.Context, keyName string, priority WaitPriority) (*XrayPoolProxyInfo, error) {
+
+	b.httpProxyLocker.Lock()
+	// 没有节点的时候直接返回，不需要排队
+	_, err := b.leaseCandidates(keyName)
+	if err != nil {
+		b.httpProxyLocker.Unlock()
+		return nil, err
+	}
+	queue, found := b.scheduler.queues[keyName]
+	if found == false {
+		queue = &proxyWaitQueue{}
+		b.scheduler.queues[keyName] = queue
+	}
+	b.scheduler.seq++
+	waiter := &proxyWaiter{
+		keyName:  keyName,
+		priority: priority,
+		seq:      b.scheduler.seq,
+		ready:    make(chan struct{}),
+	}
+	heap.Push(queue, waiter)
+	b.dispatchProxyWaiters(keyName)
+	b.httpProxyLocker.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return waiter.proxyInfo, waiter.err
+	case <-ctx.Done():
+	}
+
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	if waiter.index >= 0 {
+		// 还在排队
+		heap.Remove(queue, waiter.index)
+	} else if waiter.proxyInfo != nil {
+		// 同时分配到了节点，归还给后面的等待者
+		proxyInfo := b.currentProxyInfo(waiter.proxyInfo)
+		proxyInfo.FirTimeAccess = waiter.oldAccess.firTimeAccess
+		proxyInfo.lastAccessTime = waiter.oldAccess.lastAccessTime
+		proxyInfo.nextAccessTime = waiter.oldAccess.nextAccessTime
+		b.dispatchProxyWaiters(keyName)
+	}
+	return nil, ctx.Err()
+}
+
+// dispatchProxyWaiters 把现在可以使用的节点按优先级分配给 keyName 的等待者，没有可用节点的时候在最近一个节点可用的时候再次调度
+// 需要在 httpProxyLocker 中调用
+func (b *Pool) dispatchProxyWaiters(keyName string) {
+
+	queue, found := b.scheduler.queues[keyName]
+	if found == false || queue.Len() < 1 {
+		b.stopSchedulerTimer(keyName)
+		return
+	}
+
+	candidates, err := b.leaseCandidates(keyName)
+	if err != nil {
+		// 节点都没有了，不需要再等待
+		for queue.Len() > 0 {
+			waiter := heap.Pop(queue).(*proxyWaiter)
+			waiter.err = err
+			close(waiter.ready)
+		}
+		b.stopSchedulerTimer(keyName)
+		return
+	}
+
+	now := time.Now()
+	for queue.Len() > 0 {
+		proxyInfo, waitTime := b.pickReadyProxy(keyName, candidates, now)
+		if proxyInfo == nil {
+			b.resetSchedulerTimer(keyName, waitTime)
+			return
+		}
+		waiter := heap.Pop(queue).(*proxyWaiter)
+		waiter.oldAccess = proxyAccessState{
+			firTimeAccess:  proxyInfo.FirTimeAccess,
+			lastAccessTime: proxyInfo.lastAccessTime,
+			nextAccessTime: proxyInfo.nextAccessTime,
+		}
+		// 分配的时候就决定这个节点下一次可以使用的时间，调用者不需要再持有节点 sleep
+		proxyInfo.FirTimeAccess = false
+		proxyInfo.lastAccessTime = now.Unix()
+		proxyInfo.nextAccessTime = now.Add(b.rodOptions.timeConfig.GetOneProxyNodeUseInternalTime(0))
+		b.delaySaveProxyNodeState()
+		waiter.proxyInfo = proxyInfo
+		close(waiter.ready)
+	}
+	b.stopSchedulerTimer(keyName)
+}
+
+// dispatchAllProxyWaiters 节点的状态变化了，重新调度所有的等待队列，需要在 httpProxyLocker 中调用
+func (b *Pool) dispatchAllProxyWaiters() {
+	for keyName := range b.scheduler.queues {
+		b.dispatchProxyWaiters(keyName)
+	}
+}
+
+// pickReadyProxy 从 candidates 中选择一个现在可以使用的节点，没有的时候返回最近一个节点可用需要等待的时间
+// 需要在 httpProxyLocker 中调用
+func (b *Pool) pickReadyProxy(keyName string, candidates []*XrayPoolProxyInfo, now time.Time) (*XrayPoolProxyInfo, time.Duration) {
+
+	waitTime := leaseMaxWaitTime
+	eligible := make([]*XrayPoolProxyInfo, 0, len(candidates))
+	for _, proxyInfo := range candidates {
+		if proxyInfo.leased == true {
+			continue
+		}
+		readyTime := b.proxyReadyTime(proxyInfo)
+		if readyTime.After(now) == true {
+			if readyTime.Sub(now) < waitTime {
+				waitTime = readyTime.Sub(now)
+			}
+			continue
+		}
+		allowed, retryTime := b.proxyAllowedByCircuit(proxyInfo, keyName)
+		if allowed == false {
+			if retryTime.IsZero() == false && retryTime.Sub(now) < waitTime {
+				waitTime = retryTime.Sub(now)
+			}
+			continue
+		}
+		eligible = append(eligible, proxyInfo)
+	}
+	if len(eligible) < 1 {
+		return nil, waitTime
+	}
+	return b.selectProxy(keyName, eligible), 0
+}
+
+// proxyReadyTime 这个节点可以再次使用的时间，综合了封禁的时间以及 TimeConfig 中的使用间隔
+func (b *Pool) proxyReadyTime(proxyInfo *XrayPoolProxyInfo) time.Time {
+
+	readyTime := time.Unix(proxyInfo.skipAccessTime, 0)
+	if proxyInfo.FirTimeAccess == true {
+		return readyTime
+	}
+	minIntervalTime := time.Unix(proxyInfo.lastAccessTime+int64(b.rodOptions.timeConfig.OneProxyNodeUseInternalMinTime), 0)
+	if minIntervalTime.After(readyTime) == true {
+		readyTime = minIntervalTime
+	}
+	if proxyInfo.nextAccessTime.After(readyTime) == true {
+		readyTime = proxyInfo.nextAccessTime
+	}
+	return readyTime
+}
+
+func (b *Pool) resetSchedulerTimer(keyName string, waitTime time.Duration) {
+
+	b.stopSchedulerTimer(keyName)
+	b.scheduler.timers[keyName] = time.AfterFunc(waitTime, func() {
+		b.httpProxyLocker.Lock()
+		defer b.httpProxyLocker.Unlock()
+		b.dispatchProxyWaiters(keyName)
+	})
+}
+
+func (b *Pool) stopSchedulerTimer(keyName string) {
+
+	timer, found := b.scheduler.timers[keyName]
+	if found == false {
+		return
+	}
+	timer.Stop()
+	delete(b.scheduler.timers, keyName)
+}
@@ -0,0 +1,134 @@
+package rod_helper
+
+import (
+	"context"
+	"errors"
+	"github.com/WQGroup/logger"
+	"testing"
+	"time"
+)
+
+func TestPoolWaitProxyInfo(t *testing.T) {
+
+	source, err := NewStaticProxySource("static", "http://127.0.0.1:10809")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	timeConfig := TimeConfig{OneProxyNodeUseInternalMinTime: 60, OneProxyNodeUseInternalMaxTime: 60}
+	p := newEmptyPool(NewPoolOptions(logger.GetLogger(), false, false, timeConfig), source)
+	p.reconcileProxyInfos(proxyInfos)
+	// 节点暂时不可用，调用者需要排队
+	p.httpProxyLocker.Lock()
+	proxyInfos[0].skipAccessTime = time.Now().Unix() + 100
+	p.httpProxyLocker.Unlock()
+
+	queueLen := func() int {
+		p.httpProxyLocker.Lock()
+		defer p.httpProxyLocker.Unlock()
+		if queue, found := p.scheduler.queues[""]; found == true {
+			return queue.Len()
+		}
+		return 0
+	}
+	type result struct {
+		proxyInfo *XrayPoolProxyInfo
+		err       error
+	}
+	lowCtx, lowCancel := context.WithCancel(context.Background())
+	lowResult := make(chan result, 1)
+	go func() {
+		proxyInfo, err := p.ForKey("").GetProxyInfoSyncWithPriority(lowCtx, "low", WaitPriorityLow)
+		lowResult <- result{proxyInfo, err}
+	}()
+	for queueLen() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	urgentResult := make(chan result, 1)
+	go func() {
+		proxyInfo, err := p.ForKey("").GetProxyInfoSyncWithPriority(context.Background(), "urgent", WaitPriorityUrgent)
+		urgentResult <- result{proxyInfo, err}
+	}()
+	for queueLen() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 节点恢复，优先级高的先分配到，之后节点进入使用间隔
+	p.httpProxyLocker.Lock()
+	proxyInfos[0].skipAccessTime = 0
+	p.notifyProxyReady()
+	p.httpProxyLocker.Unlock()
+	nowResult := <-urgentResult
+	if nowResult.err != nil || nowResult.proxyInfo != proxyInfos[0] {
+		t.Fatal("urgent waiter should get the proxy", nowResult.err)
+	}
+	select {
+	case <-lowResult:
+		t.Fatal("low waiter should still wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	lowCancel()
+	nowResult = <-lowResult
+	if errors.Is(nowResult.err, context.Canceled) == false {
+		t.Fatal("low waiter should be canceled", nowResult.err)
+	}
+	if queueLen() != 0 {
+		t.Fatal("canceled waiter still in queue")
+	}
+}
+
+func TestPoolCloseWakesWaiters(t *testing.T) {
+
+	source, err := NewStaticProxySource("static", "http://127.0.0.1:10809")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	options := NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{})
+	options.SetCacheRootDirPath(t.TempDir())
+	p := newEmptyPool(options, source)
+	p.reconcileProxyInfos(proxyInfos)
+	// 节点暂时不可用，调用者需要排队
+	p.httpProxyLocker.Lock()
+	proxyInfos[0].skipAccessTime = time.Now().Unix() + 100
+	p.httpProxyLocker.Unlock()
+
+	syncResult := make(chan error, 1)
+	go func() {
+		_, err := p.GetProxyInfoSync("no ctx")
+		syncResult <- err
+	}()
+	acquireResult := make(chan error, 1)
+	go func() {
+		_, err := p.Acquire(context.Background(), "")
+		acquireResult <- err
+	}()
+	for {
+		p.httpProxyLocker.Lock()
+		queue, found := p.scheduler.queues[""]
+		queued := found == true && queue.Len() > 0
+		p.httpProxyLocker.Unlock()
+		if queued == true {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	p.Close()
+	for _, result := range []chan error{syncResult, acquireResult} {
+		select {
+		case err = <-result:
+			if errors.Is(err, ErrPoolClosed) == false {
+				t.Fatal("waiter should fail with ErrPoolClosed", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("waiter is not woken after Close")
+		}
+	}
+	// Close 之后不再排队
+	_, err = p.GetProxyInfoSync("after close")
+	if errors.Is(err, ErrPoolClosed) == false {
+		t.Fatal("GetProxyInfoSync after Close should fail", err)
+	}
+}
@@ -0,0 +1,59 @@
+package rod_helper
+
+import "time"
+
+// SetSelectionStrategy 设置这个 KeyName 选择节点的策略，keyName 为空对应全部的代理列表
+func (b *Pool) SetSelectionStrategy(keyName string, strategy SelectionStrategy) {
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	b.selectionStrategies[keyName] = strategy
+}
+
+// SetDefaultSelectionStrategy 设置没有单独指定策略的 KeyName 使用的策略，默认是轮询
+func (b *Pool) SetDefaultSelectionStrategy(strategy SelectionStrategy) {
+	b.httpProxyLocker.Lock()
+	defer b.httpProxyLocker.Unlock()
+	b.defaultStrategy = strategy
+}
+
+// GetProxyHealth 获取这个节点在 keyName 下的健康信息
+func (b *Pool) GetProxyHealth(proxyInfo *XrayPoolProxyInfo, keyName string) ProxyHealth {
+	return b.healthTracker.Get(proxyInfo.ID, keyName)
+}
+
+// selectProxy 使用 keyName 的策略从可用的节点中选择一个，需要在 httpProxyLocker 中调用
+func (b *Pool) selectProxy(keyName string, eligible []*XrayPoolProxyInfo) *XrayPoolProxyInfo {
+
+	strategy, found := b.selectionStrategies[keyName]
+	if found == false {
+		strategy = b.defaultStrategy
+	}
+	// 避免连续给出同一个出口的节点
+	eligible = b.avoidLastExit(keyName, eligible)
+	selected := strategy.Select(keyName, eligible, func(proxyInfo *XrayPoolProxyInfo) ProxyHealth {
+		return b.healthTracker.Get(proxyInfo.ID, keyName)
+	})
+	if selected < 0 || selected >= len(eligible) {
+		// 策略给出了错误的结果，那么就用第一个
+		b.log.Warningln("Pool.selectProxy", strategy.Name(), "out of range", selected)
+		selected = 0
+	}
+	b.recordLastExit(keyName, eligible[selected])
+	return eligible[selected]
+}
+
+// recordProxySuccess 记录节点在 keyName 下的一次成功，更新健康分数以及熔断器
+func (b *Pool) recordProxySuccess(proxyInfo *XrayPoolProxyInfo, keyName string, latency time.Duration) {
+	b.healthTracker.RecordSuccess(proxyInfo.ID, keyName, latency)
+	if keyName != "" {
+		b.circuitBreaker.RecordSuccess(proxyInfo.ID, keyName)
+	}
+}
+
+// recordProxyFailure 记录节点在 keyName 下的一次失败，更新健康分数以及熔断器
+func (b *Pool) recordProxyFailure(proxyInfo *XrayPoolProxyInfo, keyName string) {
+	b.healthTracker.RecordFailure(proxyInfo.ID, keyName)
+	if keyName != "" && b.circuitBreaker.RecordFailure(proxyInfo.ID, keyName) == CircuitOpen {
+		b.delaySaveProxyNodeState()
+	}
+}
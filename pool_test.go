@@ -1,8 +1,13 @@
 package rod_helper
 
 import (
+	"context"
+	"errors"
 	"github.com/WQGroup/logger"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestNewMultiBrowser(t *testing.T) {
@@ -26,10 +31,11 @@ func TestNewMultiBrowser(t *testing.T) {
 			},
 		},
 	})
-	err := b.Filter(fInfo, 2, WebPageWithHttpClient)
+	report, err := b.Filter(fInfo, 2, WebPageWithHttpClient)
 	if err != nil {
 		t.Fatal(err)
 	}
+	println("Passed:", report.Stats.Passed, "Total:", report.Stats.Total)
 	err = b.SetKeyName("imdb")
 	if err != nil {
 		t.Fatal(err)
@@ -39,6 +45,83 @@ func TestNewMultiBrowser(t *testing.T) {
 		t.Fatal(err)
 	}
 	for _, proxy := range proxyInfos {
-		println(b.GetProxyInfos()[proxy].Index, b.GetProxyInfos()[proxy].Name)
+		println(proxy.Index, proxy.ID, proxy.Name)
+	}
+}
+
+func TestGetProxyInfoSyncWithContext(t *testing.T) {
+
+	source, err := NewStaticProxySource("static", "http://127.0.0.1:10809")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	p := newEmptyPool(NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{}), source)
+	p.reconcileProxyInfos(proxyInfos)
+	// 没有空闲的节点
+	p.httpProxyLocker.Lock()
+	proxyInfos[0].skipAccessTime = time.Now().Unix() + 100
+	p.httpProxyLocker.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	proxyInfo, err := p.GetProxyInfoSyncWithContext(ctx, "canceled")
+	if errors.Is(err, context.Canceled) == false || proxyInfo != nil {
+		t.Fatal("canceled ctx should return ctx.Err()", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("canceled ctx should return right away", time.Since(start))
+	}
+	p.httpProxyLocker.Lock()
+	queueLen := 0
+	if queue, found := p.scheduler.queues[""]; found == true {
+		queueLen = queue.Len()
+	}
+	p.httpProxyLocker.Unlock()
+	if queueLen != 0 {
+		t.Fatal("canceled waiter still in queue", queueLen)
+	}
+
+	// 等待中超时
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer timeoutCancel()
+	_, err = p.GetProxyInfoSyncWithContext(timeoutCtx, "timeout")
+	if errors.Is(err, context.DeadlineExceeded) == false {
+		t.Fatal("timeout ctx should return ctx.Err()", err)
+	}
+}
+
+func TestTryLoadUrlWithContext(t *testing.T) {
+
+	InitFakeUA(true, "", "")
+	// 作为代理，一直不返回，直到请求被中断
+	release := make(chan struct{})
+	defer close(release)
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer proxyServer.Close()
+
+	source, err := NewStaticProxySource("static", proxyServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	p := newEmptyPool(NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{}), source)
+	p.reconcileProxyInfos(proxyInfos)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = p.TryLoadUrlWithContext(ctx, proxyInfos[0], PageInfo{Name: "slow", Url: "http://slow.test/", PageTimeOut: 30})
+	if errors.Is(err, context.DeadlineExceeded) == false {
+		t.Fatal("TryLoadUrlWithContext should return ctx.Err()", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("TryLoadUrlWithContext should be interrupted by ctx", time.Since(start))
 	}
 }
@@ -0,0 +1,251 @@
+package rod_helper
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WQGroup/logger"
+	"github.com/panjf2000/ants/v2"
+	"github.com/pkg/errors"
+)
+
+// AnonymityLevel 代理节点的匿名程度
+type AnonymityLevel int
+
+const (
+	AnonymityUnknown     AnonymityLevel = iota // 还没有检查过
+	AnonymityTransparent                       // 透明代理，目标网站可以看到真实的 IP
+	AnonymityAnonymous                         // 匿名代理，不会泄露真实的 IP，但是可以看出使用了代理
+	AnonymityElite                             // 高匿代理，看不出使用了代理
+)
+
+func (a AnonymityLevel) String() string {
+	switch a {
+	case AnonymityTransparent:
+		return "Transparent"
+	case AnonymityAnonymous:
+		return "Anonymous"
+	case AnonymityElite:
+		return "Elite"
+	default:
+		return "Unknown"
+	}
+}
+
+// ProxyEcho 回显服务返回的请求信息
+type ProxyEcho struct {
+	RemoteIP string              `json:"remote_ip"` // 连接到回显服务的 IP
+	Headers  map[string][]string `json:"headers"`   // 收到的请求头
+}
+
+// NewProxyEchoHandler 回显请求信息的 http.Handler，可以部署在公网上作为 AuditProxy 的回显服务
+// 需要使用 http 而不是 https，否则代理无法修改请求头，也就检查不出来
+func NewProxyEchoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			remoteIP = r.RemoteAddr
+		}
+		echo := ProxyEcho{RemoteIP: remoteIP, Headers: r.Header}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(echo)
+	})
+}
+
+// ProxyAuditResult 一个节点的匿名检查结果
+type ProxyAuditResult struct {
+	Level         AnonymityLevel
+	RemoteIP      string   // 回显服务看到的 IP
+	LeakedHeaders []string // 暴露了使用代理或者真实 IP 的请求头
+}
+
+// AuditProxy 通过这个节点访问回显服务 echoUrl，检查是否添加了 Via、X-Forwarded-For 等请求头，以及是否泄露了 realIP
+// realIP 为空的时候，不使用代理访问一次回显服务来获取真实的 IP。结果会记录在节点上，见 XrayPoolProxyInfo.GetAnonymity
+func (b *Pool) AuditProxy(ctx context.Context, proxyInfo *XrayPoolProxyInfo, echoUrl, realIP string) (*ProxyAuditResult, error) {
+
+	var err error
+	if realIP == "" {
+		realIP, err = b.getRealIP(ctx, echoUrl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opt := NewHttpClientOptions(auditTimeOut)
+	opt.SetProxyInfo(proxyInfo)
+	echo, err := requestProxyEcho(ctx, opt, echoUrl)
+	if err != nil {
+		return nil, err
+	}
+	result := classifyProxyEcho(echo, realIP)
+
+	b.httpProxyLocker.Lock()
+	b.currentProxyInfo(proxyInfo).setAnonymity(result.Level)
+	b.httpProxyLocker.Unlock()
+	return result, nil
+}
+
+// AuditProxies 检查全部节点的匿名程度，检查失败的节点不在结果中
+func (b *Pool) AuditProxies(ctx context.Context, echoUrl string, threadSize int) (map[string]*ProxyAuditResult, error) {
+	return b.auditProxies(ctx, b.GetProxyInfos(), echoUrl, "", threadSize)
+}
+
+// auditProxies realIP 为空的时候只获取一次真实的 IP，所有节点共用
+func (b *Pool) auditProxies(ctx context.Context, proxyInfos []*XrayPoolProxyInfo, echoUrl, realIP string, threadSize int) (map[string]*ProxyAuditResult, error) {
+
+	var err error
+	if realIP == "" {
+		realIP, err = b.getRealIP(ctx, echoUrl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var locker sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]*ProxyAuditResult)
+	p, err := ants.NewPool(threadSize)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Release()
+	for _, proxyInfo := range proxyInfos {
+		if ctx.Err() != nil {
+			break
+		}
+		nowProxyInfo := proxyInfo
+		wg.Add(1)
+		err = p.Submit(func() {
+			defer wg.Done()
+			result, err := b.AuditProxy(ctx, nowProxyInfo, echoUrl, realIP)
+			if err != nil {
+				logger.Warningln("Pool.AuditProxy", nowProxyInfo.Name, nowProxyInfo.Index, err)
+				return
+			}
+			locker.Lock()
+			results[nowProxyInfo.ID] = result
+			locker.Unlock()
+		})
+		if err != nil {
+			wg.Done()
+			wg.Wait()
+			return nil, err
+		}
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return results, nil
+}
+
+// filterAnonymity Filter 的时候检查 proxyInfos 的匿名程度，淘汰低于 FilterInfo.MinAnonymity 的节点，检查失败的节点视为 AnonymityUnknown
+func (b *Pool) filterAnonymity(ctx context.Context, fInfo *FilterInfo, proxyInfos []*XrayPoolProxyInfo,
+	proxyResults map[string]*ProxyFilterResult, threadSize int) ([]*XrayPoolProxyInfo, error) {
+
+	auditResults, err := b.auditProxies(ctx, proxyInfos, fInfo.AnonymityEchoUrl, fInfo.AnonymityRealIP, threadSize)
+	if err != nil {
+		if ctx.Err() != nil {
+			// 取消了，结果不会被使用
+			return proxyInfos, nil
+		}
+		return nil, err
+	}
+	survivors := make([]*XrayPoolProxyInfo, 0, len(proxyInfos))
+	for _, proxyInfo := range proxyInfos {
+		proxyResult := proxyResults[proxyInfo.ID]
+		level := AnonymityUnknown
+		if auditResult, found := auditResults[proxyInfo.ID]; found == true {
+			level = auditResult.Level
+		}
+		proxyResult.Anonymity = level.String()
+		if level < fInfo.MinAnonymity {
+			proxyResult.Passed = false
+			proxyResult.FailureCategory = FailureAnonymity
+			continue
+		}
+		survivors = append(survivors, proxyInfo)
+	}
+	return survivors, nil
+}
+
+// getRealIP 不使用代理访问回显服务，获取真实的 IP
+func (b *Pool) getRealIP(ctx context.Context, echoUrl string) (string, error) {
+
+	echo, err := requestProxyEcho(ctx, NewHttpClientOptions(auditTimeOut), echoUrl)
+	if err != nil {
+		return "", errors.New("get real ip failed: " + err.Error())
+	}
+	return echo.RemoteIP, nil
+}
+
+func requestProxyEcho(ctx context.Context, opt *HttpClientOptions, echoUrl string) (*ProxyEcho, error) {
+
+	client, err := NewHttpClient(opt)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.R().SetContext(ctx).Get(echoUrl)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode() != http.StatusOK {
+		return nil, errors.Errorf("echo endpoint StatusCode: %d", res.StatusCode())
+	}
+	// 代理可能会改掉 Content-Type，直接解析
+	echo := &ProxyEcho{}
+	err = json.Unmarshal(res.Body(), echo)
+	if err != nil {
+		return nil, errors.New("parse echo response failed: " + err.Error())
+	}
+	return echo, nil
+}
+
+// classifyProxyEcho 回显中出现了真实的 IP 是透明代理，出现了代理相关的请求头是匿名代理，否则是高匿代理
+func classifyProxyEcho(echo *ProxyEcho, realIP string) *ProxyAuditResult {
+
+	result := &ProxyAuditResult{Level: AnonymityElite, RemoteIP: echo.RemoteIP, LeakedHeaders: make([]string, 0)}
+	leakedRealIP := realIP != "" && echo.RemoteIP == realIP
+	for name, values := range echo.Headers {
+		nowValue := strings.Join(values, ",")
+		if realIP != "" && strings.Contains(nowValue, realIP) == true {
+			leakedRealIP = true
+			result.LeakedHeaders = append(result.LeakedHeaders, http.CanonicalHeaderKey(name))
+			continue
+		}
+		for _, proxyHeader := range proxyRevealingHeaders {
+			if strings.EqualFold(name, proxyHeader) == true {
+				result.LeakedHeaders = append(result.LeakedHeaders, http.CanonicalHeaderKey(name))
+				break
+			}
+		}
+	}
+	if leakedRealIP == true {
+		result.Level = AnonymityTransparent
+	} else if len(result.LeakedHeaders) > 0 {
+		result.Level = AnonymityAnonymous
+	}
+	return result
+}
+
+// proxyRevealingHeaders 会暴露使用了代理的请求头
+var proxyRevealingHeaders = []string{
+	"Via",
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+	"X-Proxy-Id",
+	"X-Client-Ip",
+	"Client-Ip",
+	"Proxy-Connection",
+	"Proxy-Authorization",
+}
+
+const auditTimeOut = 15 * time.Second
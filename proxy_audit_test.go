@@ -0,0 +1,93 @@
+package rod_helper
+
+import (
+	"context"
+	"github.com/WQGroup/logger"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPoolAuditProxy(t *testing.T) {
+
+	InitFakeUA(true, "", "")
+	echoServer := httptest.NewServer(NewProxyEchoHandler())
+	defer echoServer.Close()
+	// 转发到回显服务的 http 代理，会额外加上 addHeaders，不论请求的是哪个地址都转发到回显服务
+	echoUrl := "http://echo.test/"
+	newForwardProxy := func(addHeaders map[string]string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, err := http.NewRequest(r.Method, echoServer.URL+r.URL.Path, nil)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			req.Header = r.Header.Clone()
+			for name, value := range addHeaders {
+				req.Header.Set(name, value)
+			}
+			res, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer res.Body.Close()
+			w.WriteHeader(res.StatusCode)
+			_, _ = io.Copy(w, res.Body)
+		}))
+	}
+	// 本地测试的时候连接的 IP 都是 127.0.0.1，使用一个假的真实 IP
+	realIP := "10.9.8.7"
+	eliteProxy := newForwardProxy(nil)
+	anonymousProxy := newForwardProxy(map[string]string{"Via": "1.1 squid"})
+	transparentProxy := newForwardProxy(map[string]string{"X-Forwarded-For": realIP})
+	defer eliteProxy.Close()
+	defer anonymousProxy.Close()
+	defer transparentProxy.Close()
+
+	source, err := NewStaticProxySource("static", eliteProxy.URL, anonymousProxy.URL, transparentProxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyInfos, _ := source.Fetch(context.Background())
+	p := newEmptyPool(NewPoolOptions(logger.GetLogger(), false, false, TimeConfig{}), source)
+	p.reconcileProxyInfos(proxyInfos)
+
+	wantLevels := []AnonymityLevel{AnonymityElite, AnonymityAnonymous, AnonymityTransparent}
+	for i, proxyInfo := range proxyInfos {
+		result, err := p.AuditProxy(context.Background(), proxyInfo, echoUrl, realIP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Level != wantLevels[i] || proxyInfo.GetAnonymity() != wantLevels[i] {
+			t.Fatal("audit proxy level error", proxyInfo.Name, result.Level, result.LeakedHeaders)
+		}
+	}
+
+	// 作为过滤条件，透明代理会被淘汰
+	fInfo := &FilterInfo{KeyName: "audit", MinAnonymity: AnonymityAnonymous, AnonymityEchoUrl: echoUrl, AnonymityRealIP: realIP}
+	proxyResults := make(map[string]*ProxyFilterResult)
+	for _, proxyInfo := range proxyInfos {
+		proxyResults[proxyInfo.ID] = &ProxyFilterResult{ProxyID: proxyInfo.ID, Passed: true}
+	}
+	// 检查的同时读取匿名程度，-race 的时候不能有数据竞争
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for i := 0; i < 100; i++ {
+			for _, proxyInfo := range proxyInfos {
+				_ = proxyInfo.GetAnonymity()
+			}
+		}
+	}()
+	survivors, err := p.filterAnonymity(context.Background(), fInfo, proxyInfos, proxyResults, 2)
+	<-readDone
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(survivors) != 2 || proxyResults[proxyInfos[2].ID].FailureCategory != FailureAnonymity ||
+		proxyResults[proxyInfos[0].ID].Anonymity != AnonymityElite.String() {
+		t.Fatal("filter anonymity error", len(survivors))
+	}
+}
@@ -0,0 +1,42 @@
+package rod_helper
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+)
+
+// NewProxyID 根据节点的名称、协议以及端口生成稳定的标识，XrayPool 调整节点顺序、增删节点都不会影响其他节点的标识
+func NewProxyID(name, protoModel string, httpPort, socksPort int) string {
+
+	fingerprint := fmt.Sprintf("%s|%s|%d|%d", name, protoModel, httpPort, socksPort)
+	sum := sha1.Sum([]byte(fingerprint))
+	return hex.EncodeToString(sum[:])[:proxyIDLength]
+}
+
+// reconcileProxyCache 将本地缓存的过滤结果与当前的代理列表对齐，返回依然存在的节点标识
+// 旧版本的缓存只记录了位置索引，无法确认是否还是同一个节点，所以转换后 needRefilter 为 true，需要重新过滤
+func reconcileProxyCache(pc *ProxyCache, proxyIDIndex map[string]int, orgProxyInfos []*XrayPoolProxyInfo) (ids []string, needRefilter bool) {
+
+	ids = make([]string, 0)
+	if len(pc.FilterProxyIDList) > 0 || len(pc.FilterProxyInfoIndexList) == 0 {
+		// 新版本的缓存，丢弃已经不存在的节点
+		for _, id := range pc.FilterProxyIDList {
+			if _, found := proxyIDIndex[id]; found == false {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		return ids, false
+	}
+	// 旧版本的缓存，只能按位置索引转换，越界的丢弃
+	for _, index := range pc.FilterProxyInfoIndexList {
+		if index < 0 || index >= len(orgProxyInfos) {
+			continue
+		}
+		ids = append(ids, orgProxyInfos[index].ID)
+	}
+	return ids, true
+}
+
+const proxyIDLength = 16
@@ -0,0 +1,26 @@
+package rod_helper
+
+import "testing"
+
+func TestReconcileProxyCache(t *testing.T) {
+
+	orgProxyInfos := []*XrayPoolProxyInfo{
+		{Index: 0, ID: NewProxyID("a", "vmess", 1001, 2001)},
+		{Index: 1, ID: NewProxyID("b", "vmess", 1002, 2002)},
+	}
+	proxyIDIndex := map[string]int{orgProxyInfos[0].ID: 0, orgProxyInfos[1].ID: 1}
+
+	pc := NewProxyCache()
+	pc.FilterProxyIDList = []string{orgProxyInfos[1].ID, NewProxyID("gone", "vmess", 1003, 2003)}
+	ids, needRefilter := reconcileProxyCache(pc, proxyIDIndex, orgProxyInfos)
+	if needRefilter == true || len(ids) != 1 || ids[0] != orgProxyInfos[1].ID {
+		t.Fatal("reconcile id cache failed", ids, needRefilter)
+	}
+
+	legacy := NewProxyCache()
+	legacy.FilterProxyInfoIndexList = []int{0, 5}
+	ids, needRefilter = reconcileProxyCache(legacy, proxyIDIndex, orgProxyInfos)
+	if needRefilter == false || len(ids) != 1 || ids[0] != orgProxyInfos[0].ID {
+		t.Fatal("reconcile legacy cache failed", ids, needRefilter)
+	}
+}
@@ -0,0 +1,323 @@
+package rod_helper
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/WQGroup/logger"
+	"github.com/go-resty/resty/v2"
+	"github.com/pkg/errors"
+)
+
+// ProxySource 代理节点的来源，Pool 通过它获取以及刷新代理列表
+type ProxySource interface {
+	// Name 来源的名称，用于日志
+	Name() string
+	// Fetch 获取当前全部的代理节点，每个节点的 ID 需要稳定
+	Fetch(ctx context.Context) ([]*XrayPoolProxyInfo, error)
+}
+
+// ProxySourceWatcher 可以主动通知代理列表变化的来源，Pool 的后台刷新收到通知后会立即刷新
+type ProxySourceWatcher interface {
+	Changed() <-chan struct{}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// XrayPoolSource 从 XrayPool 的 /v1/proxy_list 获取代理节点
+type XrayPoolSource struct {
+	xrayPoolUrl  string
+	xrayPoolPort string
+	lbPort       int
+	locker       sync.Mutex
+}
+
+// NewXrayPoolSource xrayPoolUrl 127.0.0.1，xrayPoolPort 19038
+func NewXrayPoolSource(xrayPoolUrl, xrayPoolPort string) *XrayPoolSource {
+	return &XrayPoolSource{xrayPoolUrl: xrayPoolUrl, xrayPoolPort: xrayPoolPort}
+}
+
+func (x *XrayPoolSource) Name() string {
+	return "XrayPool " + x.xrayPoolUrl + ":" + x.xrayPoolPort
+}
+
+func (x *XrayPoolSource) XrayPoolUrl() string {
+	return x.xrayPoolUrl
+}
+
+// LBPort 最近一次 Fetch 获取到的负载均衡 http 端口
+func (x *XrayPoolSource) LBPort() int {
+	x.locker.Lock()
+	defer x.locker.Unlock()
+	return x.lbPort
+}
+
+func (x *XrayPoolSource) Fetch(ctx context.Context) ([]*XrayPoolProxyInfo, error) {
+
+	// 尝试从本地的 XrayPoolUrl 获取 代理信息
+	httpClient := resty.New().SetTransport(&http.Transport{
+		DisableKeepAlives:   true,
+		MaxIdleConns:        1000,
+		MaxIdleConnsPerHost: 1000,
+	})
+
+	var proxyResult ProxyResult
+	_, err := httpClient.R().
+		SetContext(ctx).
+		SetResult(&proxyResult).
+		Get(httpPrefix +
+			x.xrayPoolUrl +
+			":" +
+			x.xrayPoolPort +
+			"/v1/proxy_list")
+	if err != nil {
+		return nil, errors.New("Get error:" + err.Error())
+	}
+
+	if proxyResult.Status == "stopped" || len(proxyResult.OpenResultList) == 0 {
+		return nil, ErrXrayPoolNotStarted
+	}
+
+	proxyInfos := make([]*XrayPoolProxyInfo, 0)
+	for index, result := range proxyResult.OpenResultList {
+
+		// 单个节点的信息
+		tmpProxyInfos := XrayPoolProxyInfo{
+			Index:          index,
+			ID:             NewProxyID(result.Name, result.ProtoModel, result.HttpPort, result.SocksPort),
+			Name:           result.Name,
+			ProtoModel:     result.ProtoModel,
+			HttpUrl:        httpPrefix + x.xrayPoolUrl + ":" + strconv.Itoa(result.HttpPort),
+			SocksUrl:       socksPrefix + x.xrayPoolUrl + ":" + strconv.Itoa(result.SocksPort),
+			FirTimeAccess:  true,
+			skipAccessTime: 0,
+			lastAccessTime: 0,
+		}
+		proxyInfos = append(proxyInfos, &tmpProxyInfos)
+	}
+
+	x.locker.Lock()
+	x.lbPort = proxyResult.LBPort
+	x.locker.Unlock()
+
+	return proxyInfos, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// ProxyEntry 一个代理节点的配置，Url 支持 http://、https://、socks5://、socks5h://
+type ProxyEntry struct {
+	Name string `json:"name"` // 为空的时候使用 Url 的 host
+	Url  string `json:"url"`
+}
+
+// StaticProxySource 固定的代理列表
+type StaticProxySource struct {
+	name       string
+	proxyInfos []*XrayPoolProxyInfo
+}
+
+// NewStaticProxySource 传入代理的 Url 列表，比如 http://127.0.0.1:10809、socks5://127.0.0.1:10808
+func NewStaticProxySource(name string, proxyUrls ...string) (*StaticProxySource, error) {
+
+	entries := make([]ProxyEntry, 0, len(proxyUrls))
+	for _, proxyUrl := range proxyUrls {
+		entries = append(entries, ProxyEntry{Url: proxyUrl})
+	}
+	return NewStaticProxySourceFromEntries(name, entries)
+}
+
+// NewStaticProxySourceFromEntries 传入代理节点的配置列表
+func NewStaticProxySourceFromEntries(name string, entries []ProxyEntry) (*StaticProxySource, error) {
+
+	proxyInfos, err := proxyEntriesToInfos(entries)
+	if err != nil {
+		return nil, err
+	}
+	return &StaticProxySource{name: name, proxyInfos: proxyInfos}, nil
+}
+
+func (s *StaticProxySource) Name() string {
+	return s.name
+}
+
+func (s *StaticProxySource) Fetch(_ context.Context) ([]*XrayPoolProxyInfo, error) {
+
+	// 每次都给出新的对象，节点的运行时状态由 Pool 维护
+	outProxyInfos := make([]*XrayPoolProxyInfo, 0, len(s.proxyInfos))
+	for _, info := range s.proxyInfos {
+		tmpInfo := *info
+		outProxyInfos = append(outProxyInfos, &tmpInfo)
+	}
+	return outProxyInfos, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// CompositeProxySource 合并多个来源的代理节点，相同 ID 的节点只保留第一个
+type CompositeProxySource struct {
+	sources []ProxySource
+	changed chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func NewCompositeProxySource(sources ...ProxySource) *CompositeProxySource {
+
+	c := &CompositeProxySource{
+		sources: sources,
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	// 转发子来源的变化通知
+	for _, source := range sources {
+		watcher, ok := source.(ProxySourceWatcher)
+		if ok == false {
+			continue
+		}
+		c.wg.Add(1)
+		go func(changed <-chan struct{}) {
+			defer c.wg.Done()
+			for {
+				select {
+				case <-c.stop:
+					return
+				case <-changed:
+					notifyChanged(c.changed)
+				}
+			}
+		}(watcher.Changed())
+	}
+	return c
+}
+
+func (c *CompositeProxySource) Name() string {
+
+	names := make([]string, 0, len(c.sources))
+	for _, source := range c.sources {
+		names = append(names, source.Name())
+	}
+	return "Composite[" + strings.Join(names, ", ") + "]"
+}
+
+// Fetch 某个来源失败的时候跳过，只有全部失败才返回错误
+func (c *CompositeProxySource) Fetch(ctx context.Context) ([]*XrayPoolProxyInfo, error) {
+
+	outProxyInfos := make([]*XrayPoolProxyInfo, 0)
+	foundIDs := make(map[string]bool)
+	var lastErr error
+	failedCount := 0
+	for _, source := range c.sources {
+		proxyInfos, err := source.Fetch(ctx)
+		if err != nil {
+			logger.Warningln("CompositeProxySource.Fetch", source.Name(), err)
+			lastErr = err
+			failedCount++
+			continue
+		}
+		for _, info := range proxyInfos {
+			if foundIDs[info.ID] == true {
+				continue
+			}
+			foundIDs[info.ID] = true
+			info.Index = len(outProxyInfos)
+			outProxyInfos = append(outProxyInfos, info)
+		}
+	}
+	if failedCount > 0 && failedCount == len(c.sources) {
+		return nil, lastErr
+	}
+
+	return outProxyInfos, nil
+}
+
+func (c *CompositeProxySource) Changed() <-chan struct{} {
+	return c.changed
+}
+
+// Close 停止转发，并关闭子来源
+func (c *CompositeProxySource) Close() error {
+
+	select {
+	case <-c.stop:
+		return nil
+	default:
+		close(c.stop)
+	}
+	c.wg.Wait()
+	for _, source := range c.sources {
+		if closer, ok := source.(io.Closer); ok == true {
+			_ = closer.Close()
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// NewProxyIDFromUrl 非 XrayPool 的代理，使用代理的 Url 作为稳定的标识
+func NewProxyIDFromUrl(proxyUrl string) string {
+
+	sum := sha1.Sum([]byte(strings.ToLower(strings.TrimSpace(proxyUrl))))
+	return hex.EncodeToString(sum[:])[:proxyIDLength]
+}
+
+// proxyEntriesToInfos 将配置转换为代理节点的信息
+func proxyEntriesToInfos(entries []ProxyEntry) ([]*XrayPoolProxyInfo, error) {
+
+	proxyInfos := make([]*XrayPoolProxyInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := proxyEntryToInfo(entry)
+		if err != nil {
+			return nil, err
+		}
+		info.Index = len(proxyInfos)
+		proxyInfos = append(proxyInfos, info)
+	}
+	return proxyInfos, nil
+}
+
+func proxyEntryToInfo(entry ProxyEntry) (*XrayPoolProxyInfo, error) {
+
+	rawUrl := strings.TrimSpace(entry.Url)
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, errors.New("proxy url parse error: " + err.Error())
+	}
+	if parsedUrl.Host == "" {
+		return nil, errors.New("proxy url host is empty: " + rawUrl)
+	}
+	info := &XrayPoolProxyInfo{
+		ID:            NewProxyIDFromUrl(rawUrl),
+		Name:          entry.Name,
+		ProtoModel:    strings.ToLower(parsedUrl.Scheme),
+		FirTimeAccess: true,
+	}
+	if info.Name == "" {
+		info.Name = parsedUrl.Host
+	}
+	switch info.ProtoModel {
+	case "http", "https":
+		info.HttpUrl = rawUrl
+	case "socks5", "socks5h":
+		info.SocksUrl = rawUrl
+	default:
+		return nil, errors.New("proxy url scheme not support: " + rawUrl)
+	}
+	return info, nil
+}
+
+// notifyChanged 不阻塞的发送变化通知，已经有未处理的通知就忽略
+func notifyChanged(changed chan struct{}) {
+	select {
+	case changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,144 @@
+package rod_helper
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FileProxySource 从本地文件读取代理列表，文件修改后会通知 Pool 刷新
+// 文件支持两种格式：
+//  1. 每行一个代理的 Url，# 开头的行是注释
+//  2. JSON 数组，元素可以是代理的 Url 字符串，也可以是 ProxyEntry
+type FileProxySource struct {
+	filePath string
+	changed  chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+// NewFileProxySource watchInterval 检测文件变化的间隔，小于等于 0 不检测
+func NewFileProxySource(filePath string, watchInterval time.Duration) *FileProxySource {
+
+	f := &FileProxySource{
+		filePath: filePath,
+		changed:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	if watchInterval > 0 {
+		// 在启动协程之前记录文件的状态，避免漏掉刚创建后的修改
+		lastModTime, lastSize := f.fileStat()
+		f.wg.Add(1)
+		go f.watch(watchInterval, lastModTime, lastSize)
+	}
+	return f
+}
+
+func (f *FileProxySource) Name() string {
+	return "File " + f.filePath
+}
+
+func (f *FileProxySource) Fetch(_ context.Context) ([]*XrayPoolProxyInfo, error) {
+
+	fileBytes, err := os.ReadFile(f.filePath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseProxyEntries(fileBytes)
+	if err != nil {
+		return nil, errors.New(f.filePath + " " + err.Error())
+	}
+	return proxyEntriesToInfos(entries)
+}
+
+func (f *FileProxySource) Changed() <-chan struct{} {
+	return f.changed
+}
+
+// Close 停止检测文件的变化
+func (f *FileProxySource) Close() error {
+	f.once.Do(func() {
+		close(f.stop)
+	})
+	f.wg.Wait()
+	return nil
+}
+
+// watch 通过文件的修改时间以及大小判断是否变化
+func (f *FileProxySource) watch(watchInterval time.Duration, lastModTime time.Time, lastSize int64) {
+
+	defer f.wg.Done()
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			nowModTime, nowSize := f.fileStat()
+			if nowModTime.Equal(lastModTime) == true && nowSize == lastSize {
+				continue
+			}
+			lastModTime, lastSize = nowModTime, nowSize
+			notifyChanged(f.changed)
+		}
+	}
+}
+
+func (f *FileProxySource) fileStat() (time.Time, int64) {
+	s, err := os.Stat(f.filePath)
+	if err != nil {
+		return time.Time{}, -1
+	}
+	return s.ModTime(), s.Size()
+}
+
+// parseProxyEntries 解析 JSON 或者按行的代理列表
+func parseProxyEntries(fileBytes []byte) ([]ProxyEntry, error) {
+
+	trimmed := bytes.TrimSpace(fileBytes)
+	if bytes.HasPrefix(trimmed, []byte("[")) == true {
+		// JSON 格式，每个元素可以是 Url 字符串，也可以是 ProxyEntry
+		rawEntries := make([]json.RawMessage, 0)
+		err := BytesToStruct(trimmed, &rawEntries)
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]ProxyEntry, 0, len(rawEntries))
+		for i, rawEntry := range rawEntries {
+			entry := ProxyEntry{}
+			if bytes.HasPrefix(bytes.TrimSpace(rawEntry), []byte(`"`)) == true {
+				err = BytesToStruct(rawEntry, &entry.Url)
+			} else {
+				err = BytesToStruct(rawEntry, &entry)
+			}
+			if err != nil {
+				return nil, errors.Wrapf(err, "proxy entry %d", i)
+			}
+			entries = append(entries, entry)
+		}
+		return entries, nil
+	}
+
+	entries := make([]ProxyEntry, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") == true {
+			continue
+		}
+		entries = append(entries, ProxyEntry{Url: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
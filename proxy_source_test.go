@@ -0,0 +1,69 @@
+package rod_helper
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompositeProxySource(t *testing.T) {
+
+	fileFPath := filepath.Join(t.TempDir(), "proxy_list.txt")
+	err := os.WriteFile(fileFPath, []byte("# comment\nsocks5://127.0.0.1:10808\nhttp://127.0.0.1:10809\n"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSource := NewFileProxySource(fileFPath, 10*time.Millisecond)
+	staticSource, err := NewStaticProxySource("static", "http://127.0.0.1:10809", "http://127.0.0.1:10810")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewCompositeProxySource(fileSource, staticSource)
+	defer func() {
+		_ = source.Close()
+	}()
+
+	proxyInfos, err := source.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 重复的 http://127.0.0.1:10809 只保留一个
+	if len(proxyInfos) != 3 || proxyInfos[0].SocksUrl == "" || proxyInfos[0].ProxyUrl() != "socks5://127.0.0.1:10808" {
+		t.Fatal("composite fetch error", len(proxyInfos))
+	}
+
+	err = os.WriteFile(fileFPath, []byte(`[{"name":"new","url":"http://127.0.0.1:10811"}]`), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-source.Changed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("file changed not notify")
+	}
+	proxyInfos, err = source.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxyInfos) != 3 || proxyInfos[0].Name != "new" {
+		t.Fatal("composite fetch after changed error", len(proxyInfos))
+	}
+}
+
+func TestParseProxyEntries(t *testing.T) {
+
+	// Url 字符串和 ProxyEntry 可以混在一个 JSON 数组中
+	entries, err := parseProxyEntries([]byte(`["http://127.0.0.1:10809", {"name":"b","url":"socks5://127.0.0.1:10808"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Url != "http://127.0.0.1:10809" || entries[1].Name != "b" || entries[1].Url != "socks5://127.0.0.1:10808" {
+		t.Fatal("parse mixed json proxy list error", entries)
+	}
+	_, err = parseProxyEntries([]byte(`["http://127.0.0.1:10809", 1]`))
+	if err == nil {
+		t.Fatal("invalid json proxy entry should return error")
+	}
+}
@@ -7,6 +7,9 @@ import (
 )
 
 func RandomSecondDuration(min, max int32) time.Duration {
+	if max <= min {
+		return time.Duration(min) * time.Second
+	}
 	tmp := src.Int31n(max-min) + min
 	return time.Duration(tmp) * time.Second
 }
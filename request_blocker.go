@@ -0,0 +1,167 @@
+package rod_helper
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/pkg/errors"
+)
+
+// BlockRules 页面中需要拦截的请求，满足任意一条的请求直接失败，不会发出
+type BlockRules struct {
+	ResourceTypes []proto.NetworkResourceType // 资源类型，比如 Image、Media、Font
+	UrlGlobs      []string                    // Url 通配符，同 proto.FetchRequestPattern.URLPattern
+	UrlRegexes    []string                    // Url 正则表达式
+	Domains       []string                    // 域名，同时匹配子域名，比如 example.com 也匹配 ads.example.com
+	FilterLists   []*FilterList               // EasyList 格式的规则，见 LoadFilterList
+}
+
+// BlockRequest 判断是否需要拦截的请求
+type BlockRequest struct {
+	Url          string
+	SourceUrl    string // 发起请求的页面，FilterList 中 $third-party、$domain= 的规则需要，为空的时候这些规则不匹配
+	ResourceType proto.NetworkResourceType
+	MainFrame    bool // 主框架的 Document 请求，也就是页面本身，FilterList 中只有 $document 的规则会匹配
+}
+
+// RequestBlocker 根据 BlockRules 判断一个请求是否需要拦截，创建之后只读，可以在多个页面中共用
+type RequestBlocker struct {
+	resourceTypes map[proto.NetworkResourceType]bool
+	urlRegexes    []*regexp.Regexp
+	domains       map[string]bool
+	filterLists   []*FilterList
+}
+
+// NewRequestBlocker 正则表达式有错误的时候返回错误
+func NewRequestBlocker(rules BlockRules) (*RequestBlocker, error) {
+
+	r := &RequestBlocker{
+		resourceTypes: make(map[proto.NetworkResourceType]bool),
+		urlRegexes:    make([]*regexp.Regexp, 0, len(rules.UrlGlobs)+len(rules.UrlRegexes)),
+		domains:       make(map[string]bool),
+		filterLists:   rules.FilterLists,
+	}
+	for _, resourceType := range rules.ResourceTypes {
+		r.resourceTypes[resourceType] = true
+	}
+	r.urlRegexes = append(r.urlRegexes, globsToRegex(rules.UrlGlobs)...)
+	for _, urlRegex := range rules.UrlRegexes {
+		reg, err := regexp.Compile(urlRegex)
+		if err != nil {
+			return nil, errors.Wrap(err, "NewRequestBlocker compile "+urlRegex)
+		}
+		r.urlRegexes = append(r.urlRegexes, reg)
+	}
+	for _, domain := range rules.Domains {
+		domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain != "" {
+			r.domains[domain] = true
+		}
+	}
+	return r, nil
+}
+
+// Match 这个请求是否需要拦截，不知道发起请求的页面，见 MatchRequest
+func (r *RequestBlocker) Match(reqUrl string, resourceType proto.NetworkResourceType) bool {
+	return r.MatchRequest(BlockRequest{Url: reqUrl, ResourceType: resourceType})
+}
+
+// MatchRequest 这个请求是否需要拦截，FilterLists 中的例外规则只对 FilterLists 本身生效
+func (r *RequestBlocker) MatchRequest(req BlockRequest) bool {
+
+	if r.resourceTypes[req.ResourceType] == true {
+		return true
+	}
+	if len(r.domains) > 0 && r.matchDomain(req.Url) == true {
+		return true
+	}
+	for _, reg := range r.urlRegexes {
+		if reg.MatchString(req.Url) == true {
+			return true
+		}
+	}
+	for _, filterList := range r.filterLists {
+		if filterList.Match(req) == true {
+			return true
+		}
+	}
+	return false
+}
+
+// newBlockRequest 发起请求的页面优先使用 Referer，没有的时候使用主框架最近加载的页面
+func newBlockRequest(e *proto.FetchRequestPaused, mainFrameID proto.PageFrameID, documentUrl string) BlockRequest {
+
+	req := BlockRequest{
+		Url:          e.Request.URL,
+		SourceUrl:    documentUrl,
+		ResourceType: e.ResourceType,
+		MainFrame:    e.ResourceType == proto.NetworkResourceTypeDocument && e.FrameID == mainFrameID,
+	}
+	for k, v := range e.Request.Headers {
+		if strings.EqualFold(k, "Referer") == true && v.String() != "" {
+			req.SourceUrl = v.String()
+			break
+		}
+	}
+	if req.MainFrame == true {
+		req.SourceUrl = ""
+	}
+	return req
+}
+
+// matchDomain 从完整的域名开始，逐级去掉最左边的一段去匹配
+func (r *RequestBlocker) matchDomain(reqUrl string) bool {
+
+	u, err := url.Parse(reqUrl)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for host != "" {
+		if r.domains[host] == true {
+			return true
+		}
+		index := strings.Index(host, ".")
+		if index < 0 {
+			break
+		}
+		host = host[index+1:]
+	}
+	return false
+}
+
+// LoadDomainList 读取域名列表文件，一行一个域名，忽略空行以及 # 之后的注释
+// 也兼容 hosts 格式，比如 0.0.0.0 ads.example.com
+func LoadDomainList(fPath string) ([]string, error) {
+
+	f, err := os.Open(fPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "LoadDomainList")
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	domains := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index := strings.Index(line, "#"); index >= 0 {
+			line = line[:index]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		domains = append(domains, fields[len(fields)-1])
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "LoadDomainList")
+	}
+	return domains, nil
+}
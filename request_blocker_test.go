@@ -0,0 +1,60 @@
+package rod_helper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+func TestRequestBlocker(t *testing.T) {
+
+	tmpDir := t.TempDir()
+	fPath := filepath.Join(tmpDir, "domains.txt")
+	err := os.WriteFile(fPath, []byte("# 广告\nads.test\n0.0.0.0 tracker.test # hosts\n\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	domains, err := LoadDomainList(fPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 2 || domains[1] != "tracker.test" {
+		t.Fatal("load domain list error", domains)
+	}
+
+	_, err = NewRequestBlocker(BlockRules{UrlRegexes: []string{"("}})
+	if err == nil {
+		t.Fatal("invalid regex should return error")
+	}
+	blocker, err := NewRequestBlocker(BlockRules{
+		ResourceTypes: []proto.NetworkResourceType{proto.NetworkResourceTypeImage},
+		UrlGlobs:      []string{"*.woff2"},
+		UrlRegexes:    []string{`/collect\?`},
+		Domains:       domains,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		url          string
+		resourceType proto.NetworkResourceType
+		blocked      bool
+	}{
+		{"https://www.page.test/logo.png", proto.NetworkResourceTypeImage, true},
+		{"https://www.page.test/app.js", proto.NetworkResourceTypeScript, false},
+		{"https://www.page.test/font.woff2", proto.NetworkResourceTypeFont, true},
+		{"https://www.page.test/collect?id=1", proto.NetworkResourceTypeXHR, true},
+		{"https://ads.test/a.js", proto.NetworkResourceTypeScript, true},
+		{"https://cdn.ads.test:8443/a.js", proto.NetworkResourceTypeScript, true},
+		{"https://notads.test/a.js", proto.NetworkResourceTypeScript, false},
+		{"https://tracker.test.page.test/a.js", proto.NetworkResourceTypeScript, false},
+	}
+	for _, c := range cases {
+		if blocker.Match(c.url, c.resourceType) != c.blocked {
+			t.Fatal("request blocker match error", c.url, c.blocked)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package rod_helper
 
 import (
+	"context"
 	"github.com/WQGroup/logger"
 	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/launcher"
@@ -8,7 +9,6 @@ import (
 	"github.com/pkg/errors"
 	"net/http"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
@@ -16,10 +16,25 @@ import (
 
 func NewBrowserBase(tmpRootFolder, browserFPath, httpProxyURL string, loadAdblock, loadPic bool) (*BrowserInfo, error) {
 
+	var extensionDirs []string
+	if loadAdblock == true {
+		// 这里要写的是缓存的根目录，不是 Browser 的目录
+		err := rod.Try(func() {
+			extensionDirs = []string{GetADBlockLocalPath(tmpRootFolder, httpProxyURL)}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewBrowserBaseWithExtensions(tmpRootFolder, browserFPath, httpProxyURL, extensionDirs, loadPic)
+}
+
+// NewBrowserBaseWithExtensions 同 NewBrowserBase，同时加载 extensionDirs 中所有解压好的插件，见 ExtensionManager.LocalPaths
+func NewBrowserBaseWithExtensions(tmpRootFolder, browserFPath, httpProxyURL string, extensionDirs []string, loadPic bool) (*BrowserInfo, error) {
+
 	var err error
 	// 随机的 rod 子文件夹名称
-	nowUserData := filepath.Join(GetRodTmpRootFolder(tmpRootFolder), RandStringBytesMaskImprSrcSB(20))
-	err = os.MkdirAll(nowUserData, os.ModePerm)
+	nowUserData, err := NewCacheLayout(tmpRootFolder).NewUserDataDir()
 	if err != nil {
 		return nil, err
 	}
@@ -29,14 +44,15 @@ func NewBrowserBase(tmpRootFolder, browserFPath, httpProxyURL string, loadAdbloc
 
 		var nowLancher *launcher.Launcher
 		purl := ""
-		if loadAdblock == true {
+		if len(extensionDirs) > 0 {
 
+			extensionDirList := strings.Join(extensionDirs, ",")
 			nowLancher = launcher.New().
 				Delete("disable-extensions").
-				// 这里要写的是缓存的根目录，不是 Browser 的目录
-				Set("load-extension", GetADBlockLocalPath(tmpRootFolder, httpProxyURL)).
+				Set("load-extension", extensionDirList).
+				Set("disable-extensions-except", extensionDirList).
 				Proxy(httpProxyURL).
-				Headless(false). // 插件模式需要设置这个
+				Headless(false). // 插件模式需要设置这个，无头模式下拦截广告见 FilterList
 				UserDataDir(nowUserData)
 			//XVFB("--server-num=5", "--server-args=-screen 0 1600x900x16").
 			//XVFB("-ac :99", "-screen 0 1280x1024x16").
@@ -58,6 +74,7 @@ func NewBrowserBase(tmpRootFolder, browserFPath, httpProxyURL string, loadAdbloc
 		browser = rod.New().ControlURL(purl).MustConnect()
 	})
 	if err != nil {
+		ReleaseUserDataDir(nowUserData)
 		_ = os.RemoveAll(nowUserData)
 		return nil, err
 	}
@@ -80,6 +97,11 @@ func NewPage(browser *rod.Browser) (*rod.Page, error) {
 }
 
 func PageNavigate(page *rod.Page, randomUA bool, desURL string, timeOut time.Duration) (*rod.Page, *proto.NetworkResponseReceived, error) {
+	return PageNavigateWithContext(context.Background(), page, randomUA, desURL, timeOut)
+}
+
+// PageNavigateWithContext 同 PageNavigate，ctx 结束的时候会中断导航，返回 ctx.Err()
+func PageNavigateWithContext(ctx context.Context, page *rod.Page, randomUA bool, desURL string, timeOut time.Duration) (*rod.Page, *proto.NetworkResponseReceived, error) {
 
 	if randomUA == true {
 		ua := RandomUserAgent()
@@ -94,11 +116,15 @@ func PageNavigate(page *rod.Page, randomUA bool, desURL string, timeOut time.Dur
 		}
 	}
 	var e proto.NetworkResponseReceived
-	wait := page.WaitEvent(&e)
+	ctxPage := page.Context(ctx)
+	wait := ctxPage.WaitEvent(&e)
 	err := rod.Try(func() {
-		page.Timeout(timeOut).MustNavigate(desURL)
+		ctxPage.Timeout(timeOut).MustNavigate(desURL)
 		wait()
 	})
+	if ctx.Err() != nil {
+		return page, &e, ctx.Err()
+	}
 	if err != nil {
 		return page, &e, err
 	}
@@ -110,6 +136,7 @@ func PageNavigate(page *rod.Page, randomUA bool, desURL string, timeOut time.Dur
 }
 
 // NewPageHijackRouter 需要手动启动 休要开启协程 Run() 和 释放 Stop
+// 每个请求都通过 httpClient 完整读取，需要复用代理连接、选择拦截哪些请求的见 NewPageHijacker
 func NewPageHijackRouter(page *rod.Page, loadBody bool, httpClient *http.Client) *rod.HijackRouter {
 	router := page.HijackRequests()
 	router.MustAdd("*", func(ctx *rod.Hijack) {
@@ -121,6 +148,12 @@ func NewPageHijackRouter(page *rod.Page, loadBody bool, httpClient *http.Client)
 	return router
 }
 
+// NewPageBlockRouter 不需要走代理的页面也可以拦截请求，匹配 blocker 的请求直接失败，其他的由浏览器直接发出
+// 同 NewPageHijackRouter，需要开启协程 Run() 和 释放 Stop，已经使用了 NewPageHijacker 的页面设置 HijackConfig.Blocker 即可
+func NewPageBlockRouter(page *rod.Page, blocker *RequestBlocker) (*PageHijacker, error) {
+	return NewPageHijacker(page, "", HijackConfig{PassUrls: []string{"*"}, Blocker: blocker})
+}
+
 // ContainedWords 返回的页面是否包含关键词
 func ContainedWords(pageContent string, failedWords []string) (bool, int) {
 
@@ -204,14 +237,6 @@ func PageStatusCodeCheck(e *proto.NetworkResponseReceived, statusCodeInfo []Stat
 }
 
 func GetPublicIP(page *rod.Page, timeOut time.Duration, customDectIPSites []string) (string, error) {
-	defPublicIPSites := []string{
-		"https://myip.biturl.top/",
-		"https://ip4.seeip.org/",
-		"https://ipecho.net/plain",
-		"https://api-ipv4.ip.sb/ip",
-		"https://api.ipify.org/",
-		"http://myexternalip.com/raw",
-	}
 
 	customPublicIPSites := make([]string, 0)
 	if customDectIPSites != nil {
@@ -241,12 +266,19 @@ func GetPublicIP(page *rod.Page, timeOut time.Duration, customDectIPSites []stri
 
 // HasPageLoaded 通过一个 Element 的 XPath 判断是否页面加载完毕
 func HasPageLoaded(page *rod.Page, targetElementXPaths []string, timeOut int) bool {
+	pageLoaded, _ := HasPageLoadedWithContext(context.Background(), page, targetElementXPaths, timeOut)
+	return pageLoaded
+}
 
+// HasPageLoadedWithContext 同 HasPageLoaded，ctx 结束的时候立即返回 ctx.Err()
+func HasPageLoadedWithContext(ctx context.Context, page *rod.Page, targetElementXPaths []string, timeOut int) (bool, error) {
+
+	ctxPage := page.Context(ctx)
 	for {
 		for _, targetElementXPath := range targetElementXPaths {
-			foundEle, _, _ := page.Timeout(300 * time.Millisecond).HasX(targetElementXPath)
+			foundEle, _, _ := ctxPage.Timeout(300 * time.Millisecond).HasX(targetElementXPath)
 			if foundEle == true {
-				return true
+				return true, nil
 			}
 		}
 		// Timeout check
@@ -254,15 +286,29 @@ func HasPageLoaded(page *rod.Page, targetElementXPaths []string, timeOut int) bo
 			break
 		}
 		timeOut--
-		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(1 * time.Second):
+		}
 	}
-	return false
+	return false, nil
 }
 
 const regMatchIP = `(?m)((25[0-5]|2[0-4]\d|((1\d{2})|([1-9]?\d))).){3}(25[0-5]|2[0-4]\d|((1\d{2})|([1-9]?\d)))`
 
 var ReMatchIP = regexp.MustCompile(regMatchIP)
 
+// defPublicIPSites 获取公网 IP 默认使用的网站
+var defPublicIPSites = []string{
+	"https://myip.biturl.top/",
+	"https://ip4.seeip.org/",
+	"https://ipecho.net/plain",
+	"https://api-ipv4.ip.sb/ip",
+	"https://api.ipify.org/",
+	"http://myexternalip.com/raw",
+}
+
 type BrowserInfo struct {
 	Browser     *rod.Browser // 浏览器
 	UserDataDir string       // 这里实例的缓存文件夹
@@ -282,6 +328,7 @@ func (bi *BrowserInfo) Close() {
 	}
 	if needClearFolder != "" {
 
+		ReleaseUserDataDir(needClearFolder)
 		if IsDir(needClearFolder) == false {
 			return
 		}
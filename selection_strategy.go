@@ -0,0 +1,211 @@
+package rod_helper
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ProxyHealth 一个节点在某个 KeyName 下的健康信息，由 Filter 的结果以及租约反馈的实际请求结果更新
+type ProxyHealth struct {
+	Score       float64       // 0 ~ 1，越大越健康
+	EWMALatency time.Duration // 成功请求耗时的指数加权平均，为 0 表示还没有数据
+	Samples     int64         // 记录的次数
+}
+
+// HealthTracker 以 (节点, KeyName) 为单位记录健康信息
+type HealthTracker struct {
+	alpha   float64
+	healths map[circuitKey]*ProxyHealth
+	locker  sync.Mutex
+}
+
+// NewHealthTracker alpha 是指数加权平均中新样本的权重，范围 (0, 1]，越大越看重最近的结果
+func NewHealthTracker(alpha float64) *HealthTracker {
+
+	if alpha <= 0 || alpha > 1 {
+		alpha = defHealthAlpha
+	}
+	return &HealthTracker{
+		alpha:   alpha,
+		healths: make(map[circuitKey]*ProxyHealth),
+	}
+}
+
+// RecordSuccess 记录一次成功以及耗时
+func (h *HealthTracker) RecordSuccess(proxyID, keyName string, latency time.Duration) {
+
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	health := h.getHealth(proxyID, keyName)
+	health.Score = health.Score*(1-h.alpha) + h.alpha
+	if latency > 0 {
+		if health.EWMALatency == 0 {
+			health.EWMALatency = latency
+		} else {
+			health.EWMALatency = time.Duration(float64(health.EWMALatency)*(1-h.alpha) + float64(latency)*h.alpha)
+		}
+	}
+	health.Samples++
+}
+
+// RecordFailure 记录一次失败
+func (h *HealthTracker) RecordFailure(proxyID, keyName string) {
+
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	health := h.getHealth(proxyID, keyName)
+	health.Score = health.Score * (1 - h.alpha)
+	health.Samples++
+}
+
+// Get 获取健康信息，没有记录过的节点给出中间的分数
+func (h *HealthTracker) Get(proxyID, keyName string) ProxyHealth {
+
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	health, found := h.healths[circuitKey{proxyID, keyName}]
+	if found == false {
+		return ProxyHealth{Score: defHealthScore}
+	}
+	return *health
+}
+
+func (h *HealthTracker) getHealth(proxyID, keyName string) *ProxyHealth {
+
+	nowKey := circuitKey{proxyID, keyName}
+	health, found := h.healths[nowKey]
+	if found == false {
+		health = &ProxyHealth{Score: defHealthScore}
+		h.healths[nowKey] = health
+	}
+	return health
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// SelectionStrategy 从当前可用的节点中选择一个的策略
+type SelectionStrategy interface {
+	// Name 策略的名称
+	Name() string
+	// Select 传入的 candidates 都是当前可以使用的节点（不为空），返回选中的节点在 candidates 中的位置
+	Select(keyName string, candidates []*XrayPoolProxyInfo, health func(proxyInfo *XrayPoolProxyInfo) ProxyHealth) int
+}
+
+// RoundRobinStrategy 轮询
+type RoundRobinStrategy struct {
+	cursors map[string]int
+	locker  sync.Mutex
+}
+
+func NewRoundRobinStrategy() *RoundRobinStrategy {
+	return &RoundRobinStrategy{cursors: make(map[string]int)}
+}
+
+func (s *RoundRobinStrategy) Name() string {
+	return "RoundRobin"
+}
+
+func (s *RoundRobinStrategy) Select(keyName string, candidates []*XrayPoolProxyInfo, _ func(proxyInfo *XrayPoolProxyInfo) ProxyHealth) int {
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	selected := s.cursors[keyName] % len(candidates)
+	s.cursors[keyName] = selected + 1
+	return selected
+}
+
+// LeastRecentlyUsedStrategy 选择最久没有使用的节点
+type LeastRecentlyUsedStrategy struct{}
+
+func NewLeastRecentlyUsedStrategy() *LeastRecentlyUsedStrategy {
+	return &LeastRecentlyUsedStrategy{}
+}
+
+func (s *LeastRecentlyUsedStrategy) Name() string {
+	return "LeastRecentlyUsed"
+}
+
+func (s *LeastRecentlyUsedStrategy) Select(_ string, candidates []*XrayPoolProxyInfo, _ func(proxyInfo *XrayPoolProxyInfo) ProxyHealth) int {
+
+	selected := 0
+	for i, proxyInfo := range candidates {
+		if proxyInfo.GetLastAccessTime() < candidates[selected].GetLastAccessTime() {
+			selected = i
+		}
+	}
+	return selected
+}
+
+// WeightedRandomStrategy 按健康分数加权随机
+type WeightedRandomStrategy struct {
+	random *rand.Rand
+	locker sync.Mutex
+}
+
+func NewWeightedRandomStrategy() *WeightedRandomStrategy {
+	return &WeightedRandomStrategy{random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *WeightedRandomStrategy) Name() string {
+	return "WeightedRandom"
+}
+
+func (s *WeightedRandomStrategy) Select(_ string, candidates []*XrayPoolProxyInfo, health func(proxyInfo *XrayPoolProxyInfo) ProxyHealth) int {
+
+	weights := make([]float64, len(candidates))
+	totalWeight := 0.0
+	for i, proxyInfo := range candidates {
+		// 分数很低的节点也保留一点机会，这样恢复了还能被发现
+		weights[i] = health(proxyInfo).Score
+		if weights[i] < minSelectWeight {
+			weights[i] = minSelectWeight
+		}
+		totalWeight += weights[i]
+	}
+
+	s.locker.Lock()
+	target := s.random.Float64() * totalWeight
+	s.locker.Unlock()
+	for i, weight := range weights {
+		target -= weight
+		if target < 0 {
+			return i
+		}
+	}
+	return len(candidates) - 1
+}
+
+// LowestLatencyStrategy 选择 EWMA 耗时最低的节点，还没有耗时数据的节点优先，这样每个节点都会被测到
+type LowestLatencyStrategy struct{}
+
+func NewLowestLatencyStrategy() *LowestLatencyStrategy {
+	return &LowestLatencyStrategy{}
+}
+
+func (s *LowestLatencyStrategy) Name() string {
+	return "LowestLatency"
+}
+
+func (s *LowestLatencyStrategy) Select(_ string, candidates []*XrayPoolProxyInfo, health func(proxyInfo *XrayPoolProxyInfo) ProxyHealth) int {
+
+	selected := 0
+	selectedLatency := health(candidates[0]).EWMALatency
+	for i := 1; i < len(candidates); i++ {
+		if selectedLatency == 0 {
+			break
+		}
+		nowLatency := health(candidates[i]).EWMALatency
+		if nowLatency < selectedLatency {
+			selected = i
+			selectedLatency = nowLatency
+		}
+	}
+	return selected
+}
+
+const (
+	defHealthAlpha  = 0.3
+	defHealthScore  = 0.5
+	minSelectWeight = 0.01
+)
@@ -0,0 +1,39 @@
+package rod_helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectionStrategy(t *testing.T) {
+
+	a := &XrayPoolProxyInfo{ID: "a", lastAccessTime: 20}
+	b := &XrayPoolProxyInfo{ID: "b", lastAccessTime: 10}
+	candidates := []*XrayPoolProxyInfo{a, b}
+	tracker := NewHealthTracker(0.5)
+	tracker.RecordSuccess("a", "imdb", 300*time.Millisecond)
+	tracker.RecordSuccess("b", "imdb", 100*time.Millisecond)
+	tracker.RecordFailure("a", "imdb")
+	health := func(proxyInfo *XrayPoolProxyInfo) ProxyHealth {
+		return tracker.Get(proxyInfo.ID, "imdb")
+	}
+	if tracker.Get("a", "imdb").Score >= tracker.Get("b", "imdb").Score {
+		t.Fatal("failed node should have lower score")
+	}
+
+	roundRobin := NewRoundRobinStrategy()
+	if roundRobin.Select("imdb", candidates, health) != 0 || roundRobin.Select("imdb", candidates, health) != 1 ||
+		roundRobin.Select("imdb", candidates, health) != 0 {
+		t.Fatal("round robin error")
+	}
+	if NewLeastRecentlyUsedStrategy().Select("imdb", candidates, health) != 1 {
+		t.Fatal("least recently used error")
+	}
+	if NewLowestLatencyStrategy().Select("imdb", candidates, health) != 1 {
+		t.Fatal("lowest latency error")
+	}
+	selected := NewWeightedRandomStrategy().Select("imdb", candidates, health)
+	if selected < 0 || selected >= len(candidates) {
+		t.Fatal("weighted random out of range")
+	}
+}
@@ -0,0 +1,230 @@
+package rod_helper
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// StateStore 保存 Pool 的过滤结果、节点状态、报告以及 adblock 的缓存信息，key 是类似 proxy_cache_xxx.json 的名称
+type StateStore interface {
+	// Save 保存 value 的 json
+	Save(key string, value interface{}) error
+	// Load 读取到 value 中，value 必须是指针，不存在的时候返回 ErrStateNotFound
+	Load(key string, value interface{}) error
+	// Delete 删除，不存在的时候忽略
+	Delete(key string) error
+	// List 以 prefix 开头的 key，按名称排序
+	List(prefix string) ([]string, error)
+}
+
+var ErrStateNotFound = errors.New("state not found")
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// MemoryStateStore 只保存在内存中，重启后丢失，用于测试或者不需要缓存的场景
+type MemoryStateStore struct {
+	locker sync.Mutex
+	states map[string][]byte
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{states: make(map[string][]byte)}
+}
+
+func (m *MemoryStateStore) Save(key string, value interface{}) error {
+
+	// 保存 json 而不是对象，避免之后的修改影响到保存的内容
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.states[key] = jsonBytes
+	return nil
+}
+
+func (m *MemoryStateStore) Load(key string, value interface{}) error {
+
+	m.locker.Lock()
+	jsonBytes, found := m.states[key]
+	m.locker.Unlock()
+	if found == false {
+		return ErrStateNotFound
+	}
+	return BytesToStruct(jsonBytes, value)
+}
+
+func (m *MemoryStateStore) Delete(key string) error {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	delete(m.states, key)
+	return nil
+}
+
+func (m *MemoryStateStore) List(prefix string) ([]string, error) {
+
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	keys := make([]string, 0)
+	for key := range m.states {
+		if strings.HasPrefix(key, prefix) == true {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+
+// FileStateStore 每个 key 保存为目录中的一个文件
+// 先写临时文件再重命名，读写的时候都会加上目录的文件锁，多个进程共用一个目录也不会读到写了一半的文件
+type FileStateStore struct {
+	dirPath string
+}
+
+func NewFileStateStore(dirPath string) *FileStateStore {
+	return &FileStateStore{dirPath: dirPath}
+}
+
+func (f *FileStateStore) DirPath() string {
+	return f.dirPath
+}
+
+func (f *FileStateStore) Save(key string, value interface{}) error {
+
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(f.dirPath, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	unlock, err := f.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return writeFileAtomic(filepath.Join(f.dirPath, key), jsonBytes)
+}
+
+func (f *FileStateStore) Load(key string, value interface{}) error {
+
+	fPath := filepath.Join(f.dirPath, key)
+	if IsFile(fPath) == false {
+		return ErrStateNotFound
+	}
+	unlock, err := f.lock(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	jsonBytes, err := os.ReadFile(fPath)
+	if err != nil {
+		if os.IsNotExist(err) == true {
+			return ErrStateNotFound
+		}
+		return err
+	}
+	return BytesToStruct(jsonBytes, value)
+}
+
+func (f *FileStateStore) Delete(key string) error {
+
+	unlock, err := f.lock(true)
+	if err != nil {
+		if os.IsNotExist(err) == true {
+			return nil
+		}
+		return err
+	}
+	defer unlock()
+	err = os.Remove(filepath.Join(f.dirPath, key))
+	if err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+	// 之前的版本每个 key 有一个锁文件，顺便清理掉
+	_ = os.Remove(filepath.Join(f.dirPath, "."+key+".lock"))
+	return nil
+}
+
+func (f *FileStateStore) List(prefix string) ([]string, error) {
+
+	entries, err := os.ReadDir(f.dirPath)
+	if err != nil {
+		if os.IsNotExist(err) == true {
+			return make([]string, 0), nil
+		}
+		return nil, err
+	}
+	keys := make([]string, 0)
+	for _, entry := range entries {
+		// 临时文件、锁文件都以 . 开头
+		if entry.IsDir() == true || strings.HasPrefix(entry.Name(), ".") == true {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), prefix) == true {
+			keys = append(keys, entry.Name())
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// lock 加上目录的文件锁，exclusive 为 false 的时候是共享锁，返回解锁的函数
+// 整个目录共用一个锁文件，报告这类每次都是新 key 的也不会留下越来越多的锁文件
+func (f *FileStateStore) lock(exclusive bool) (func(), error) {
+
+	lockFile, err := os.OpenFile(filepath.Join(f.dirPath, fileStateStoreLockName), os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFileHandle(lockFile, exclusive)
+	if err != nil {
+		_ = lockFile.Close()
+		return nil, errors.New("lock state file failed: " + err.Error())
+	}
+	return func() {
+		_ = unlockFileHandle(lockFile)
+		_ = lockFile.Close()
+	}, nil
+}
+
+// writeFileAtomic 先写到同一个目录中的临时文件，再重命名为目标文件
+func writeFileAtomic(fPath string, data []byte) error {
+
+	fPath = filepath.FromSlash(fPath)
+	tmpFile, err := os.CreateTemp(filepath.Dir(fPath), "."+filepath.Base(fPath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpFPath := tmpFile.Name()
+	_, err = tmpFile.Write(data)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFPath, fPath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFPath)
+		return err
+	}
+	return nil
+}
+
+// fileStateStoreLockName 目录的锁文件，以 . 开头，List 的时候会跳过
+const fileStateStoreLockName = ".state.lock"
@@ -0,0 +1,106 @@
+package rod_helper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestStateStore(t *testing.T) {
+
+	type testState struct {
+		Name  string
+		Value []int
+	}
+	tmpDir, err := os.MkdirTemp("", "state_store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tmpDir)
+	}()
+
+	stores := map[string]StateStore{
+		"memory": NewMemoryStateStore(),
+		"file":   NewFileStateStore(tmpDir),
+	}
+	for storeName, store := range stores {
+
+		err = store.Load("not_exist.json", &testState{})
+		if errors.Is(err, ErrStateNotFound) == false {
+			t.Fatal(storeName, "load not exist state error", err)
+		}
+		err = store.Save("report_a_1.json", testState{Name: "a", Value: []int{1, 2}})
+		if err != nil {
+			t.Fatal(storeName, err)
+		}
+		err = store.Save("report_a_2.json", testState{Name: "a"})
+		if err != nil {
+			t.Fatal(storeName, err)
+		}
+		err = store.Save("other.json", testState{Name: "other"})
+		if err != nil {
+			t.Fatal(storeName, err)
+		}
+		loaded := testState{}
+		err = store.Load("report_a_1.json", &loaded)
+		if err != nil || loaded.Name != "a" || len(loaded.Value) != 2 {
+			t.Fatal(storeName, "load state error", err, loaded)
+		}
+		keys, err := store.List("report_a_")
+		if err != nil || len(keys) != 2 || keys[0] != "report_a_1.json" {
+			t.Fatal(storeName, "list state error", err, keys)
+		}
+		err = store.Delete("report_a_1.json")
+		if err != nil {
+			t.Fatal(storeName, err)
+		}
+		err = store.Delete("report_a_1.json")
+		if err != nil {
+			t.Fatal(storeName, "delete twice error", err)
+		}
+		keys, _ = store.List("report_a_")
+		if len(keys) != 1 {
+			t.Fatal(storeName, "delete state error", keys)
+		}
+
+		// 同时读写，读到的一定是完整的内容
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				saveErr := store.Save("shared.json", testState{Name: fmt.Sprintf("writer-%d", i), Value: make([]int, 1000)})
+				if saveErr != nil {
+					t.Error(storeName, saveErr)
+				}
+			}(i)
+			go func() {
+				defer wg.Done()
+				nowState := testState{}
+				loadErr := store.Load("shared.json", &nowState)
+				if loadErr != nil && errors.Is(loadErr, ErrStateNotFound) == false {
+					t.Error(storeName, "load while saving error", loadErr)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	// 整个目录只有一个锁文件，删除了的 key 不会留下锁文件
+	entries, err := os.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hiddenNames := make([]string, 0)
+	for _, entry := range entries {
+		if entry.Name()[0] == '.' {
+			hiddenNames = append(hiddenNames, entry.Name())
+		}
+	}
+	if len(hiddenNames) != 1 || hiddenNames[0] != fileStateStoreLockName {
+		t.Fatal("file state store lock files error", hiddenNames)
+	}
+}
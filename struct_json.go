@@ -7,26 +7,14 @@ import (
 	"path/filepath"
 )
 
-// ToFile 注意传入的不是指针
+// ToFile 注意传入的不是指针，先写临时文件再重命名，不会留下写了一半的文件
 func ToFile(srcJsonFileFPath string, input interface{}) error {
 	jsonBytes, err := json.Marshal(input)
 	if err != nil {
 		return err
 	}
 
-	file, err := os.Create(filepath.FromSlash(srcJsonFileFPath))
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = file.Close()
-	}()
-
-	_, err = file.Write(jsonBytes)
-	if err != nil {
-		return err
-	}
-	return nil
+	return writeFileAtomic(srcJsonFileFPath, jsonBytes)
 }
 
 // ToStruct 传入的必须是指针